@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// ModelConfig 模型配置，key为客户端请求中使用的模型名称
+type ModelConfig struct {
+	// Model 实际的上游模型，配置后该名称作为别名使用
+	Model string `yaml:"model"`
+	// System 请求中没有system消息时使用的默认系统提示词
+	System string `yaml:"system"`
+	// Options 默认的Ollama选项，如num_ctx、temperature，请求中已设置的值优先
+	Options map[string]interface{} `yaml:"options"`
+	// Reasoning 推理内容的处理方式：passthrough（默认）/ extract / strip
+	Reasoning string `yaml:"reasoning"`
+	// ContextLength 模型的上下文长度，未配置时通过/api/show查询
+	ContextLength int `yaml:"context_length"`
+	// ContextOverflow 请求超出上下文长度时的处理方式：error（默认）/ truncate
+	ContextOverflow string `yaml:"context_overflow"`
+}
+
+// resolveModel 解析模型名称，返回上游模型名以及该名称对应的模型配置
+func resolveModel(config *Config, name string) (string, ModelConfig) {
+	settings := config.Models[name]
+	if settings.Model == "" {
+		return name, settings
+	}
+	return settings.Model, settings
+}
+
+// withDefaultSystem 请求中没有system消息时插入默认系统提示词
+func withDefaultSystem(messages []models.ChatMessage, system string) []models.ChatMessage {
+	if system == "" {
+		return messages
+	}
+	for _, message := range messages {
+		if message.Role == "system" {
+			return messages
+		}
+	}
+	return append([]models.ChatMessage{{Role: "system", Content: system}}, messages...)
+}
+
+// aliasModelData 返回所有别名对应的模型列表项，创建时间与上游模型一致
+func aliasModelData(config *Config, upstream []models.ModelData) []models.ModelData {
+	created := make(map[string]int64)
+	for _, model := range upstream {
+		created[model.ID] = model.Created
+	}
+
+	var names []string
+	for name, settings := range config.Models {
+		if settings.Model != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var data []models.ModelData
+	for _, name := range names {
+		target := config.Models[name].Model
+		data = append(data, models.NewAliasModelData(name, target, created[withDefaultTag(target)]))
+	}
+	return data
+}
+
+// withDefaultTag 模型名称没有tag时补充:latest，与Ollama的模型列表保持一致
+func withDefaultTag(model string) string {
+	if strings.Contains(model, ":") {
+		return model
+	}
+	return model + ":latest"
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// aliasConfig 将gpt-4o-mini映射到qwen2.5:7b的代理配置
+func aliasConfig(upstream string) string {
+	return testConfig(upstream) + `models:
+  gpt-4o-mini:
+    model: "qwen2.5:7b"
+    system: "be brief"
+    options:
+      num_ctx: 8192
+      temperature: 0.7
+      num_gpu: 1
+      mirostat: 2
+`
+}
+
+func TestModelAlias(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, aliasConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-4o-mini","stream":false,"temperature":0.2,"messages":[{"role":"user","content":"hi"}]}`)
+	if got := decodeJSON(t, resp)["model"]; got != "gpt-4o-mini" {
+		t.Errorf("响应中的model = %v, want gpt-4o-mini", got)
+	}
+
+	received := fake.received("/api/chat")
+	if len(received) != 1 {
+		t.Fatalf("Ollama收到 %d 个请求, want 1", len(received))
+	}
+	if received[0].Body["model"] != "qwen2.5:7b" {
+		t.Errorf("上游model = %v", received[0].Body["model"])
+	}
+	// 请求中的temperature优先，其余默认选项原样转发
+	options, _ := received[0].Body["options"].(map[string]interface{})
+	want := map[string]interface{}{"temperature": 0.2, "num_ctx": float64(8192), "num_gpu": float64(1), "mirostat": float64(2)}
+	for key, value := range want {
+		if options[key] != value {
+			t.Errorf("options[%s] = %v, want %v", key, options[key], value)
+		}
+	}
+	messages, _ := received[0].Body["messages"].([]interface{})
+	if first, _ := messages[0].(map[string]interface{}); len(messages) != 2 || first["content"] != "be brief" {
+		t.Errorf("应插入默认系统提示词: %v", messages)
+	}
+}
+
+func TestModelAliasKeepsRequestSystem(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, aliasConfig(fake.URL()))
+
+	doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-4o-mini","stream":false,"messages":[{"role":"system","content":"custom"},{"role":"user","content":"hi"}]}`)
+	messages, _ := fake.received("/api/chat")[0].Body["messages"].([]interface{})
+	if first, _ := messages[0].(map[string]interface{}); len(messages) != 2 || first["content"] != "custom" {
+		t.Errorf("请求中已有system消息时不应插入默认提示词: %v", messages)
+	}
+
+	// 别名出现在模型列表中
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/v1/models", ""))
+	var ids []string
+	for _, item := range result["data"].([]interface{}) {
+		ids = append(ids, item.(map[string]interface{})["id"].(string))
+	}
+	if fmt.Sprint(ids) != "[qwen2.5:7b llama3.2:latest gpt-4o-mini]" {
+		t.Errorf("models = %v", ids)
+	}
+}
@@ -0,0 +1,122 @@
+package base
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// logDir 日志文件目录
+const logDir = "logs"
+
+// Logger 将请求日志和运行日志按天写入logs目录，每行一条JSON记录
+type Logger struct {
+	mu     sync.Mutex
+	day    string
+	file   *os.File
+	closed bool
+}
+
+// requestEntry 一条请求日志
+type requestEntry struct {
+	Time     string      `json:"time"`
+	Method   string      `json:"method"`
+	Path     string      `json:"path"`
+	Request  interface{} `json:"request,omitempty"`
+	Response interface{} `json:"response,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	// Identity 调用方身份，不包含token原文
+	Identity string `json:"identity,omitempty"`
+	Valid    bool   `json:"valid"`
+}
+
+// messageEntry 一条运行日志
+type messageEntry struct {
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+// NewLogger 创建日志记录器，日志目录不存在时自动创建
+func NewLogger() (*Logger, error) {
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("无法创建日志目录: %v", err)
+	}
+	l := &Logger{}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// LogRequest 记录一次请求，identity为调用方身份标识
+func (l *Logger) LogRequest(method, path string, request, response interface{}, err error, identity string, valid bool) {
+	entry := requestEntry{
+		Time:     time.Now().Format(time.RFC3339Nano),
+		Method:   method,
+		Path:     path,
+		Request:  request,
+		Response: response,
+		Identity: identity,
+		Valid:    valid,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.write(entry)
+}
+
+// Printf 记录运行状态日志，同时输出到标准错误
+func (l *Logger) Printf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+	l.write(messageEntry{Time: time.Now().Format(time.RFC3339Nano), Message: message})
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+func (l *Logger) write(entry interface{}) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.rotate(time.Now()) != nil {
+		return
+	}
+	l.file.Write(append(data, '\n'))
+}
+
+// rotate 日期变化时切换到新的日志文件，调用方需持有锁
+func (l *Logger) rotate(now time.Time) error {
+	day := now.Format("2006-01-02")
+	if l.file != nil && day == l.day {
+		return nil
+	}
+	file, err := os.OpenFile(filepath.Join(logDir, day+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("无法打开日志文件: %v", err)
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, l.day = file, day
+	return nil
+}
@@ -0,0 +1,54 @@
+package base
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoggerWritesJSONLines(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	l, err := NewLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.LogRequest("POST", "/api/chat", map[string]interface{}{"model": "qwen2.5:7b"}, nil, errors.New("boom"), "cert:alice", false)
+	l.Printf("listening on %s", ":8080")
+	l.Close()
+	// 关闭后不再写入
+	l.Printf("ignored")
+
+	file, err := os.Open(filepath.Join(dir, logDir, time.Now().Format("2006-01-02")+".log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("无法解析的行: %s", scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("收到 %d 行, want 2", len(lines))
+	}
+	if lines[0]["identity"] != "cert:alice" || lines[0]["error"] != "boom" || lines[0]["valid"] != false {
+		t.Errorf("request entry = %v", lines[0])
+	}
+	if lines[1]["message"] != "listening on :8080" {
+		t.Errorf("message entry = %v", lines[1])
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CacheConfig 响应缓存配置
+type CacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Backend 缓存后端：memory / disk，默认为memory
+	Backend string `yaml:"backend"`
+	// MaxEntries 内存缓存的最大条目数
+	MaxEntries int `yaml:"max_entries"`
+	// Dir 磁盘缓存目录
+	Dir string        `yaml:"dir"`
+	TTL time.Duration `yaml:"ttl"`
+	// Shared 不同调用方共享缓存，默认每个调用方只能命中自己写入的缓存
+	Shared bool `yaml:"shared"`
+}
+
+const (
+	defaultCacheEntries = 1000
+	defaultCacheTTL     = time.Hour
+	defaultCacheDir     = "cache"
+	// digestTTL 模型digest的本地缓存时间
+	digestTTL = 30 * time.Second
+	// diskSweepInterval 清理磁盘缓存中过期文件的最大间隔
+	diskSweepInterval = 10 * time.Minute
+)
+
+// cacheEntry 缓存的上游响应，流式响应保存为完整的NDJSON
+type cacheEntry struct {
+	Body    []byte    `json:"body"`
+	Expires time.Time `json:"expires"`
+}
+
+// responseCache 响应缓存后端
+type responseCache interface {
+	Get(key string) (*cacheEntry, bool)
+	Set(key string, entry *cacheEntry)
+}
+
+// respCache 全局响应缓存，未启用时为nil
+var respCache responseCache
+
+var cacheTTL = defaultCacheTTL
+
+// cacheShared 不同调用方是否共享缓存
+var cacheShared bool
+
+// initResponseCache 根据配置初始化响应缓存
+func initResponseCache(cfg CacheConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.TTL > 0 {
+		cacheTTL = cfg.TTL
+	}
+	cacheShared = cfg.Shared
+
+	switch cfg.Backend {
+	case "", "memory":
+		maxEntries := cfg.MaxEntries
+		if maxEntries <= 0 {
+			maxEntries = defaultCacheEntries
+		}
+		respCache = newMemoryCache(maxEntries)
+	case "disk":
+		dir := cfg.Dir
+		if dir == "" {
+			dir = defaultCacheDir
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		disk := &diskCache{dir: dir}
+		disk.sweep()
+		go disk.sweepLoop(min(cacheTTL, diskSweepInterval))
+		respCache = disk
+	default:
+		return fmt.Errorf("不支持的缓存后端: %s", cfg.Backend)
+	}
+	return nil
+}
+
+// memoryCache 基于LRU淘汰的内存缓存
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *cacheEntry
+}
+
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryCache) Get(key string) (*cacheEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryItem)
+	if time.Now().After(item.entry.Expires) {
+		m.ll.Remove(elem)
+		delete(m.items, key)
+		return nil, false
+	}
+	m.ll.MoveToFront(elem)
+	return item.entry, true
+}
+
+func (m *memoryCache) Set(key string, entry *cacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.items[key]; ok {
+		elem.Value.(*memoryItem).entry = entry
+		m.ll.MoveToFront(elem)
+		return
+	}
+	m.items[key] = m.ll.PushFront(&memoryItem{key: key, entry: entry})
+	for m.ll.Len() > m.maxEntries {
+		oldest := m.ll.Back()
+		m.ll.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryItem).key)
+	}
+}
+
+// diskCache 以文件形式保存缓存，进程重启后仍然有效
+type diskCache struct {
+	dir string
+}
+
+func (d *diskCache) path(key string) string {
+	return filepath.Join(d.dir, key+".json")
+}
+
+func (d *diskCache) Get(key string) (*cacheEntry, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if time.Now().After(entry.Expires) {
+		os.Remove(d.path(key))
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (d *diskCache) Set(key string, entry *cacheEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// 先写临时文件再重命名，避免并发读取到不完整的内容
+	tmp, err := os.CreateTemp(d.dir, key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), d.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// sweep 删除所有过期的缓存文件，过期但从未再次读取的条目不会在Get中删除
+func (d *diskCache) sweep() {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.json"))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if json.Unmarshal(data, &entry) != nil || now.After(entry.Expires) {
+			os.Remove(file)
+		}
+	}
+}
+
+// sweepLoop 定期清理过期的缓存文件
+func (d *diskCache) sweepLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.sweep()
+	}
+}
+
+// cachePolicy 单次请求的缓存策略
+type cachePolicy struct {
+	key     string
+	noCache bool // 跳过读取缓存
+	noStore bool // 不写入缓存
+}
+
+// newCachePolicy 计算请求的缓存键，不可缓存时返回nil
+// 缓存键由模型digest、上游路径和规范化后的请求体组成，未开启shared时还包括调用方身份
+func newCachePolicy(c *gin.Context, model, path string, request interface{}) *cachePolicy {
+	if respCache == nil {
+		return nil
+	}
+
+	digest := modelDigest(model)
+	if digest == "" {
+		return nil
+	}
+
+	// map和结构体经json序列化后字段顺序固定，可直接作为规范化结果
+	normalized, err := json.Marshal(request)
+	if err != nil {
+		return nil
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(digest))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(normalized)
+	if !cacheShared {
+		hash.Write([]byte{0})
+		hash.Write([]byte(c.GetString(identityKey)))
+	}
+
+	cacheControl := strings.ToLower(c.GetHeader("Cache-Control"))
+	return &cachePolicy{
+		key:     hex.EncodeToString(hash.Sum(nil)),
+		noCache: strings.Contains(cacheControl, "no-cache"),
+		noStore: strings.Contains(cacheControl, "no-store"),
+	}
+}
+
+// lookup 查询缓存并设置X-Cache响应头
+func (p *cachePolicy) lookup(c *gin.Context) (*cacheEntry, bool) {
+	if p == nil {
+		return nil, false
+	}
+	if !p.noCache {
+		if entry, ok := respCache.Get(p.key); ok {
+			c.Header("X-Cache", "HIT")
+			return entry, true
+		}
+	}
+	c.Header("X-Cache", "MISS")
+	return nil, false
+}
+
+// store 保存上游响应
+func (p *cachePolicy) store(body []byte) {
+	if p == nil || p.noStore {
+		return
+	}
+	respCache.Set(p.key, &cacheEntry{
+		Body:    body,
+		Expires: time.Now().Add(cacheTTL),
+	})
+}
+
+// storeJSON 保存已解析的上游响应
+func (p *cachePolicy) storeJSON(resp map[string]interface{}) {
+	if p == nil || p.noStore {
+		return
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	p.store(body)
+}
+
+type digestItem struct {
+	digest  string
+	expires time.Time
+}
+
+var (
+	digestMu    sync.Mutex
+	digestCache = map[string]digestItem{}
+)
+
+// modelDigest 通过/api/tags查询模型digest，模型更新后缓存自动失效
+func modelDigest(model string) string {
+	name := withDefaultTag(model)
+
+	digestMu.Lock()
+	item, ok := digestCache[name]
+	digestMu.Unlock()
+	if ok && time.Now().Before(item.expires) {
+		return item.digest
+	}
+
+	resp, err := sendToOllamaGet("/api/tags")
+	if err != nil {
+		return ""
+	}
+	tags, _ := resp["models"].([]interface{})
+
+	digestMu.Lock()
+	defer digestMu.Unlock()
+	digest := ""
+	for _, tag := range tags {
+		info, ok := tag.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tagName, _ := info["name"].(string)
+		tagDigest, _ := info["digest"].(string)
+		digestCache[tagName] = digestItem{digest: tagDigest, expires: time.Now().Add(digestTTL)}
+		if tagName == name {
+			digest = tagDigest
+		}
+	}
+	return digest
+}
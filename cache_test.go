@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withCache 使用指定配置启用响应缓存，测试结束后恢复
+func withCache(t *testing.T, cfg CacheConfig) {
+	t.Helper()
+	previous, previousTTL, previousShared := respCache, cacheTTL, cacheShared
+	t.Cleanup(func() { respCache, cacheTTL, cacheShared = previous, previousTTL, previousShared })
+	cfg.Enabled = true
+	if err := initResponseCache(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// doRequestAs 使用指定token向代理发送请求
+func doRequestAs(t *testing.T, proxy string, token, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, proxy+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestMemoryCacheLRU(t *testing.T) {
+	cache := newMemoryCache(2)
+	entry := func(body string) *cacheEntry {
+		return &cacheEntry{Body: []byte(body), Expires: time.Now().Add(time.Hour)}
+	}
+	cache.Set("a", entry("a"))
+	cache.Set("b", entry("b"))
+	// 读取a后b成为最久未使用的条目
+	cache.Get("a")
+	cache.Set("c", entry("c"))
+
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("b应被淘汰")
+	}
+	for _, key := range []string{"a", "c"} {
+		if got, ok := cache.Get(key); !ok || string(got.Body) != key {
+			t.Errorf("%s应保留在缓存中", key)
+		}
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	cache := newMemoryCache(10)
+	cache.Set("expired", &cacheEntry{Body: []byte("x"), Expires: time.Now().Add(-time.Second)})
+	if _, ok := cache.Get("expired"); ok {
+		t.Errorf("过期的条目不应命中")
+	}
+	if cache.ll.Len() != 0 {
+		t.Errorf("过期的条目应被删除")
+	}
+}
+
+func TestDiskCacheSweep(t *testing.T) {
+	dir := t.TempDir()
+	cache := &diskCache{dir: dir}
+	cache.Set("fresh", &cacheEntry{Body: []byte("fresh"), Expires: time.Now().Add(time.Hour)})
+	cache.Set("stale", &cacheEntry{Body: []byte("stale"), Expires: time.Now().Add(-time.Second)})
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.sweep()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 || filepath.Base(files[0]) != "fresh.json" {
+		t.Errorf("清理后的文件 = %v", files)
+	}
+	if entry, ok := cache.Get("fresh"); !ok || string(entry.Body) != "fresh" {
+		t.Errorf("未过期的条目应保留")
+	}
+}
+
+func TestCacheIsolatedPerCaller(t *testing.T) {
+	fake := newFakeOllama(t)
+	withCache(t, CacheConfig{})
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q, "other-token"]
+service:
+  base_url: %q
+`, testToken, fake.URL()))
+
+	body := `{"model":"qwen2.5:7b","input":"hello"}`
+	for i, tt := range []struct {
+		token string
+		want  string
+	}{
+		{testToken, "MISS"},
+		{testToken, "HIT"},
+		{"other-token", "MISS"},
+	} {
+		resp := doRequestAs(t, proxy.URL, tt.token, http.MethodPost, "/v1/embeddings", body)
+		if got := resp.Header.Get("X-Cache"); got != tt.want {
+			t.Errorf("第%d次请求 X-Cache = %q, want %q", i+1, got, tt.want)
+		}
+	}
+	if got := len(fake.received("/api/embed")); got != 2 {
+		t.Errorf("Ollama收到 %d 个请求, want 2", got)
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	fake := newFakeOllama(t)
+	withCache(t, CacheConfig{Shared: true})
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q, "other-token"]
+service:
+  base_url: %q
+`, testToken, fake.URL()))
+
+	body := `{"model":"qwen2.5:7b","stream":false,"temperature":0,"messages":[{"role":"user","content":"hi"}]}`
+	doRequestAs(t, proxy.URL, testToken, http.MethodPost, "/v1/chat/completions", body)
+	resp := doRequestAs(t, proxy.URL, "other-token", http.MethodPost, "/v1/chat/completions", body)
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Errorf("开启shared后其他调用方应命中缓存, X-Cache = %q", got)
+	}
+
+	// no-cache跳过读取缓存
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Cache-Control", "no-cache")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Cache"); got != "MISS" {
+		t.Errorf("no-cache时 X-Cache = %q, want MISS", got)
+	}
+	if got := len(fake.received("/api/chat")); got != 2 {
+		t.Errorf("Ollama收到 %d 个请求, want 2", got)
+	}
+}
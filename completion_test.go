@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAICompletionSuffix(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5-coder:7b","prompt":"def add(a, b):\n","suffix":"\nprint(add(1, 2))","raw":true,"template":"{{ .Prompt }}","max_tokens":32}`)
+	body := fake.received("/api/generate")[0].Body
+	if body["prompt"] != "def add(a, b):\n" || body["suffix"] != "\nprint(add(1, 2))" {
+		t.Errorf("prompt = %q, suffix = %q", body["prompt"], body["suffix"])
+	}
+	if body["raw"] != true || body["template"] != "{{ .Prompt }}" {
+		t.Errorf("raw = %v, template = %v", body["raw"], body["template"])
+	}
+}
+
+func TestOpenAICompletionEcho(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/generate", fakeReply{Body: withLogprobs(generateLine("qwen2.5:7b", " world", true), " world", -0.5)})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":"你好","echo":true,"logprobs":0}`)
+	var result struct {
+		Choices []struct {
+			Text     string `json:"text"`
+			Logprobs struct {
+				TextOffset []int `json:"text_offset"`
+			} `json:"logprobs"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	choice := result.Choices[0]
+	// text_offset按拼接prompt后的字符数计算
+	if choice.Text != "你好 world" || len(choice.Logprobs.TextOffset) != 1 || choice.Logprobs.TextOffset[0] != 2 {
+		t.Errorf("choice = %+v", choice)
+	}
+}
+
+func TestOpenAICompletionPromptArray(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":["a","b","c"],"n":2}`)
+	var result struct {
+		Choices []struct {
+			Index int    `json:"index"`
+			Text  string `json:"text"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	// 每个prompt生成n个结果，按prompt顺序排列
+	var texts []string
+	for i, choice := range result.Choices {
+		if choice.Index != i {
+			t.Errorf("choice %d index = %d", i, choice.Index)
+		}
+		texts = append(texts, choice.Text)
+	}
+	if got := strings.Join(texts, ","); got != "echo: a,echo: a,echo: b,echo: b,echo: c,echo: c" {
+		t.Errorf("texts = %s", got)
+	}
+	if result.Usage.PromptTokens != 36 {
+		t.Errorf("prompt_tokens = %d, want 36", result.Usage.PromptTokens)
+	}
+}
+
+func TestOpenAICompletionStreamEchoPromptArray(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":["a","b"],"echo":true,"stream":true}`)
+	texts := map[int]string{}
+	for _, chunk := range chatChunks(t, readSSE(t, resp.Body)) {
+		texts[chunk.Choices[0].Index] += chunk.Choices[0].Text
+	}
+	if len(texts) != 2 || texts[0] != "aecho: a" || texts[1] != "becho: b" {
+		t.Errorf("texts = %v", texts)
+	}
+}
+
+func TestOpenAICompletionTokenPrompt(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions", `{"model":"qwen2.5:7b","prompt":[1,2,3]}`)
+	if got, _ := decodeJSON(t, resp)["error"].(string); !strings.Contains(got, "token数组") {
+		t.Errorf("error = %q", got)
+	}
+	if got := len(fake.received("/api/generate")); got != 0 {
+		t.Errorf("Ollama收到 %d 个请求, want 0", got)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// updateGolden 使用当前输出重新生成golden文件：go test -run TestConformance -update
+var updateGolden = flag.Bool("update", false, "使用当前输出更新testdata/conformance下的golden文件")
+
+// conformanceCase 一个录制的请求，包含客户端请求和Ollama的响应
+type conformanceCase struct {
+	Endpoint string `json:"endpoint"`
+	Method   string `json:"method"`
+	// Config 追加到测试配置中的YAML
+	Config  string          `json:"config"`
+	Request json.RawMessage `json:"request"`
+	// Upstream 按接口编排的Ollama响应
+	Upstream map[string][]recordedReply `json:"upstream"`
+	// Schema 响应应满足的OpenAPI schema，流式响应的每个分块分别校验
+	Schema string `json:"schema"`
+}
+
+// recordedReply 录制的一次Ollama响应
+type recordedReply struct {
+	Status int               `json:"status"`
+	Body   json.RawMessage   `json:"body"`
+	Chunks []json.RawMessage `json:"chunks"`
+}
+
+// goldenResponse 代理返回的响应，随机的id和created已替换为固定值
+type goldenResponse struct {
+	Status int           `json:"status"`
+	Body   interface{}   `json:"body,omitempty"`
+	Chunks []interface{} `json:"chunks,omitempty"`
+}
+
+func TestConformance(t *testing.T) {
+	spec := loadOpenAPISpec(t, filepath.Join("testdata", "openapi", "openai.yaml"))
+
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, ".golden.json") {
+			continue
+		}
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		t.Run(name, func(t *testing.T) {
+			var tc conformanceCase
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &tc); err != nil {
+				t.Fatal(err)
+			}
+
+			got := replayCase(t, tc)
+			values := got.Chunks
+			if got.Body != nil {
+				values = []interface{}{got.Body}
+			}
+			for i, value := range values {
+				for _, problem := range spec.validate(tc.Schema, value) {
+					t.Errorf("响应 %d 不符合 %s: %s", i, tc.Schema, problem)
+				}
+			}
+			compareGolden(t, strings.TrimSuffix(file, ".json")+".golden.json", got)
+		})
+	}
+}
+
+// replayCase 使用录制的Ollama响应启动模拟服务，通过真实的路由发送请求
+func replayCase(t *testing.T, tc conformanceCase) goldenResponse {
+	t.Helper()
+	fake := newFakeOllama(t)
+	for path, replies := range tc.Upstream {
+		for _, reply := range replies {
+			scripted := fakeReply{Status: reply.Status, Body: string(reply.Body)}
+			for _, chunk := range reply.Chunks {
+				var line bytes.Buffer
+				if err := json.Compact(&line, chunk); err != nil {
+					t.Fatal(err)
+				}
+				scripted.Chunks = append(scripted.Chunks, fakeChunk{Line: line.String()})
+			}
+			fake.script(path, scripted)
+		}
+	}
+	proxy := newTestProxy(t, testConfig(fake.URL())+tc.Config)
+
+	method := tc.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	resp := doRequest(t, proxy, method, tc.Endpoint, string(tc.Request))
+
+	got := goldenResponse{Status: resp.StatusCode}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		events := readSSE(t, resp.Body)
+		if len(events) == 0 || events[len(events)-1].Data != sseDone {
+			t.Fatalf("流式响应没有以[DONE]结束: %+v", events)
+		}
+		for _, event := range events[:len(events)-1] {
+			if event.Event != "" {
+				t.Errorf("数据分块不应带有event字段: %+v", event)
+			}
+			var chunk interface{}
+			if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+				t.Fatalf("无法解析分块: %v\n%s", err, event.Data)
+			}
+			got.Chunks = append(got.Chunks, normalizeGolden(chunk))
+		}
+		return got
+	}
+
+	var body interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	got.Body = normalizeGolden(body)
+	return got
+}
+
+// compareGolden 与golden文件比较，指定-update时写入golden文件
+func compareGolden(t *testing.T, path string, got goldenResponse) {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(got); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if *updateGolden {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取golden文件失败，可以使用 -update 生成: %v", err)
+	}
+	if !bytes.Equal(want, data) {
+		t.Errorf("响应与 %s 不一致\n--- want\n%s\n--- got\n%s", path, want, data)
+	}
+}
+
+// randomID 代理随机生成的ID
+var randomID = regexp.MustCompile(`^(chatcmpl-|cmpl-|call_|modelperm-)[0-9a-f]+$`)
+
+// normalizeGolden 将随机生成的id替换为前缀加<id>，将当前时间生成的created替换为0
+func normalizeGolden(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			switch key {
+			case "id":
+				if id, ok := item.(string); ok && randomID.MatchString(id) {
+					v[key] = randomID.FindStringSubmatch(id)[1] + "<id>"
+					continue
+				}
+			case "created":
+				if _, ok := item.(float64); ok && v["object"] != "model" {
+					v[key] = float64(0)
+					continue
+				}
+			}
+			v[key] = normalizeGolden(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeGolden(item)
+		}
+	}
+	return value
+}
+
+// openAPISchema OpenAPI schema中校验用到的部分
+type openAPISchema struct {
+	Ref                  string                    `yaml:"$ref"`
+	Type                 string                    `yaml:"type"`
+	Nullable             bool                      `yaml:"nullable"`
+	Enum                 []interface{}             `yaml:"enum"`
+	Required             []string                  `yaml:"required"`
+	Properties           map[string]*openAPISchema `yaml:"properties"`
+	Items                *openAPISchema            `yaml:"items"`
+	AdditionalProperties *openAPISchema            `yaml:"additionalProperties"`
+}
+
+// openAPISpec 规范中的所有schema
+type openAPISpec struct {
+	Components struct {
+		Schemas map[string]*openAPISchema `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T, path string) *openAPISpec {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec openAPISpec
+	if err := yaml.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	return &spec
+}
+
+// validate 校验JSON值是否符合指定的schema，返回所有不符合的地方
+func (s *openAPISpec) validate(name string, value interface{}) []string {
+	schema, ok := s.Components.Schemas[name]
+	if !ok {
+		return []string{fmt.Sprintf("schema %s 不存在", name)}
+	}
+	var problems []string
+	s.check(schema, value, "$", &problems)
+	return problems
+}
+
+func (s *openAPISpec) check(schema *openAPISchema, value interface{}, path string, problems *[]string) {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		ref, ok := s.Components.Schemas[name]
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: 无法解析 %s", path, schema.Ref))
+			return
+		}
+		s.check(ref, value, path, problems)
+		return
+	}
+
+	if value == nil {
+		if !schema.Nullable {
+			*problems = append(*problems, fmt.Sprintf("%s: 不能为null", path))
+		}
+		return
+	}
+	if !matchesType(schema.Type, value) {
+		*problems = append(*problems, fmt.Sprintf("%s: 应为 %s，实际为 %T", path, schema.Type, value))
+		return
+	}
+	if len(schema.Enum) > 0 && !containsValue(schema.Enum, value) {
+		*problems = append(*problems, fmt.Sprintf("%s: %v 不在 %v 中", path, value, schema.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, field := range schema.Required {
+			if _, ok := v[field]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: 缺少必需字段 %s", path, field))
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if property, ok := schema.Properties[key]; ok {
+				s.check(property, v[key], path+"."+key, problems)
+			} else if schema.AdditionalProperties != nil {
+				s.check(schema.AdditionalProperties, v[key], path+"."+key, problems)
+			}
+		}
+	case []interface{}:
+		if schema.Items != nil {
+			for i, item := range v {
+				s.check(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+}
+
+func matchesType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "":
+		return true
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	default:
+		return false
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, item := range values {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOpenAPIValidatorRejectsDrift(t *testing.T) {
+	spec := loadOpenAPISpec(t, filepath.Join("testdata", "openapi", "openai.yaml"))
+
+	// 旧版本的流式分块：中间分块的finish_reason为空字符串，并且使用message代替delta
+	var chunk interface{}
+	json.Unmarshal([]byte(`{"id":"chatcmpl-1","object":"chat.completion.chunk","created":1,"model":"m",
+		"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":""}]}`), &chunk)
+	problems := spec.validate("CreateChatCompletionStreamResponse", chunk)
+	if len(problems) != 2 {
+		t.Errorf("problems = %v, want missing delta and invalid finish_reason", problems)
+	}
+
+	var embedding interface{}
+	json.Unmarshal([]byte(`{"object":"list","model":"m","data":[{"index":"0","object":"embedding","embedding":[0.1]}]}`), &embedding)
+	problems = spec.validate("CreateEmbeddingResponse", embedding)
+	if len(problems) != 2 {
+		t.Errorf("problems = %v, want missing usage and invalid index", problems)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// 上下文溢出时的处理策略
+const (
+	// overflowError 返回context_length_exceeded错误（默认）
+	overflowError = "error"
+	// overflowTruncate 丢弃最早的非system消息直到不再溢出
+	overflowTruncate = "truncate"
+)
+
+const (
+	// contextLengthTTL 通过/api/show查询到的上下文长度的本地缓存时间
+	contextLengthTTL = 5 * time.Minute
+	// minAutoNumCtx Ollama的默认num_ctx，请求需要的token数不超过该值时不调整num_ctx
+	minAutoNumCtx = 2048
+	// defaultCompletionReserve 未指定max_tokens时为输出预留的token数，仅用于计算num_ctx
+	defaultCompletionReserve = 1024
+)
+
+type contextLengthItem struct {
+	length  int
+	expires time.Time
+}
+
+var (
+	contextLengthMu    sync.Mutex
+	contextLengthCache = map[string]contextLengthItem{}
+)
+
+// modelContextLength 返回模型的上下文长度，优先使用配置，否则通过/api/show查询，无法获取时返回0
+func modelContextLength(model string, settings ModelConfig) int {
+	if settings.ContextLength > 0 {
+		return settings.ContextLength
+	}
+
+	contextLengthMu.Lock()
+	item, ok := contextLengthCache[model]
+	contextLengthMu.Unlock()
+	if ok && time.Now().Before(item.expires) {
+		return item.length
+	}
+
+	// 查询失败时同样缓存，避免每个请求都重复查询
+	length := 0
+	if resp, err := showModel(model); err == nil {
+		length = models.ModelContextLength(resp)
+	}
+
+	contextLengthMu.Lock()
+	contextLengthCache[model] = contextLengthItem{length: length, expires: time.Now().Add(contextLengthTTL)}
+	contextLengthMu.Unlock()
+	return length
+}
+
+// fitContext 检查聊天请求是否超出模型的上下文长度，按配置返回错误或丢弃最早的非system消息，
+// 并在未指定num_ctx时根据请求大小自动设置，避免Ollama按默认的num_ctx截断长对话。返回错误表示请求超出上下文长度
+// maxNumCtx为策略限制的num_ctx上限，0表示不限制
+func fitContext(model string, settings ModelConfig, maxNumCtx int, req *models.OllamaChatRequest) error {
+	window := modelContextLength(model, settings)
+	if maxNumCtx > 0 && (window <= 0 || maxNumCtx < window) {
+		window = maxNumCtx
+	}
+	if window <= 0 {
+		return nil
+	}
+
+	var numCtx, numPredict int
+	if req.Options != nil {
+		numCtx = req.Options.NumCtx
+		numPredict = req.Options.NumPredict
+	}
+	// 显式指定的num_ctx更小时，Ollama实际只能使用num_ctx
+	limit := window
+	if numCtx > 0 && numCtx < limit {
+		limit = numCtx
+	}
+	if numPredict < 0 {
+		numPredict = 0
+	}
+
+	prompt := models.EstimateMessagesTokens(req.Messages, req.Tools)
+	if prompt+numPredict > limit {
+		if settings.ContextOverflow != overflowTruncate {
+			return fmt.Errorf("模型的上下文长度为%d个token，但请求约需要%d个token（消息%d个，max_tokens %d个），请缩短消息或减小max_tokens",
+				limit, prompt+numPredict, prompt, numPredict)
+		}
+
+		toolTokens := models.EstimateMessagesTokens(nil, req.Tools)
+		messages, ok := truncateMessages(req.Messages, limit-numPredict-toolTokens)
+		if !ok {
+			return fmt.Errorf("模型的上下文长度为%d个token，丢弃历史消息后system消息和最后一条消息仍超出上下文长度", limit)
+		}
+		req.Messages = messages
+		prompt = models.EstimateMessagesTokens(req.Messages, req.Tools)
+	}
+
+	if numCtx == 0 {
+		reserve := numPredict
+		if reserve == 0 {
+			reserve = defaultCompletionReserve
+		}
+		if needed := prompt + reserve; needed > minAutoNumCtx {
+			// 按2的幂取值，避免num_ctx频繁变化导致Ollama反复重新加载模型
+			numCtx = minAutoNumCtx
+			for numCtx < needed {
+				numCtx *= 2
+			}
+			if numCtx > window {
+				numCtx = window
+			}
+			if req.Options == nil {
+				req.Options = &models.RequestOptions{}
+			}
+			req.Options.NumCtx = numCtx
+		}
+	}
+	return nil
+}
+
+// truncateMessages 从最早的消息开始丢弃非system消息，直到估算的token数不超过budget
+// 最后一条消息始终保留，丢弃带工具调用的assistant消息时一并丢弃对应的工具结果
+func truncateMessages(messages []models.ChatMessage, budget int) ([]models.ChatMessage, bool) {
+	total := models.EstimateMessagesTokens(messages, nil)
+	dropped := make([]bool, len(messages))
+	droppingToolResults := false
+	for i, message := range messages {
+		last := i == len(messages)-1
+		if message.Role == "tool" && droppingToolResults && !last {
+			dropped[i] = true
+			total -= models.EstimateMessageTokens(message)
+			continue
+		}
+		droppingToolResults = false
+		if total <= budget {
+			break
+		}
+		if message.Role == "system" || last {
+			continue
+		}
+		dropped[i] = true
+		total -= models.EstimateMessageTokens(message)
+		droppingToolResults = len(message.ToolCalls) > 0
+	}
+	if total > budget {
+		return nil, false
+	}
+
+	var result []models.ChatMessage
+	for i, message := range messages {
+		if !dropped[i] {
+			result = append(result, message)
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// words 返回约n个token的英文文本
+func words(n int) string {
+	return strings.Repeat("abc ", n)
+}
+
+func TestFitContextOverflowError(t *testing.T) {
+	req := &models.OllamaChatRequest{Messages: []models.ChatMessage{{Role: "user", Content: words(200)}}}
+	err := fitContext("m", ModelConfig{ContextLength: 100}, 0, req)
+	if err == nil || !strings.Contains(err.Error(), "上下文长度为100") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestFitContextTruncate(t *testing.T) {
+	call := models.ToolCall{Function: models.ToolCallFunction{Name: "f", Arguments: []byte(`{}`)}}
+	req := &models.OllamaChatRequest{
+		Messages: []models.ChatMessage{
+			{Role: "system", Content: "sys"},
+			{Role: "user", Content: words(100)},
+			{Role: "assistant", ToolCalls: []models.ToolCall{call}},
+			{Role: "tool", Content: words(50)},
+			{Role: "user", Content: words(20)},
+			{Role: "assistant", Content: "ok"},
+			{Role: "user", Content: "last"},
+		},
+		Options: &models.RequestOptions{NumPredict: 10},
+	}
+	if err := fitContext("m", ModelConfig{ContextLength: 60, ContextOverflow: overflowTruncate}, 0, req); err != nil {
+		t.Fatal(err)
+	}
+	// 丢弃带工具调用的assistant消息时一并丢弃工具结果，system和最后一条消息始终保留
+	var roles []string
+	for _, message := range req.Messages {
+		roles = append(roles, message.Role)
+	}
+	if got := strings.Join(roles, ","); got != "system,user,assistant,user" {
+		t.Errorf("roles = %s", got)
+	}
+	if req.Messages[1].Content != words(20) {
+		t.Errorf("应保留较新的消息: %q", req.Messages[1].Content)
+	}
+}
+
+func TestFitContextTruncateLastMessageTooLong(t *testing.T) {
+	req := &models.OllamaChatRequest{Messages: []models.ChatMessage{
+		{Role: "user", Content: "old"},
+		{Role: "user", Content: words(200)},
+	}}
+	if err := fitContext("m", ModelConfig{ContextLength: 100, ContextOverflow: overflowTruncate}, 0, req); err == nil {
+		t.Errorf("最后一条消息超出上下文长度时应返回错误")
+	}
+}
+
+func TestFitContextNumCtx(t *testing.T) {
+	settings := ModelConfig{ContextLength: 32768}
+
+	// 请求较小时保持Ollama默认的num_ctx
+	small := &models.OllamaChatRequest{Messages: []models.ChatMessage{{Role: "user", Content: "hi"}}}
+	if err := fitContext("m", settings, 0, small); err != nil || small.Options != nil {
+		t.Errorf("options = %+v, err = %v", small.Options, err)
+	}
+
+	// 约3000个token的请求加上预留的输出后取2的幂
+	large := &models.OllamaChatRequest{Messages: []models.ChatMessage{{Role: "user", Content: words(3000)}}}
+	if err := fitContext("m", settings, 0, large); err != nil || large.Options == nil || large.Options.NumCtx != 4096 {
+		t.Errorf("options = %+v, err = %v", large.Options, err)
+	}
+
+	// 显式指定的num_ctx不调整
+	explicit := &models.OllamaChatRequest{
+		Messages: []models.ChatMessage{{Role: "user", Content: words(3000)}},
+		Options:  &models.RequestOptions{NumCtx: 8192},
+	}
+	if err := fitContext("m", settings, 0, explicit); err != nil || explicit.Options.NumCtx != 8192 {
+		t.Errorf("options = %+v, err = %v", explicit.Options, err)
+	}
+
+	// 策略限制的num_ctx上限小于模型的上下文长度
+	if err := fitContext("m", settings, 1024, &models.OllamaChatRequest{Messages: large.Messages}); err == nil {
+		t.Errorf("超出策略限制的上下文长度时应返回错误")
+	}
+}
+
+func TestContextLengthExceeded(t *testing.T) {
+	fake := newFakeOllama(t)
+	// 上下文长度通过/api/show的model_info获取，使用单独的模型名称避免命中其他测试的缓存
+	fake.script("/api/show", jsonReply(map[string]interface{}{
+		"model_info": map[string]interface{}{"general.architecture": "llama", "llama.context_length": 128},
+	}))
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		fmt.Sprintf(`{"model":"tiny-context:1b","messages":[{"role":"user","content":%q}]}`, words(200)))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	errObj, _ := decodeJSON(t, resp)["error"].(map[string]interface{})
+	if errObj["code"] != "context_length_exceeded" || errObj["param"] != "messages" {
+		t.Errorf("error = %v", errObj)
+	}
+	if got := len(fake.received("/api/chat")); got != 0 {
+		t.Errorf("Ollama收到 %d 个聊天请求, want 0", got)
+	}
+}
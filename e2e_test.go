@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/douguohai/ollama-proxy/base"
+	"github.com/gin-gonic/gin"
+)
+
+const testToken = "test-token"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	var err error
+	logger, err = base.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	logger.Close()
+	os.Exit(code)
+}
+
+// testConfig 返回使用单个上游服务的最小配置
+func testConfig(upstream string) string {
+	return fmt.Sprintf("auth:\n  generate_tokens: [%q]\nservice:\n  base_url: %q\n", testToken, upstream)
+}
+
+// newTestProxy 将配置写入临时文件并使用真实的路由启动代理
+func newTestProxy(t *testing.T, config string) *httptest.Server {
+	t.Helper()
+	proxy := httptest.NewServer(newRouter(writeTestConfig(t, config)))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// writeTestConfig 将配置写入临时文件作为代理的配置文件
+func writeTestConfig(t *testing.T, config string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	previous := configFile
+	configFile = path
+	t.Cleanup(func() { configFile = previous })
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// doRequest 携带测试token向代理发送请求
+func doRequest(t *testing.T, proxy *httptest.Server, method, path, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, proxy.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decodeJSON 读取并解析JSON响应
+func decodeJSON(t *testing.T, resp *http.Response) map[string]interface{} {
+	t.Helper()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		t.Fatalf("响应不是JSON: %v\n%s", err, body)
+	}
+	return result
+}
+
+// sseEvent SSE流中的一个事件
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// readSSE 按SSE格式解析整个响应
+func readSSE(t *testing.T, r io.Reader) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	reader := bufio.NewReader(r)
+	for {
+		event, err := nextSSE(reader)
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+}
+
+// nextSSE 读取下一个SSE事件，事件之间以空行分隔
+func nextSSE(reader *bufio.Reader) (sseEvent, error) {
+	var event sseEvent
+	var seen bool
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && seen {
+				return event, nil
+			}
+			return event, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if seen {
+				return event, nil
+			}
+			continue
+		}
+		seen = true
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Event = value
+		case "data":
+			event.Data += value
+		}
+	}
+}
+
+// chatChunks 解析OpenAI流式响应的所有数据分块，要求以[DONE]结束
+func chatChunks(t *testing.T, events []sseEvent) []streamChunk {
+	t.Helper()
+	if len(events) == 0 || events[len(events)-1].Data != sseDone {
+		t.Fatalf("流式响应没有以[DONE]结束: %+v", events)
+	}
+	var chunks []streamChunk
+	for _, event := range events[:len(events)-1] {
+		if event.Event != "" {
+			t.Fatalf("数据分块不应带有event字段: %+v", event)
+		}
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatalf("无法解析分块: %v\n%s", err, event.Data)
+		}
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// streamChunk 测试中使用的流式分块，同时兼容聊天和生成接口
+type streamChunk struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		Text         string `json:"text"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+}
+
+func TestAuth(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{name: "缺少token", header: "", want: "未提供认证token"},
+		{name: "错误的token", header: "Bearer wrong", want: "非授权访问"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/api/tags", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if got := decodeJSON(t, resp)["error"]; got != tt.want {
+				t.Errorf("error = %v, want %q", got, tt.want)
+			}
+		})
+	}
+	if got := len(fake.received("/api/tags")); got != 0 {
+		t.Errorf("未认证的请求不应转发到Ollama，实际转发了 %d 次", got)
+	}
+
+	resp := doRequest(t, proxy, http.MethodGet, "/api/tags", "")
+	if models, _ := decodeJSON(t, resp)["models"].([]interface{}); len(models) != 2 {
+		t.Errorf("models = %v, want 2 models", models)
+	}
+}
+
+func TestNativeChatNonStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hello"}]}`)
+	result := decodeJSON(t, resp)
+	message, _ := result["message"].(map[string]interface{})
+	if message["content"] != "echo: hello" || result["done"] != true {
+		t.Errorf("unexpected response: %v", result)
+	}
+
+	received := fake.received("/api/chat")
+	if len(received) != 1 {
+		t.Fatalf("Ollama收到 %d 个请求, want 1", len(received))
+	}
+	if received[0].Body["model"] != "qwen2.5:7b" {
+		t.Errorf("转发的model = %v", received[0].Body["model"])
+	}
+}
+
+func TestNativeGenerateStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/generate", `{"model":"qwen2.5:7b","prompt":"hello world"}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var text strings.Builder
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("无法解析的行: %s", scanner.Text())
+		}
+		response, _ := line["response"].(string)
+		text.WriteString(response)
+		lines = append(lines, line)
+	}
+	if len(lines) != 4 {
+		t.Fatalf("收到 %d 行, want 4", len(lines))
+	}
+	if lines[3]["done"] != true {
+		t.Errorf("最后一行应为结束分块: %v", lines[3])
+	}
+	if text.String() != "echo: hello world" {
+		t.Errorf("text = %q", text.String())
+	}
+}
+
+func TestNativeUpstreamError(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Status: http.StatusNotFound, Body: `{"error":"model \"missing\" not found, try pulling it first"}`})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"missing","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+	if got := decodeJSON(t, resp)["error"]; got != `model "missing" not found, try pulling it first` {
+		t.Errorf("error = %v", got)
+	}
+}
+
+func TestFailoverToSecondBackend(t *testing.T) {
+	primary := newFakeOllama(t)
+	secondary := newFakeOllama(t)
+	primary.script("/api/chat", fakeReply{Status: http.StatusInternalServerError, Body: `{"error":"out of memory"}`})
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  backends:
+    - name: primary
+      base_url: %q
+    - name: secondary
+      base_url: %q
+`, testToken, primary.URL(), secondary.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"hi"}]}`)
+	result := decodeJSON(t, resp)
+	choices, _ := result["choices"].([]interface{})
+	if len(choices) != 1 {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	if len(primary.received("/api/chat")) != 1 || len(secondary.received("/api/chat")) != 1 {
+		t.Errorf("主后端返回5xx后应故障转移到备用后端")
+	}
+}
+
+func TestOpenAIChat(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","max_tokens":16,"stop":["\n"],"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hello"}]}`)
+	var result struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Message      map[string]interface{} `json:"message"`
+			FinishReason string                 `json:"finish_reason"`
+		} `json:"choices"`
+		Usage struct {
+			PromptTokens     int `json:"prompt_tokens"`
+			CompletionTokens int `json:"completion_tokens"`
+			TotalTokens      int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(result.ID, "chatcmpl-") || result.Object != "chat.completion" || result.Model != "qwen2.5:7b" {
+		t.Errorf("unexpected metadata: %+v", result)
+	}
+	if len(result.Choices) != 1 || result.Choices[0].Message["content"] != "echo: hello" || result.Choices[0].FinishReason != "stop" {
+		t.Errorf("unexpected choices: %+v", result.Choices)
+	}
+	if result.Usage.PromptTokens != 12 || result.Usage.CompletionTokens != 5 || result.Usage.TotalTokens != 17 {
+		t.Errorf("unexpected usage: %+v", result.Usage)
+	}
+
+	// 采样参数转换为Ollama的options
+	received := fake.received("/api/chat")
+	if len(received) != 1 {
+		t.Fatalf("Ollama收到 %d 个请求, want 1", len(received))
+	}
+	options, _ := received[0].Body["options"].(map[string]interface{})
+	if options["num_predict"] != float64(16) {
+		t.Errorf("num_predict = %v, want 16", options["num_predict"])
+	}
+	if stop, _ := options["stop"].([]interface{}); len(stop) != 1 || stop[0] != "\n" {
+		t.Errorf("stop = %v", options["stop"])
+	}
+	if received[0].Body["stream"] != false {
+		t.Errorf("非流式请求应显式设置stream为false")
+	}
+}
+
+func TestOpenAIChatStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hello world"}]}`)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	chunks := chatChunks(t, readSSE(t, resp.Body))
+	if len(chunks) != 4 {
+		t.Fatalf("收到 %d 个分块, want 4", len(chunks))
+	}
+	var content strings.Builder
+	for i, chunk := range chunks {
+		if chunk.ID != chunks[0].ID || chunk.Created != chunks[0].Created {
+			t.Errorf("同一个流的分块应使用相同的id和created")
+		}
+		if chunk.Object != "chat.completion.chunk" || !strings.HasPrefix(chunk.ID, "chatcmpl-") {
+			t.Errorf("unexpected chunk metadata: %+v", chunk)
+		}
+		choice := chunk.Choices[0]
+		content.WriteString(choice.Delta.Content)
+		wantFinish := ""
+		if i == len(chunks)-1 {
+			wantFinish = "stop"
+		}
+		if choice.FinishReason != wantFinish {
+			t.Errorf("chunk %d finish_reason = %q, want %q", i, choice.FinishReason, wantFinish)
+		}
+	}
+	if content.String() != "echo: hello world" {
+		t.Errorf("content = %q", content.String())
+	}
+}
+
+func TestOpenAIChatStreamSkipsMalformedLines(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "Hel", false)},
+		{Line: `{"message":{"content":`},
+		{Line: ""},
+		{Line: chatLine("qwen2.5:7b", "lo", false)},
+		{Line: chatLine("qwen2.5:7b", "", true)},
+	}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	chunks := chatChunks(t, readSSE(t, resp.Body))
+	var content strings.Builder
+	for _, chunk := range chunks {
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if len(chunks) != 3 || content.String() != "Hello" {
+		t.Errorf("收到 %d 个分块，content = %q", len(chunks), content.String())
+	}
+}
+
+func TestOpenAIChatStreamDisconnect(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "partial", false)},
+		{Disconnect: true},
+	}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	events := readSSE(t, resp.Body)
+	if len(events) != 3 {
+		t.Fatalf("收到 %d 个事件, want 3: %+v", len(events), events)
+	}
+	if !strings.Contains(events[0].Data, "partial") {
+		t.Errorf("第一个分块应正常返回: %+v", events[0])
+	}
+	if events[1].Event != "error" || !strings.Contains(events[1].Data, "读取响应流出错") {
+		t.Errorf("上游断开后应返回错误事件: %+v", events[1])
+	}
+	if events[2].Data != sseDone {
+		t.Errorf("流应以[DONE]结束: %+v", events[2])
+	}
+}
+
+func TestOpenAIChatStreamIsNotBuffered(t *testing.T) {
+	const delay = 500 * time.Millisecond
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "first", false)},
+		{Line: chatLine("qwen2.5:7b", "", true), Delay: delay},
+	}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	start := time.Now()
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	reader := bufio.NewReader(resp.Body)
+	first, err := nextSSE(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("第一个分块在 %v 后才返回，流式响应被缓冲", elapsed)
+	}
+	if !strings.Contains(first.Data, "first") {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	if rest := readSSE(t, reader); len(rest) != 2 || rest[1].Data != sseDone {
+		t.Errorf("unexpected remaining events: %+v", rest)
+	}
+}
+
+func TestOpenAIChatUpstreamError(t *testing.T) {
+	const message = `model "missing" not found, try pulling it first`
+	fake := newFakeOllama(t)
+	fake.script("/api/chat",
+		fakeReply{Status: http.StatusNotFound, Body: mustJSON(map[string]string{"error": message})},
+		fakeReply{Status: http.StatusNotFound, Body: mustJSON(map[string]string{"error": message})},
+	)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"missing","messages":[{"role":"user","content":"hi"}]}`)
+	if got := decodeJSON(t, resp)["error"]; got != message {
+		t.Errorf("error = %v, want %q", got, message)
+	}
+
+	resp = doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"missing","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	events := readSSE(t, resp.Body)
+	if len(events) == 0 || events[0].Event != "error" || !strings.Contains(events[0].Data, "not found") {
+		t.Errorf("流式请求应返回错误事件: %+v", events)
+	}
+}
+
+func TestOpenAICompletionStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","stream":true,"prompt":"say hi"}`)
+	chunks := chatChunks(t, readSSE(t, resp.Body))
+	var text strings.Builder
+	for _, chunk := range chunks {
+		if !strings.HasPrefix(chunk.ID, "cmpl-") || chunk.ID != chunks[0].ID {
+			t.Errorf("unexpected chunk id: %q", chunk.ID)
+		}
+		text.WriteString(chunk.Choices[0].Text)
+	}
+	if text.String() != "echo: say hi" {
+		t.Errorf("text = %q", text.String())
+	}
+	if last := chunks[len(chunks)-1]; last.Choices[0].FinishReason != "stop" {
+		t.Errorf("最后一个分块的finish_reason = %q", last.Choices[0].FinishReason)
+	}
+}
+
+func TestOpenAIEmbeddings(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"nomic-embed-text","input":["a","bbb"]}`)
+	var result struct {
+		Object string `json:"object"`
+		Data   []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Object != "list" || len(result.Data) != 2 {
+		t.Fatalf("unexpected response: %+v", result)
+	}
+	for i, want := range []float64{1, 3} {
+		if result.Data[i].Index != i || result.Data[i].Embedding[0] != want {
+			t.Errorf("data[%d] = %+v", i, result.Data[i])
+		}
+	}
+}
+
+func TestOpenAIModels(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodGet, "/v1/models", "")
+	var result struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID     string `json:"id"`
+			Object string `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Object != "list" || len(result.Data) != 2 || result.Data[0].ID != "qwen2.5:7b" || result.Data[0].Object != "model" {
+		t.Errorf("unexpected response: %+v", result)
+	}
+
+	resp = doRequest(t, proxy, http.MethodGet, "/v1/models/qwen2.5:7b", "")
+	model := decodeJSON(t, resp)
+	if model["id"] != "qwen2.5:7b" || model["context_length"] != float64(32768) {
+		t.Errorf("unexpected model: %v", model)
+	}
+}
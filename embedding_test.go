@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestOpenAIEmbeddingsBatching(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+"embedding:\n  batch_size: 2\n")
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"nomic-embed-text","input":["a","bb","ccc"]}`))
+	data, _ := result["data"].([]interface{})
+	if len(data) != 3 {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	// 模拟服务返回的第一维为输入长度，合并后应保持原始顺序
+	for i, item := range data {
+		embedding := item.(map[string]interface{})["embedding"].([]interface{})
+		if embedding[0] != float64(i+1) {
+			t.Errorf("data[%d] = %v", i, item)
+		}
+	}
+	if got := len(fake.received("/api/embed")); got != 2 {
+		t.Errorf("Ollama收到 %d 个请求, want 2", got)
+	}
+	usage := result["usage"].(map[string]interface{})
+	if usage["prompt_tokens"] != float64(3) {
+		t.Errorf("usage = %v", usage)
+	}
+}
+
+func TestOpenAIEmbeddingsFormat(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	// 单个字符串输入，截断为2维并以base64返回
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"nomic-embed-text","input":"abc","dimensions":2,"encoding_format":"base64"}`))
+	data, _ := result["data"].([]interface{})
+	if len(data) != 1 {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	// 模拟服务返回[3, 0.5, 0]，截断后归一化为[0.986394, 0.164399]，按float32小端序编码
+	encoded, _ := data[0].(map[string]interface{})["embedding"].(string)
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != 8 {
+		t.Fatalf("embedding = %q", encoded)
+	}
+	for i, want := range []float64{0.986394, 0.164399} {
+		got := float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])))
+		if math.Abs(got-want) > 1e-5 {
+			t.Errorf("embedding[%d] = %v, want %v", i, got, want)
+		}
+	}
+
+	result = decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"nomic-embed-text","input":"abc","encoding_format":"int8"}`))
+	if result["error"] != "encoding_format仅支持float或base64" {
+		t.Errorf("unexpected response: %v", result)
+	}
+}
+
+func TestOpenAIEmbeddingsTokens(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/embeddings", jsonReply(map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{"index": 0, "embedding": []float64{0.1, 0.2}},
+			map[string]interface{}{"index": 1, "embedding": []float64{0.3, 0.4}},
+		},
+		"usage": map[string]interface{}{"prompt_tokens": 5},
+	}))
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  backends:
+    - name: ollama
+      base_url: %q
+    - name: vllm
+      type: openai
+      base_url: %q
+      models: ["bge-m3"]
+`, testToken, ollama.URL(), vllm.URL()))
+
+	// token数组原样发送到OpenAI兼容后端
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"bge-m3","input":[[101,2023],[101,2000,102]]}`))
+	if data, _ := result["data"].([]interface{}); len(data) != 2 {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	received := vllm.received("/v1/embeddings")
+	if len(received) != 1 || fmt.Sprint(received[0].Body["input"]) != "[[101 2023] [101 2000 102]]" {
+		t.Errorf("转发的请求 = %v", received)
+	}
+
+	// Ollama提供的模型不支持token数组
+	result = decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/embeddings",
+		`{"model":"nomic-embed-text","input":[101,2023]}`))
+	if result["error"] == nil {
+		t.Errorf("unexpected response: %v", result)
+	}
+	if got := len(ollama.received("/api/embed")); got != 0 {
+		t.Errorf("token数组不应发送到Ollama, 收到 %d 个请求", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envConfigFile 指定配置文件路径的环境变量
+const envConfigFile = "OLLAMA_PROXY_CONFIG"
+
+// envOverride 可以通过环境变量覆盖的配置项
+type envOverride struct {
+	name  string
+	apply func(config *Config, value string) error
+}
+
+// envOverrides 环境变量优先于配置文件，列表类型使用逗号分隔
+var envOverrides = []envOverride{
+	{"OLLAMA_PROXY_LISTEN", func(config *Config, value string) error {
+		config.Server.Listen = splitEnvList(value)
+		return nil
+	}},
+	{"OLLAMA_PROXY_BASE_URL", func(config *Config, value string) error {
+		// 同时清空backends，否则base_url不会生效
+		config.Service.BaseURL = value
+		config.Service.Backends = nil
+		return nil
+	}},
+	{"OLLAMA_PROXY_GENERATE_TOKENS", func(config *Config, value string) error {
+		config.Auth.GenerateTokens = splitEnvList(value)
+		return nil
+	}},
+	{"OLLAMA_PROXY_CORS_ORIGINS", func(config *Config, value string) error {
+		config.Server.CORS.AllowOrigins = splitEnvList(value)
+		return nil
+	}},
+	{"OLLAMA_PROXY_CORS_ALLOW_CREDENTIALS", func(config *Config, value string) error {
+		allow, err := strconv.ParseBool(value)
+		config.Server.CORS.AllowCredentials = allow
+		return err
+	}},
+	{"OLLAMA_PROXY_MAX_BODY_SIZE", func(config *Config, value string) error {
+		size, err := strconv.ParseInt(value, 10, 64)
+		config.Server.MaxBodySize = size
+		return err
+	}},
+	{"OLLAMA_PROXY_TRUSTED_PROXIES", func(config *Config, value string) error {
+		config.Server.TrustedProxies = splitEnvList(value)
+		return nil
+	}},
+	{"OLLAMA_PROXY_SHUTDOWN_TIMEOUT", func(config *Config, value string) error {
+		timeout, err := time.ParseDuration(value)
+		config.Server.ShutdownTimeout = timeout
+		return err
+	}},
+}
+
+// applyEnvOverrides 使用环境变量覆盖配置文件中的配置
+func applyEnvOverrides(config *Config) error {
+	for _, override := range envOverrides {
+		value, ok := os.LookupEnv(override.name)
+		if !ok {
+			continue
+		}
+		if err := override.apply(config, strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("环境变量 %s 无效: %v", override.name, err)
+		}
+	}
+	return nil
+}
+
+// hasEnvOverrides 是否设置了任意一个覆盖配置的环境变量
+func hasEnvOverrides() bool {
+	for _, override := range envOverrides {
+		if _, ok := os.LookupEnv(override.name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitEnvList 按逗号拆分列表，忽略空项
+func splitEnvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
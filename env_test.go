@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	writeTestConfig(t, `auth:
+  generate_tokens: ["from-file"]
+server:
+  listen: [":8080"]
+service:
+  backends:
+    - name: primary
+      base_url: "http://gpu-1:11434"
+`)
+	t.Setenv("OLLAMA_PROXY_LISTEN", "0.0.0.0:9000, 127.0.0.1:9001")
+	t.Setenv("OLLAMA_PROXY_BASE_URL", "http://ollama.ollama.svc:11434")
+	t.Setenv("OLLAMA_PROXY_GENERATE_TOKENS", "token-a,token-b")
+	t.Setenv("OLLAMA_PROXY_SHUTDOWN_TIMEOUT", "1m")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"0.0.0.0:9000", "127.0.0.1:9001"}; !reflect.DeepEqual(config.Server.Listen, want) {
+		t.Errorf("listen = %v, want %v", config.Server.Listen, want)
+	}
+	if backends := upstreamBackends(config); len(backends) != 1 || backends[0].BaseURL != "http://ollama.ollama.svc:11434" {
+		t.Errorf("backends = %+v, want base_url from env", backends)
+	}
+	if want := []string{"token-a", "token-b"}; !reflect.DeepEqual(config.Auth.GenerateTokens, want) {
+		t.Errorf("generate_tokens = %v, want %v", config.Auth.GenerateTokens, want)
+	}
+	if config.Server.ShutdownTimeout != time.Minute {
+		t.Errorf("shutdown_timeout = %v", config.Server.ShutdownTimeout)
+	}
+}
+
+func TestEnvOverridesInvalidValue(t *testing.T) {
+	writeTestConfig(t, testConfig("http://127.0.0.1:1"))
+	t.Setenv("OLLAMA_PROXY_MAX_BODY_SIZE", "10MB")
+	if _, err := loadConfig(); err == nil {
+		t.Error("无效的环境变量应返回错误")
+	}
+}
+
+func TestEnvOnlyConfig(t *testing.T) {
+	writeTestConfig(t, "")
+	configFile = filepath.Join(t.TempDir(), "missing.yaml")
+
+	if _, err := loadConfig(); err == nil {
+		t.Error("没有配置文件也没有环境变量时应返回错误")
+	}
+
+	t.Setenv("OLLAMA_PROXY_BASE_URL", "http://ollama:11434")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatalf("只通过环境变量配置时不需要配置文件: %v", err)
+	}
+	if config.Service.BaseURL != "http://ollama:11434" {
+		t.Errorf("base_url = %q", config.Service.BaseURL)
+	}
+}
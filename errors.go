@@ -0,0 +1,22 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// openAIError 返回OpenAI格式的错误响应，用于客户端需要根据HTTP状态码和错误码处理的场景
+func openAIError(c *gin.Context, status int, errType, param, code, message string) {
+	body := gin.H{
+		"message": message,
+		"type":    errType,
+		"param":   nil,
+		"code":    nil,
+	}
+	if param != "" {
+		body["param"] = param
+	}
+	if code != "" {
+		body["code"] = code
+	}
+	c.JSON(status, gin.H{"error": body})
+}
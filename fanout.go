@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// fanOutOptions 为第i路生成设置不同的seed，请求指定seed时依次递增以保证可复现。
+// reproducible为true时（流量录制与回放）未指定seed也从固定值递增，使回放时的请求与录制时一致
+func fanOutOptions(options *models.RequestOptions, i, n int, reproducible bool) *models.RequestOptions {
+	if n <= 1 {
+		return options
+	}
+	result := models.RequestOptions{}
+	if options != nil {
+		result = *options
+	}
+	seed := rand.Intn(1 << 30)
+	if reproducible {
+		seed = i
+	}
+	if options != nil && options.Seed != nil {
+		seed = *options.Seed + i
+	}
+	result.Seed = &seed
+	return &result
+}
+
+// sendAll 并发发送多路请求，任意一路失败时返回错误
+func sendAll(entry *cacheEntry, hit bool, path string, requests []interface{}) ([]map[string]interface{}, error) {
+	if hit {
+		resp, err := sendOrReplay(entry, hit, path, requests[0])
+		if err != nil {
+			return nil, err
+		}
+		return []map[string]interface{}{resp}, nil
+	}
+
+	responses := make([]map[string]interface{}, len(requests))
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := sendToOllama(path, requests[i])
+			if err == nil {
+				// 检查响应中是否包含错误信息
+				if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
+					err = fmt.Errorf("%s", errMsg)
+				}
+			}
+			responses[i], errs[i] = resp, err
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// openStreams 并发打开多路流式请求，命中缓存时回放缓存的单路流
+func openStreams(ctx context.Context, entry *cacheEntry, hit bool, path string, requests []interface{}) ([]io.ReadCloser, error) {
+	if hit {
+		body, err := openStream(ctx, entry, hit, path, requests[0])
+		if err != nil {
+			return nil, err
+		}
+		return []io.ReadCloser{body}, nil
+	}
+
+	bodies := make([]io.ReadCloser, len(requests))
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i], errs[i] = openOllamaStream(ctx, path, requests[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			closeAll(bodies)
+			return nil, err
+		}
+	}
+	return bodies, nil
+}
+
+func closeAll(bodies []io.ReadCloser) {
+	for _, body := range bodies {
+		if body != nil {
+			body.Close()
+		}
+	}
+}
+
+// streamLine 多路流中的一行输出
+type streamLine struct {
+	index  int
+	raw    []byte
+	result map[string]interface{}
+	err    error
+}
+
+// readStreams 并发读取多路NDJSON流，按到达顺序交错输出，每一路在done后结束
+func readStreams(ctx context.Context, bodies []io.ReadCloser) <-chan streamLine {
+	lines := make(chan streamLine)
+	var wg sync.WaitGroup
+	for i, body := range bodies {
+		wg.Add(1)
+		go func(index int, body io.Reader) {
+			defer wg.Done()
+			send := func(line streamLine) bool {
+				select {
+				case lines <- line:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+
+			reader := bufio.NewReader(body)
+			for {
+				raw, err := reader.ReadBytes('\n')
+				if err != nil && err != io.EOF {
+					send(streamLine{index: index, err: err})
+					return
+				}
+				// 最后一行可能没有换行符，例如上游返回的错误信息
+				eof := err == io.EOF
+
+				// 跳过空行和无法解析的行
+				var result map[string]interface{}
+				if len(bytes.TrimSpace(raw)) > 0 && json.Unmarshal(raw, &result) == nil {
+					if !send(streamLine{index: index, raw: raw, result: result}) {
+						return
+					}
+					if done, ok := result["done"].(bool); ok && done {
+						return
+					}
+				}
+				if eof {
+					return
+				}
+			}
+		}(i, body)
+	}
+
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+	return lines
+}
+
+// rankByLogprob 按平均对数概率从高到低排序候选结果，Ollama未返回logprobs时保持原顺序
+func rankByLogprob(responses []map[string]interface{}) []map[string]interface{} {
+	ranked := make([]map[string]interface{}, len(responses))
+	scores := make([]float64, len(responses))
+	for i, resp := range responses {
+		score, ok := models.MeanLogprob(resp)
+		if !ok {
+			return responses
+		}
+		scores[i] = score
+	}
+
+	indexes := make([]int, len(responses))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return scores[indexes[a]] > scores[indexes[b]]
+	})
+	for i, index := range indexes {
+		ranked[i] = responses[index]
+	}
+	return ranked
+}
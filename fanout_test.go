@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// generateWithLogprobs 构造带logprobs的/api/generate非流式响应，每个词一个token
+func generateWithLogprobs(text string, logprob float64) fakeReply {
+	var logprobs []map[string]interface{}
+	for _, token := range strings.SplitAfter(text, " ") {
+		logprobs = append(logprobs, map[string]interface{}{"token": token, "logprob": logprob})
+	}
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(generateLine("qwen2.5:7b", text, true)), &resp)
+	resp["logprobs"] = logprobs
+	return jsonReply(resp)
+}
+
+func TestFanOutOptions(t *testing.T) {
+	options := &models.RequestOptions{NumCtx: 2048}
+	if got := fanOutOptions(options, 0, 1, false); got != options {
+		t.Errorf("n=1时应保持原选项")
+	}
+
+	seeds := map[int]bool{}
+	for i := 0; i < 3; i++ {
+		got := fanOutOptions(options, i, 3, false)
+		if got.Seed == nil || got.NumCtx != 2048 {
+			t.Fatalf("第%d路选项 = %+v", i, got)
+		}
+		seeds[*got.Seed] = true
+	}
+	if len(seeds) != 3 || options.Seed != nil {
+		t.Errorf("每一路应使用不同的seed且不修改原选项: %v", seeds)
+	}
+
+	// 请求指定seed时依次递增
+	seed := 42
+	if got := fanOutOptions(&models.RequestOptions{Seed: &seed}, 2, 3, true); *got.Seed != 44 {
+		t.Errorf("seed = %d, want 44", *got.Seed)
+	}
+	// 录制与回放时未指定seed也使用固定的seed
+	if got := fanOutOptions(options, 1, 3, true); *got.Seed != 1 {
+		t.Errorf("录制与回放时 seed = %d, want 1", *got.Seed)
+	}
+}
+
+func TestRankByLogprob(t *testing.T) {
+	resp := func(text string, logprobs ...float64) map[string]interface{} {
+		var entries []interface{}
+		for _, logprob := range logprobs {
+			entries = append(entries, map[string]interface{}{"logprob": logprob})
+		}
+		return map[string]interface{}{"response": text, "logprobs": entries}
+	}
+	ranked := rankByLogprob([]map[string]interface{}{resp("a", -2, -2), resp("b", -0.5, -1.5), resp("c", -0.1)})
+	var order string
+	for _, r := range ranked {
+		order += r["response"].(string)
+	}
+	if order != "cba" {
+		t.Errorf("order = %s, want cba", order)
+	}
+
+	// 任一候选没有logprobs时保持原顺序
+	unranked := []map[string]interface{}{resp("a", -2), {"response": "b"}}
+	if ranked := rankByLogprob(unranked); ranked[0]["response"] != "a" {
+		t.Errorf("没有logprobs时应保持原顺序")
+	}
+}
+
+func TestOpenAIChatFanOut(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","n":3,"messages":[{"role":"user","content":"hi"}]}`)
+	var result models.OpenAIChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Choices) != 3 {
+		t.Fatalf("choices = %d, want 3", len(result.Choices))
+	}
+	for i, choice := range result.Choices {
+		if choice.Index != i || choice.Message.Content != "echo: hi" {
+			t.Errorf("choice %d = %+v", i, choice)
+		}
+	}
+	// prompt只计算一次，completion_tokens为各路之和
+	if result.Usage.PromptTokens != 12 || result.Usage.CompletionTokens != 15 {
+		t.Errorf("usage = %+v", result.Usage)
+	}
+
+	seeds := map[float64]bool{}
+	for _, req := range fake.received("/api/chat") {
+		options, _ := req.Body["options"].(map[string]interface{})
+		seed, _ := options["seed"].(float64)
+		seeds[seed] = true
+	}
+	if len(seeds) != 3 {
+		t.Errorf("Ollama应收到3个seed不同的请求: %v", seeds)
+	}
+}
+
+func TestOpenAIChatFanOutStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","n":2,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	contents := map[int]string{}
+	ids := map[string]bool{}
+	for _, chunk := range chatChunks(t, readSSE(t, resp.Body)) {
+		ids[chunk.ID] = true
+		for _, choice := range chunk.Choices {
+			contents[choice.Index] += choice.Delta.Content
+		}
+	}
+	if len(contents) != 2 || contents[0] != "echo: hi" || contents[1] != "echo: hi" {
+		t.Errorf("contents = %v", contents)
+	}
+	if len(ids) != 1 {
+		t.Errorf("多路生成的分块应使用同一个id: %v", ids)
+	}
+}
+
+func TestOpenAICompletionBestOf(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/generate",
+		generateWithLogprobs("so so", -1.5),
+		generateWithLogprobs("the best", -0.1),
+		generateWithLogprobs("bad", -3),
+	)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":"hi","best_of":3}`)
+	result := decodeJSON(t, resp)
+	choices, _ := result["choices"].([]interface{})
+	if len(choices) != 1 {
+		t.Fatalf("choices = %v", result)
+	}
+	choice := choices[0].(map[string]interface{})
+	if choice["text"] != "the best" {
+		t.Errorf("text = %v, want the best", choice["text"])
+	}
+	// 客户端没有请求logprobs，不返回排序用的对数概率
+	if choice["logprobs"] != nil {
+		t.Errorf("logprobs = %v, want nil", choice["logprobs"])
+	}
+
+	received := fake.received("/api/generate")
+	if len(received) != 3 || received[0].Body["logprobs"] != true {
+		t.Errorf("应生成3个请求logprobs的候选: %+v", received)
+	}
+}
+
+func TestOpenAICompletionBestOfStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":"hi","best_of":2,"stream":true}`)
+	if got := decodeJSON(t, resp)["error"]; got != "stream模式下不支持best_of" {
+		t.Errorf("error = %v", got)
+	}
+	if got := len(fake.received("/api/generate")); got != 0 {
+		t.Errorf("Ollama收到 %d 个请求, want 0", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HeaderConfig 代理与上游之间的请求头和响应头配置
+type HeaderConfig struct {
+	// Allow 转发给上游的客户端请求头，为空时转发除deny和默认排除之外的所有请求头
+	// 明确列出的Authorization、Cookie等默认不转发的请求头也会被转发
+	Allow []string `yaml:"allow"`
+	// Deny 不转发给上游的客户端请求头
+	Deny []string `yaml:"deny"`
+	// Set 添加到所有上游请求的请求头，用于向上游的认证网关注入凭证
+	Set map[string]string `yaml:"set"`
+	// ResponseDeny 不返回给客户端的上游响应头
+	ResponseDeny []string `yaml:"response_deny"`
+}
+
+// hopByHopHeaders 只对单个连接有效的请求头和响应头，不能由代理转发（RFC 9110 7.6.1）
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// defaultDeniedRequestHeaders 默认不转发给上游的客户端请求头
+// Authorization和Cookie是客户端访问代理的凭证，上游需要凭证时通过set注入
+// 代理需要解析上游响应，Accept-Encoding由Go的HTTP客户端自行处理
+var defaultDeniedRequestHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Accept-Encoding",
+	sessionHeader,
+}
+
+// removeHopByHop 删除逐跳头部以及Connection中列出的头部
+func removeHopByHop(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// upstreamRequestHeader 按配置过滤需要转发给上游的客户端请求头
+func upstreamRequestHeader(cfg HeaderConfig, client http.Header) http.Header {
+	header := client.Clone()
+	removeHopByHop(header)
+	if len(cfg.Allow) > 0 {
+		allowed := http.Header{}
+		for _, name := range cfg.Allow {
+			if values := header.Values(name); len(values) > 0 {
+				allowed[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		header = allowed
+	} else {
+		for _, name := range defaultDeniedRequestHeaders {
+			header.Del(name)
+		}
+	}
+	for _, name := range cfg.Deny {
+		header.Del(name)
+	}
+	return header
+}
+
+// applyUpstreamHeaders 删除逐跳头部并添加配置的请求头，所有发往上游的请求都会经过这里
+func applyUpstreamHeaders(cfg HeaderConfig, header http.Header) {
+	removeHopByHop(header)
+	for name, value := range cfg.Set {
+		header.Set(name, value)
+	}
+}
+
+// copyResponseHeader 将上游响应头复制给客户端
+// 代理可能会改写响应体，也可能以流的方式返回，因此不复制Content-Length
+func copyResponseHeader(c *gin.Context, cfg HeaderConfig, upstream http.Header) {
+	header := upstream.Clone()
+	removeHopByHop(header)
+	header.Del("Content-Length")
+	for _, name := range cfg.ResponseDeny {
+		header.Del(name)
+	}
+	for name, values := range header {
+		c.Writer.Header()[name] = values
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestForwardedRequestHeaders(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`  headers:
+    deny: ["X-Internal-Trace"]
+    set:
+      X-Gateway-Key: "gateway-secret"
+`)
+
+	req, _ := http.NewRequest(http.MethodPost, proxy.URL+"/api/chat",
+		strings.NewReader(`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("X-Request-ID", "req-1")
+	req.Header.Set("X-Internal-Trace", "trace-1")
+	req.Header.Set("X-Drop-Me", "1")
+	req.Header.Set("Connection", "X-Drop-Me")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	received := fake.received("/api/chat")
+	if len(received) != 1 {
+		t.Fatalf("received %d requests", len(received))
+	}
+	header := received[0].Header
+	for _, name := range []string{"Authorization", "Cookie", "X-Internal-Trace", "X-Drop-Me"} {
+		if value := header.Get(name); value != "" {
+			t.Errorf("%s = %q，不应转发给上游", name, value)
+		}
+	}
+	if got := header.Get("X-Request-ID"); got != "req-1" {
+		t.Errorf("X-Request-ID = %q, want req-1", got)
+	}
+	if got := header.Get("X-Gateway-Key"); got != "gateway-secret" {
+		t.Errorf("X-Gateway-Key = %q, want injected credential", got)
+	}
+}
+
+func TestForwardedRequestHeadersAllowList(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`  headers:
+    allow: ["X-Request-ID", "Authorization"]
+`)
+
+	req, _ := http.NewRequest(http.MethodGet, proxy.URL+"/api/tags", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("X-Request-ID", "req-2")
+	req.Header.Set("X-Other", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	header := fake.received("/api/tags")[0].Header
+	if header.Get("X-Request-ID") != "req-2" || header.Get("X-Other") != "" {
+		t.Errorf("只应转发allow中的请求头: %v", header)
+	}
+	if header.Get("Authorization") != "Bearer "+testToken {
+		t.Errorf("allow中明确列出的Authorization应转发")
+	}
+}
+
+func TestUpstreamResponseHeaders(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/generate", fakeReply{
+		Header: http.Header{
+			"Content-Length": {"9999"},
+			"Keep-Alive":     {"timeout=5"},
+			"X-Ollama-Node":  {"gpu-1"},
+			"Server":         {"ollama"},
+		},
+		Chunks: []fakeChunk{
+			{Line: generateLine("qwen2.5:7b", "Hello", false)},
+			{Line: generateLine("qwen2.5:7b", "", true)},
+		},
+	})
+	proxy := newTestProxy(t, testConfig(fake.URL())+`  headers:
+    response_deny: ["Server"]
+`)
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/generate", `{"model":"qwen2.5:7b","prompt":"hi","stream":true}`)
+	if resp.Header.Get("Content-Length") != "" || resp.Header.Get("Keep-Alive") != "" {
+		t.Errorf("流式响应不应带有上游的Content-Length和逐跳头部: %v", resp.Header)
+	}
+	if resp.Header.Get("Server") != "" {
+		t.Errorf("response_deny中的响应头不应返回")
+	}
+	if resp.Header.Get("X-Ollama-Node") != "gpu-1" {
+		t.Errorf("X-Ollama-Node = %q", resp.Header.Get("X-Ollama-Node"))
+	}
+
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	if err := scanner.Err(); err != nil || lines != 2 {
+		t.Errorf("读取到 %d 行, err = %v", lines, err)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 版本信息，发布时通过 -ldflags "-X main.version=..." 设置
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
+// startedAt 服务启动时间
+var startedAt = time.Now()
+
+// healthProbeTimeout 探测单个后端的超时时间
+const healthProbeTimeout = 3 * time.Second
+
+// backendStatus 后端的探测结果
+type backendStatus struct {
+	Name      string `json:"name"`
+	BaseURL   string `json:"base_url"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	// Version 后端的Ollama版本
+	Version string `json:"version,omitempty"`
+	// LoadedModels 已加载到内存的模型，来自/api/ps
+	LoadedModels []loadedModel `json:"loaded_models"`
+	// QueueDepth 代理发往该后端、尚未完成的请求数
+	QueueDepth     int64  `json:"queue_depth"`
+	CircuitBreaker string `json:"circuit_breaker"`
+	Failures       int    `json:"consecutive_failures"`
+}
+
+// loadedModel /api/ps返回的模型
+type loadedModel struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	SizeVRAM  int64  `json:"size_vram"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// handleHealthz 进程存活检查，不访问后端
+func handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// handleReadyz 就绪检查，配置有效且至少一个后端可以访问时返回200
+// 该接口不需要认证，只返回后端名称和是否可以访问
+func handleReadyz(c *gin.Context) {
+	config, err := loadConfig()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "配置文件无效"})
+		return
+	}
+
+	ready := false
+	backends := gin.H{}
+	for _, status := range probeBackends(c.Request.Context(), config, false) {
+		backends[status.Name] = status.Reachable
+		ready = ready || status.Reachable
+	}
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "backends": backends})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "backends": backends})
+}
+
+// handleStatus 返回版本信息和各后端的详细状态
+func handleStatus(c *gin.Context) {
+	config, err := loadConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"error": "无法读取配置文件"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"version": gin.H{
+			"version":    version,
+			"commit":     commit,
+			"date":       date,
+			"go_version": runtime.Version(),
+		},
+		"uptime_seconds":  int64(time.Since(startedAt).Seconds()),
+		"active_requests": atomic.LoadInt64(&activeRequests),
+		"backends":        probeBackends(c.Request.Context(), config, true),
+	})
+}
+
+// probeBackends 并发探测所有后端，detailed为true时同时查询已加载的模型
+// 探测请求直接发送到后端，不经过重试和熔断
+func probeBackends(ctx context.Context, config *Config, detailed bool) []backendStatus {
+	backends := upstreamBackends(config)
+	statuses := make([]backendStatus, len(backends))
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, backend BackendConfig) {
+			defer wg.Done()
+			statuses[i] = probeBackend(ctx, config, backend, detailed)
+		}(i, backend)
+	}
+	wg.Wait()
+	return statuses
+}
+
+func probeBackend(ctx context.Context, config *Config, backend BackendConfig, detailed bool) backendStatus {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	status := backendStatus{
+		Name:         backend.Name,
+		BaseURL:      backend.BaseURL,
+		LoadedModels: []loadedModel{},
+		QueueDepth:   inFlightRequests(backend.BaseURL),
+	}
+	status.CircuitBreaker, status.Failures = breakerFor(backend.BaseURL, config.Service.CircuitBreaker).snapshot()
+
+	start := time.Now()
+	if isOpenAIBackend(backend) {
+		// OpenAI兼容后端没有版本和运行中模型的接口，只检查/v1/models
+		err := getBackendJSON(ctx, config, backend, "/v1/models", &struct{}{})
+		status.LatencyMS = time.Since(start).Milliseconds()
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.Reachable = true
+		}
+		return status
+	}
+	var versionResp struct {
+		Version string `json:"version"`
+	}
+	err := getBackendJSON(ctx, config, backend, "/api/version", &versionResp)
+	status.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.Reachable = true
+	status.Version = versionResp.Version
+
+	if detailed {
+		var psResp struct {
+			Models []loadedModel `json:"models"`
+		}
+		if err := getBackendJSON(ctx, config, backend, "/api/ps", &psResp); err != nil {
+			status.Error = err.Error()
+		} else if psResp.Models != nil {
+			status.LoadedModels = psResp.Models
+		}
+	}
+	return status
+}
+
+// getBackendJSON 向后端发送GET请求并解析JSON响应
+func getBackendJSON(ctx context.Context, config *Config, backend BackendConfig, path string, out interface{}) error {
+	resp, err := sendToBackend(ctx, config, backend, http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s 返回状态码 %d", path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runHealthcheck 访问第一个监听地址的/readyz，用于容器健康检查，返回进程退出码
+func runHealthcheck() int {
+	config, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "无法读取配置文件: %v\n", err)
+		return 1
+	}
+
+	scheme := "http"
+	if config.Server.TLS.Enabled {
+		scheme = "https"
+	}
+	client, err := healthcheckClient(config.Server.TLS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "健康检查失败: %v\n", err)
+		return 1
+	}
+	resp, err := client.Get(scheme + "://" + loopbackAddr(listenAddrs(config)[0]) + "/readyz")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "健康检查失败: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "健康检查失败: 状态码 %d\n", resp.StatusCode)
+		return 1
+	}
+	return 0
+}
+
+// healthcheckClient 返回健康检查使用的HTTP客户端，开启mTLS时携带配置的客户端证书
+func healthcheckClient(cfg ServerTLSConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	if !cfg.Enabled {
+		return client, nil
+	}
+	// 访问的是本机地址，证书中通常不包含该地址
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if cfg.HealthcheckCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.HealthcheckCertFile, cfg.HealthcheckKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载健康检查客户端证书: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if cfg.ClientCAFile != "" && strings.EqualFold(cfg.ClientAuth, "require") {
+		return nil, fmt.Errorf("client_auth为require时需要配置healthcheck_cert_file和healthcheck_key_file")
+	}
+	client.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	return client, nil
+}
+
+// loopbackAddr 将监听地址转换为本机可以访问的地址
+func loopbackAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
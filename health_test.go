@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHealthz(t *testing.T) {
+	proxy := newTestProxy(t, testConfig("http://127.0.0.1:1"))
+
+	resp, err := http.Get(proxy.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || decodeJSON(t, resp)["status"] != "ok" {
+		t.Errorf("status = %d, want 200 ok", resp.StatusCode)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	fake := newFakeOllama(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  backends:
+    - name: primary
+      base_url: %q
+    - name: secondary
+      base_url: %q
+`, testToken, down.URL, fake.URL()))
+
+	// 不携带token也可以访问
+	resp, err := http.Get(proxy.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 when one backend is reachable", resp.StatusCode)
+	}
+	backends, _ := decodeJSON(t, resp)["backends"].(map[string]interface{})
+	if backends["primary"] != false || backends["secondary"] != true {
+		t.Errorf("backends = %v", backends)
+	}
+	if len(fake.received("/api/version")) != 1 {
+		t.Errorf("就绪检查应探测后端的/api/version")
+	}
+}
+
+func TestReadyzUnavailable(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	proxy := newTestProxy(t, testConfig(down.URL))
+
+	resp, err := http.Get(proxy.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503 when no backend is reachable", resp.StatusCode)
+	}
+
+	// 配置文件无效时同样不可用
+	if err := os.WriteFile(configFile, []byte("auth: ["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.Get(proxy.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || decodeJSON(t, resp)["error"] != "配置文件无效" {
+		t.Errorf("status = %d, want 503 for invalid config", resp.StatusCode)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+"  circuit_breaker:\n    failure_threshold: 3\n")
+
+	resp, err := http.Get(proxy.URL + "/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := decodeJSON(t, resp)["error"]; got != "未提供认证token" {
+		t.Errorf("未认证时 error = %v", got)
+	}
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/status", ""))
+	if info, _ := result["version"].(map[string]interface{}); info["version"] != version {
+		t.Errorf("version = %v", result["version"])
+	}
+	backends, _ := result["backends"].([]interface{})
+	if len(backends) != 1 {
+		t.Fatalf("backends = %v", result["backends"])
+	}
+	backend := backends[0].(map[string]interface{})
+	if backend["reachable"] != true || backend["version"] != "0.5.7" || backend["circuit_breaker"] != breakerClosed {
+		t.Errorf("backend = %v", backend)
+	}
+	models, _ := backend["loaded_models"].([]interface{})
+	if len(models) != 1 || models[0].(map[string]interface{})["name"] != "qwen2.5:7b" {
+		t.Errorf("loaded_models = %v", backend["loaded_models"])
+	}
+	if backend["queue_depth"] != float64(0) {
+		t.Errorf("queue_depth = %v, want 0 after probes finished", backend["queue_depth"])
+	}
+}
+
+func TestLoopbackAddr(t *testing.T) {
+	tests := map[string]string{
+		":8080":          "127.0.0.1:8080",
+		"0.0.0.0:8080":   "127.0.0.1:8080",
+		"[::]:8080":      "127.0.0.1:8080",
+		"10.0.0.5:9000":  "10.0.0.5:9000",
+		"localhost:8080": "localhost:8080",
+	}
+	for addr, want := range tests {
+		if got := loopbackAddr(addr); got != want {
+			t.Errorf("loopbackAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestHealthcheckClientCert(t *testing.T) {
+	fake := newFakeOllama(t)
+	ca := newTestCA(t)
+	certFile, keyFile := ca.issue("healthcheck")
+	tlsConfig := fmt.Sprintf("    enabled: true\n    self_signed: true\n    client_ca_file: %q\n    client_auth: require\n", ca.File)
+	proxy := newTLSProxy(t, "server:\n  tls:\n"+tlsConfig+testConfig(fake.URL()))
+
+	// 未配置客户端证书时给出明确的错误，而不是TLS握手失败
+	if _, err := healthcheckClient(ServerTLSConfig{Enabled: true, ClientCAFile: ca.File, ClientAuth: "require"}); err == nil {
+		t.Errorf("client_auth为require且未配置证书时应返回错误")
+	}
+
+	client, err := healthcheckClient(ServerTLSConfig{
+		Enabled:             true,
+		ClientCAFile:        ca.File,
+		ClientAuth:          "require",
+		HealthcheckCertFile: certFile,
+		HealthcheckKeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(proxy.URL + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HookConfig 外部策略钩子配置
+type HookConfig struct {
+	Name string `yaml:"name"`
+	// Type 钩子类型，目前只支持webhook，不支持进程内插件或WASM模块
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+	// Stage 调用时机：pre（请求发送前）/ post（响应返回前）/ both，默认为pre
+	Stage string `yaml:"stage"`
+	// Models 生效的模型，支持*通配符，为空时对所有模型生效
+	Models []string `yaml:"models"`
+	// Headers 调用钩子时附加的请求头，例如认证信息
+	Headers map[string]string `yaml:"headers"`
+	// Timeout 调用超时时间，默认为5s
+	Timeout time.Duration `yaml:"timeout"`
+	// FailOpen 钩子调用失败或超时时是否放行，默认拒绝请求
+	FailOpen bool `yaml:"fail_open"`
+}
+
+const (
+	hookStagePre  = "pre"
+	hookStagePost = "post"
+
+	defaultHookTimeout = 5 * time.Second
+)
+
+// 钩子返回的处理结果
+const (
+	hookAllow  = "allow"
+	hookDeny   = "deny"
+	hookModify = "modify"
+)
+
+// hookPayload 发送给钩子的内容
+type hookPayload struct {
+	Stage    string `json:"stage"`
+	Endpoint string `json:"endpoint"`
+	Identity string `json:"identity"`
+	Model    string `json:"model"`
+	// Request 发送给Ollama的请求
+	Request interface{} `json:"request"`
+	// Response Ollama的响应，仅post阶段
+	Response interface{} `json:"response,omitempty"`
+}
+
+// hookResult 钩子返回的结果，modify时request或response为替换后的内容
+type hookResult struct {
+	Action   string          `json:"action"`
+	Message  string          `json:"message"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+// validateHooks 检查钩子配置，目前只支持webhook类型，不支持进程内插件或WASM模块
+func validateHooks(hooks []HookConfig) error {
+	for _, hook := range hooks {
+		if hook.Type != "" && hook.Type != "webhook" {
+			return fmt.Errorf("策略钩子 %s 的类型 %s 不受支持，目前只支持webhook", hook.Name, hook.Type)
+		}
+		if hook.URL == "" {
+			return fmt.Errorf("策略钩子 %s 未配置url", hook.Name)
+		}
+	}
+	return nil
+}
+
+// runPreHooks 依次调用请求前钩子，request为指向请求的指针，modify时替换为钩子返回的内容
+// 返回错误表示请求被拒绝
+func runPreHooks(config *Config, c *gin.Context, model string, request interface{}) error {
+	for _, hook := range config.Hooks {
+		if !hookEnabled(hook, hookStagePre, model) {
+			continue
+		}
+		result, err := callHook(c, hook, hookPayload{
+			Stage:    hookStagePre,
+			Endpoint: c.Request.URL.Path,
+			Identity: c.GetString(identityKey),
+			Model:    model,
+			Request:  request,
+		})
+		if err != nil {
+			if hook.FailOpen {
+				continue
+			}
+			return err
+		}
+		if err := applyHookResult(hook, result, result.Request, request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPostHooks 依次调用响应后钩子，response为指向Ollama响应的指针，modify时替换为钩子返回的内容
+// 返回错误表示响应被拒绝
+func runPostHooks(config *Config, c *gin.Context, model string, request, response interface{}) error {
+	for _, hook := range config.Hooks {
+		if !hookEnabled(hook, hookStagePost, model) {
+			continue
+		}
+		result, err := callHook(c, hook, hookPayload{
+			Stage:    hookStagePost,
+			Endpoint: c.Request.URL.Path,
+			Identity: c.GetString(identityKey),
+			Model:    model,
+			Request:  request,
+			Response: response,
+		})
+		if err != nil {
+			if hook.FailOpen {
+				continue
+			}
+			return err
+		}
+		if err := applyHookResult(hook, result, result.Response, response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasPostHooks 判断是否有对该模型生效的响应后钩子
+func hasPostHooks(config *Config, model string) bool {
+	for _, hook := range config.Hooks {
+		if hookEnabled(hook, hookStagePost, model) {
+			return true
+		}
+	}
+	return false
+}
+
+func hookEnabled(hook HookConfig, stage, model string) bool {
+	hookStage := hook.Stage
+	if hookStage == "" {
+		hookStage = hookStagePre
+	}
+	if hookStage != stage && hookStage != "both" {
+		return false
+	}
+	return matchPolicyModel(hook.Models, model)
+}
+
+// applyHookResult 处理钩子返回的结果，modify时将replacement解析到target
+func applyHookResult(hook HookConfig, result *hookResult, replacement json.RawMessage, target interface{}) error {
+	switch result.Action {
+	case hookAllow, "":
+		return nil
+	case hookDeny:
+		message := result.Message
+		if message == "" {
+			message = defaultBlockMessage
+		}
+		return fmt.Errorf("%s", message)
+	case hookModify:
+		if len(replacement) == 0 {
+			return nil
+		}
+		// 解析到新的值再整体替换，避免与原内容合并
+		value := reflect.New(reflect.TypeOf(target).Elem())
+		if err := json.Unmarshal(replacement, value.Interface()); err != nil {
+			return fmt.Errorf("策略钩子 %s 返回的内容无效: %v", hook.Name, err)
+		}
+		reflect.ValueOf(target).Elem().Set(value.Elem())
+		return nil
+	default:
+		return fmt.Errorf("策略钩子 %s 返回了不支持的action: %s", hook.Name, result.Action)
+	}
+}
+
+// callHook 调用钩子，调用失败时记录日志，日志中不包含请求和响应内容
+func callHook(c *gin.Context, hook HookConfig, payload hookPayload) (*hookResult, error) {
+	result, err := sendHook(c.Request.Context(), hook, payload)
+	if err != nil {
+		err = fmt.Errorf("策略钩子 %s 调用失败: %v", hook.Name, err)
+		logger.LogRequest(http.MethodPost, hook.URL, nil, nil, err, payload.Identity, false)
+	}
+	return result, err
+}
+
+// hookStreams 有响应后钩子时读取完整的流式响应，合并为一个响应后调用钩子，
+// 返回只包含一行合并结果的流。需要在输出任何内容之前调用，返回错误表示响应被拒绝
+func hookStreams(config *Config, c *gin.Context, model string, requests []interface{}, bodies []io.ReadCloser) ([]io.ReadCloser, error) {
+	if !hasPostHooks(config, model) {
+		return bodies, nil
+	}
+	result := make([]io.ReadCloser, len(bodies))
+	for i, body := range bodies {
+		response := mergeStream(body)
+		if _, failed := response["error"]; !failed {
+			if err := runPostHooks(config, c, model, requests[i], &response); err != nil {
+				return nil, err
+			}
+		}
+		data, _ := json.Marshal(response)
+		result[i] = io.NopCloser(bytes.NewReader(append(data, '\n')))
+	}
+	return result, nil
+}
+
+// mergeStream 读取完整的Ollama NDJSON流，合并为一个非流式响应：
+// 输出内容、工具调用和logprobs依次拼接，其余字段使用最后一行的值。出错时返回包含error的响应
+func mergeStream(r io.Reader) map[string]interface{} {
+	merged := map[string]interface{}{}
+	message := map[string]interface{}{}
+	texts := map[string]string{}
+	var toolCalls, logprobs []interface{}
+	appendText := func(fields map[string]interface{}, prefix string, keys ...string) {
+		for _, key := range keys {
+			if text, ok := fields[key].(string); ok {
+				texts[prefix+key] += text
+			}
+		}
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return map[string]interface{}{"error": fmt.Sprintf("读取响应流出错: %v", err)}
+		}
+		var line map[string]interface{}
+		if len(bytes.TrimSpace(raw)) > 0 && json.Unmarshal(raw, &line) == nil {
+			if _, failed := line["error"]; failed {
+				return line
+			}
+			for key, value := range line {
+				merged[key] = value
+			}
+			appendText(line, "", "response", "thinking")
+			if fields, ok := line["message"].(map[string]interface{}); ok {
+				for key, value := range fields {
+					message[key] = value
+				}
+				appendText(fields, "message.", "content", "thinking")
+				if calls, ok := fields["tool_calls"].([]interface{}); ok {
+					toolCalls = append(toolCalls, calls...)
+				}
+			}
+			if items, ok := line["logprobs"].([]interface{}); ok {
+				logprobs = append(logprobs, items...)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+
+	for key, text := range texts {
+		if name, ok := strings.CutPrefix(key, "message."); ok {
+			message[name] = text
+		} else {
+			merged[key] = text
+		}
+	}
+	if _, ok := merged["message"]; ok {
+		if toolCalls != nil {
+			message["tool_calls"] = toolCalls
+		}
+		merged["message"] = message
+	}
+	if logprobs != nil {
+		merged["logprobs"] = logprobs
+	}
+	return merged
+}
+
+func sendHook(ctx context.Context, hook HookConfig, payload hookPayload) (*hookResult, error) {
+	if hook.Type != "" && hook.Type != "webhook" {
+		return nil, fmt.Errorf("不支持的钩子类型: %s", hook.Type)
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range hook.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("返回状态码 %d", resp.StatusCode)
+	}
+
+	var result hookResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeHook 记录收到的钩子请求，按阶段返回预设的结果
+type fakeHook struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+	results  map[string]string
+}
+
+func newFakeHook(t *testing.T, results map[string]string) *fakeHook {
+	t.Helper()
+	hook := &fakeHook{results: results}
+	hook.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		hook.mu.Lock()
+		hook.payloads = append(hook.payloads, payload)
+		hook.mu.Unlock()
+		result, ok := results[fmt.Sprint(payload["stage"])]
+		if !ok {
+			result = `{"action":"allow"}`
+		}
+		w.Write([]byte(result))
+	}))
+	t.Cleanup(hook.Close)
+	return hook
+}
+
+// received 返回指定阶段收到的钩子请求
+func (h *fakeHook) received(stage string) []map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var payloads []map[string]interface{}
+	for _, payload := range h.payloads {
+		if payload["stage"] == stage {
+			payloads = append(payloads, payload)
+		}
+	}
+	return payloads
+}
+
+// hookConfig 配置一个pre和post阶段都生效的钩子
+func hookConfig(upstream, hookURL string) string {
+	return testConfig(upstream) + fmt.Sprintf("hooks:\n  - name: moderation\n    type: webhook\n    url: %q\n    stage: both\n", hookURL)
+}
+
+func TestHookPreDeny(t *testing.T) {
+	fake := newFakeOllama(t)
+	hook := newFakeHook(t, map[string]string{"pre": `{"action":"deny","message":"不允许讨论该话题"}`})
+	proxy := newTestProxy(t, hookConfig(fake.URL(), hook.URL))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if errObj, _ := decodeJSON(t, resp)["error"].(map[string]interface{}); errObj["message"] != "不允许讨论该话题" {
+		t.Errorf("error = %v", errObj)
+	}
+	if got := len(fake.received("/api/chat")); got != 0 {
+		t.Errorf("被拒绝的请求不应发送到Ollama, 收到 %d 个请求", got)
+	}
+}
+
+func TestHookPayloadIdentity(t *testing.T) {
+	fake := newFakeOllama(t)
+	hook := newFakeHook(t, nil)
+	proxy := newTestProxy(t, hookConfig(fake.URL(), hook.URL))
+
+	doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	payloads := hook.received("pre")
+	if len(payloads) != 1 {
+		t.Fatalf("钩子收到 %d 个pre请求, want 1", len(payloads))
+	}
+	identity, _ := payloads[0]["identity"].(string)
+	if identity != tokenIdentity(testToken) || strings.Contains(identity, testToken) {
+		t.Errorf("identity = %q, 不应包含token原文", identity)
+	}
+}
+
+func TestHookPostStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	hook := newFakeHook(t, map[string]string{"post": `{"action":"deny","message":"响应包含敏感内容"}`})
+	proxy := newTestProxy(t, hookConfig(fake.URL(), hook.URL))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if errObj, _ := decodeJSON(t, resp)["error"].(map[string]interface{}); errObj["message"] != "响应包含敏感内容" {
+		t.Errorf("error = %v", errObj)
+	}
+
+	payloads := hook.received("post")
+	if len(payloads) != 1 {
+		t.Fatalf("钩子收到 %d 个post请求, want 1", len(payloads))
+	}
+	// 钩子收到合并后的完整响应
+	response, _ := payloads[0]["response"].(map[string]interface{})
+	message, _ := response["message"].(map[string]interface{})
+	if message["content"] != "echo: hi" || response["done"] != true {
+		t.Errorf("response = %v", response)
+	}
+}
+
+func TestHookPostDefaultStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	hook := newFakeHook(t, map[string]string{
+		"post": `{"action":"modify","response":{"model":"qwen2.5:7b","message":{"role":"assistant","content":"已审核"},"done":true}}`,
+	})
+	proxy := newTestProxy(t, hookConfig(fake.URL(), hook.URL))
+
+	// 原生接口未指定stream时Ollama默认流式返回
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("无效的响应行 %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("响应有 %d 行, want 1", len(lines))
+	}
+	if message, _ := lines[0]["message"].(map[string]interface{}); message["content"] != "已审核" {
+		t.Errorf("响应 = %v, 应为钩子替换后的内容", lines[0])
+	}
+
+	payloads := hook.received("post")
+	if len(payloads) != 1 {
+		t.Fatalf("钩子收到 %d 个post请求, want 1", len(payloads))
+	}
+	response, _ := payloads[0]["response"].(map[string]interface{})
+	if message, _ := response["message"].(map[string]interface{}); message["content"] != "echo: hi" {
+		t.Errorf("钩子收到的响应 = %v", response)
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	if err := validateHooks([]HookConfig{{Name: "moderation", URL: "http://127.0.0.1:9000"}}); err != nil {
+		t.Errorf("validateHooks() = %v", err)
+	}
+	writeTestConfig(t, testConfig("http://127.0.0.1:1"))
+	for _, hook := range []string{"type: wasm\n    url: \"http://127.0.0.1:9000\"", "type: webhook"} {
+		if err := os.WriteFile(configFile, []byte("hooks:\n  - name: moderation\n    "+hook+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadConfig(); err == nil {
+			t.Errorf("%q 应返回错误", hook)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// withLogprobs 为一行Ollama响应添加单个token的logprobs
+func withLogprobs(line, token string, logprob float64) string {
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(line), &resp)
+	resp["logprobs"] = []map[string]interface{}{{
+		"token":        token,
+		"logprob":      logprob,
+		"top_logprobs": []map[string]interface{}{{"token": token, "logprob": logprob}, {"token": "?", "logprob": -9.0}},
+	}}
+	return mustJSON(resp)
+}
+
+func TestOpenAIChatLogprobs(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Body: withLogprobs(chatLine("qwen2.5:7b", "yes", true), "yes", -0.01)})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"logprobs":true,"top_logprobs":2,"messages":[{"role":"user","content":"hi"}]}`)
+	var result struct {
+		Choices []struct {
+			Logprobs struct {
+				Content []struct {
+					Token       string  `json:"token"`
+					Logprob     float64 `json:"logprob"`
+					Bytes       []int   `json:"bytes"`
+					TopLogprobs []struct {
+						Token string `json:"token"`
+					} `json:"top_logprobs"`
+				} `json:"content"`
+			} `json:"logprobs"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	content := result.Choices[0].Logprobs.Content
+	if len(content) != 1 || content[0].Token != "yes" || content[0].Logprob != -0.01 || len(content[0].Bytes) != 3 || len(content[0].TopLogprobs) != 2 {
+		t.Errorf("logprobs = %+v", content)
+	}
+
+	body := fake.received("/api/chat")[0].Body
+	if body["logprobs"] != true || body["top_logprobs"] != float64(2) {
+		t.Errorf("Ollama请求 logprobs=%v top_logprobs=%v", body["logprobs"], body["top_logprobs"])
+	}
+}
+
+func TestOpenAIChatWithoutLogprobs(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	choices, _ := decodeJSON(t, resp)["choices"].([]interface{})
+	if choice := choices[0].(map[string]interface{}); choice["logprobs"] != nil {
+		t.Errorf("未请求时logprobs应为null: %v", choice["logprobs"])
+	}
+	if _, ok := fake.received("/api/chat")[0].Body["logprobs"]; ok {
+		t.Errorf("未请求时不应向Ollama请求logprobs")
+	}
+}
+
+func TestOpenAICompletionLogprobsStream(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/generate", fakeReply{Chunks: []fakeChunk{
+		{Line: withLogprobs(generateLine("qwen2.5:7b", "你好", false), "你好", -0.5)},
+		{Line: withLogprobs(generateLine("qwen2.5:7b", " world", false), " world", -1)},
+		{Line: generateLine("qwen2.5:7b", "", true)},
+	}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"qwen2.5:7b","prompt":"hi","stream":true,"logprobs":1}`)
+	var offsets []int
+	var tokens []string
+	for _, event := range readSSE(t, resp.Body) {
+		if event.Data == sseDone {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Logprobs *struct {
+					Tokens     []string `json:"tokens"`
+					TextOffset []int    `json:"text_offset"`
+				} `json:"logprobs"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if logprobs := chunk.Choices[0].Logprobs; logprobs != nil {
+			tokens = append(tokens, logprobs.Tokens...)
+			offsets = append(offsets, logprobs.TextOffset...)
+		}
+	}
+	// 后续分块的text_offset接着之前输出的字符数计算
+	if len(tokens) != 2 || tokens[1] != " world" || len(offsets) != 2 || offsets[0] != 0 || offsets[1] != 2 {
+		t.Errorf("tokens = %q, text_offset = %v", tokens, offsets)
+	}
+	if body := fake.received("/api/generate")[0].Body; body["logprobs"] != true || body["top_logprobs"] != float64(1) {
+		t.Errorf("Ollama请求 logprobs=%v top_logprobs=%v", body["logprobs"], body["top_logprobs"])
+	}
+}
@@ -1,16 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/douguohai/ollama-proxy/models"
 	"io"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/douguohai/ollama-proxy/base"
 	"github.com/gin-contrib/cors"
@@ -20,29 +26,77 @@ import (
 
 // Config 配置结构体
 type Config struct {
+	Server struct {
+		// Listen 监听地址，可以配置多个，默认为[":8080"]
+		Listen []string        `yaml:"listen"`
+		TLS    ServerTLSConfig `yaml:"tls"`
+		CORS   CORSConfig      `yaml:"cors"`
+		// MaxBodySize 请求体的最大字节数，0表示不限制
+		MaxBodySize int64 `yaml:"max_body_size"`
+		// TrustedProxies 信任的反向代理IP或CIDR，只有来自这些地址的X-Forwarded-For才会被用于获取客户端IP，默认不信任任何代理
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// SecurityHeaders 添加或覆盖默认的安全响应头，值为空时不添加该响应头
+		SecurityHeaders map[string]string `yaml:"security_headers"`
+		// ShutdownTimeout 关闭服务时等待进行中请求完成的最长时间，默认为30s
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+		// ReusePort 开启SO_REUSEPORT，用于新旧进程交替监听同一端口实现不停机升级
+		ReusePort bool `yaml:"reuse_port"`
+	} `yaml:"server"`
 	Auth struct {
-		GenerateTokens []string `yaml:"generate_tokens"`
-		ModelTokens    []string `yaml:"model_tokens"`
+		GenerateTokens []string             `yaml:"generate_tokens"`
+		ModelTokens    []string             `yaml:"model_tokens"`
+		ClientCerts    []ClientCertIdentity `yaml:"client_certs"`
 	} `yaml:"auth"`
 	Service struct {
-		BaseURL string `yaml:"base_url"`
+		BaseURL string            `yaml:"base_url"`
+		TLS     UpstreamTLSConfig `yaml:"tls"`
+		// Auth 访问上游时使用的认证信息，backends中未配置auth的后端同样使用该配置
+		Auth UpstreamAuthConfig `yaml:"auth"`
+		// Backends 按优先级排列的后端列表，配置后替代base_url，主后端不可用时依次故障转移
+		Backends       []BackendConfig      `yaml:"backends"`
+		Retry          RetryConfig          `yaml:"retry"`
+		CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+		// Traffic 上游流量录制与回放
+		Traffic TrafficConfig `yaml:"traffic"`
+		// Headers 转发给上游和返回给客户端的头部
+		Headers HeaderConfig `yaml:"headers"`
 	} `yaml:"service"`
+	Cache     CacheConfig `yaml:"cache"`
+	Embedding struct {
+		// BatchSize 单次发送给Ollama的最大输入条数
+		BatchSize int `yaml:"batch_size"`
+	} `yaml:"embedding"`
+	// Models 模型别名与按模型生效的默认配置
+	Models map[string]ModelConfig `yaml:"models"`
+	// Policies 按调用方和模型生效的请求策略
+	Policies []PolicyConfig `yaml:"policies"`
+	// Hooks 外部策略钩子
+	Hooks []HookConfig `yaml:"hooks"`
+	// Sessions 服务端会话
+	Sessions SessionConfig `yaml:"sessions"`
 }
 
-const (
-	configFile = "config.yaml"
-)
+// configFile 配置文件路径
+var configFile = "config.yaml"
 
 var logger *base.Logger
 
 func main() {
+	if path := os.Getenv(envConfigFile); path != "" {
+		configFile = path
+	}
+
+	// 容器健康检查：ollama-proxy healthcheck
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(runHealthcheck())
+	}
+
 	// 初始化日志记录器
 	var err error
 	logger, err = base.NewLogger()
 	if err != nil {
 		panic(err)
 	}
-	defer logger.Close()
 
 	// 读取配置文件
 	config, err := loadConfig()
@@ -50,8 +104,51 @@ func main() {
 		panic(err)
 	}
 
+	// 初始化响应缓存
+	if err := initResponseCache(config.Cache); err != nil {
+		panic(err)
+	}
+
+	// 初始化会话存储
+	if err := initSessionStore(config.Sessions); err != nil {
+		panic(err)
+	}
+
+	r := newRouter(config)
+
+	srv := &http.Server{
+		Handler: r,
+	}
+	if config.Server.TLS.Enabled {
+		srv.TLSConfig, err = buildServerTLSConfig(config.Server.TLS)
+		if err != nil {
+			panic(err)
+		}
+	}
+	lns, err := listen(config)
+	if err != nil {
+		panic(err)
+	}
+
+	// 收到SIGINT或SIGTERM后停止接受新连接，等待进行中的请求完成后再退出
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = serve(ctx, config, srv, lns)
+
+	// 写入剩余的日志
+	logger.Close()
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newRouter 创建路由，注册中间件和所有接口
+func newRouter(config *Config) *gin.Engine {
 	r := gin.New()
 	r.Use(gin.Logger())
+	r.Use(trackRequests())
+	// 请求体大小限制需要在日志中间件读取请求体之前生效
+	r.Use(limitBody(config.Server.MaxBodySize))
 	// 添加日志中间件
 	r.Use(logMiddleware())
 	// 添加全局异常处理
@@ -67,15 +164,11 @@ func main() {
 		c.Next()
 	})
 
-	// 配置CORS中间件
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
+	// 配置CORS中间件，配置已在loadConfig中校验
+	corsConfig, _ := buildCORSConfig(config.Server.CORS)
+	r.Use(cors.New(corsConfig))
+	r.Use(securityHeaders(config))
+	r.SetTrustedProxies(config.Server.TrustedProxies)
 
 	// API路由组，用于生成相关功能
 	api := r.Group("/api", authMiddleware(*config))
@@ -97,28 +190,58 @@ func main() {
 	{
 		// OpenAI风格的生成相关接口
 		openai.GET("/models", handleOpenAIModels)
-		openai.POST("/chat/completions", handleOpenAIChat)
-		openai.POST("/completions", handleOpenAICompletion)
-		openai.POST("/embeddings", handleOpenAIEmbedding)
+		openai.GET("/models/*model", handleOpenAIModel)
+		// 模型只由OpenAI兼容后端提供时直接转发，否则转换为Ollama接口
+		openai.POST("/chat/completions", openAIPassthrough("/v1/chat/completions"), handleOpenAIChat)
+		openai.POST("/completions", openAIPassthrough("/v1/completions"), handleOpenAICompletion)
+		openai.POST("/embeddings", openAIPassthrough("/v1/embeddings"), handleOpenAIEmbedding)
+	}
+
+	// 服务端会话管理接口
+	sessionGroup := r.Group("/sessions", authMiddleware(*config))
+	{
+		sessionGroup.GET("", handleListSessions)
+		sessionGroup.GET("/:id", handleGetSession)
+		sessionGroup.POST("/:id/fork", handleForkSession)
+		sessionGroup.DELETE("/:id", handleDeleteSession)
 	}
 
-	r.Run(":8080")
+	// 健康检查接口不需要认证，状态接口包含后端地址，需要认证
+	r.GET("/healthz", handleHealthz)
+	r.GET("/readyz", handleReadyz)
+	r.GET("/status", authMiddleware(*config), handleStatus)
+
+	return r
 }
 
 func loadConfig() (*Config, error) {
-	// 读取配置文件
+	// 读取配置文件，只通过环境变量配置时可以没有配置文件
+	var config Config
 	data, err := os.ReadFile(configFile)
-	if err != nil {
+	if err != nil && !(os.IsNotExist(err) && hasEnvOverrides()) {
 		return nil, err
 	}
 
 	// 解析YAML配置
-	var config Config
 	err = yaml.Unmarshal(data, &config)
 	if err != nil {
 		return nil, err
 	}
 
+	// 环境变量优先于配置文件
+	if err := applyEnvOverrides(&config); err != nil {
+		return nil, err
+	}
+	if _, err := buildCORSConfig(config.Server.CORS); err != nil {
+		return nil, err
+	}
+	if err := validateTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if err := validateHooks(config.Hooks); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
@@ -128,6 +251,22 @@ func authMiddleware(config Config) gin.HandlerFunc {
 		// 获取请求头中的token
 		token := c.GetHeader("Authorization")
 		if token == "" {
+			// 未携带token时尝试使用mTLS客户端证书认证
+			if identity := clientCertIdentity(config, c); identity != nil {
+				valid := identity.Scope == "" || identity.Scope == "generate"
+				logger.LogRequest(c.Request.Method, c.Request.URL.Path, nil, nil, nil, "cert:"+identity.Name, valid)
+				if !valid {
+					c.JSON(http.StatusOK, gin.H{
+						"error": "非授权访问",
+					})
+					c.Abort()
+					return
+				}
+				c.Set(identityKey, "cert:"+identity.Name)
+				c.Next()
+				return
+			}
+
 			// 记录未提供token的情况
 			logger.LogRequest(c.Request.Method, c.Request.URL.Path, nil, nil, fmt.Errorf("未提供认证token"), "", false)
 			c.JSON(http.StatusOK, gin.H{
@@ -142,8 +281,9 @@ func authMiddleware(config Config) gin.HandlerFunc {
 
 		// 根据路由组判断使用哪种token验证
 		var validToken = false
-		if strings.HasPrefix(c.Request.URL.Path, "/api") || strings.HasPrefix(c.Request.URL.Path, "/v1") {
-			// 生成相关接口使用生成token
+		if strings.HasPrefix(c.Request.URL.Path, "/api") || strings.HasPrefix(c.Request.URL.Path, "/v1") ||
+			strings.HasPrefix(c.Request.URL.Path, "/sessions") || strings.HasPrefix(c.Request.URL.Path, "/status") {
+			// 生成相关接口、会话接口和状态接口使用生成token
 			for _, allowedToken := range config.Auth.GenerateTokens {
 				if token == allowedToken {
 					validToken = true
@@ -153,7 +293,7 @@ func authMiddleware(config Config) gin.HandlerFunc {
 		}
 
 		// 记录token验证结果
-		logger.LogRequest(c.Request.Method, c.Request.URL.Path, nil, nil, nil, token, validToken)
+		logger.LogRequest(c.Request.Method, c.Request.URL.Path, nil, nil, nil, tokenIdentity(token), validToken)
 
 		if !validToken {
 			c.JSON(http.StatusOK, gin.H{
@@ -163,11 +303,29 @@ func authMiddleware(config Config) gin.HandlerFunc {
 			return
 		}
 
+		c.Set(identityKey, tokenIdentity(token))
 		c.Next()
 	}
 }
 
-// proxyOllama 创建一个代理处理函数
+// identityKey 认证通过后保存调用方身份的上下文键
+const identityKey = "auth_identity"
+
+// tokenIdentity 返回token对应的身份标识，使用摘要避免token原文出现在日志和钩子请求中
+func tokenIdentity(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])[:16]
+}
+
+// clientCertIdentity 返回已通过校验的客户端证书对应的身份
+func clientCertIdentity(config Config, c *gin.Context) *ClientCertIdentity {
+	state := c.Request.TLS
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return identityFromCert(config.Auth.ClientCerts, state.VerifiedChains[0][0])
+}
+
 // OpenAI风格的API处理函数
 func handleOpenAIChat(c *gin.Context) {
 	var openAIReq models.OpenAIChatRequest
@@ -178,118 +336,223 @@ func handleOpenAIChat(c *gin.Context) {
 		return
 	}
 
+	config, err := loadConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 解析模型别名并应用模型默认配置
+	model, settings := resolveModel(config, openAIReq.Model)
+	options := models.MergeOptions(openAIReq.Options, openAIReq.Sampling())
+
+	// 启用会话时，客户端只发送新消息，由代理补充会话的历史消息
+	owner := sessionOwner(c)
+	session := sessionID(c, openAIReq.User)
+	if session != "" {
+		c.Header(sessionHeader, session)
+	}
+	messages := append(sessions.history(owner, session), openAIReq.Messages...)
+
 	// 转换为Ollama请求格式
 	ollamaReq := models.OllamaChatRequest{
-		Model:    openAIReq.Model,
-		Messages: openAIReq.Messages,
-		Stream:   openAIReq.Stream,
-		Options:  openAIReq.Options,
+		Model:       model,
+		Messages:    models.ToOllamaMessages(withDefaultSystem(messages, settings.System)),
+		Tools:       openAIReq.Tools,
+		Stream:      openAIReq.Stream,
+		Think:       models.ThinkValue(openAIReq.Think, openAIReq.ReasoningEffort),
+		Logprobs:    openAIReq.Logprobs || openAIReq.TopLogprobs > 0,
+		TopLogprobs: openAIReq.TopLogprobs,
+		Options:     models.ApplyDefaultOptions(options, settings.Options),
+	}
+	// tool_choice为none时不向模型提供工具
+	if choice, ok := openAIReq.ToolChoice.(string); ok && choice == "none" {
+		ollamaReq.Tools = nil
 	}
 
-	if openAIReq.Stream {
-		c.Header("Content-Type", "text/event-stream")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
+	// 应用调用方和模型对应的策略以及外部策略钩子
+	policies, err := newPolicyPipeline(config, c, openAIReq.Model, model)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := policies.applyChat(&ollamaReq); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "messages", "content_policy_violation", err.Error())
+		return
+	}
+	if err := runPreHooks(config, c, openAIReq.Model, &ollamaReq); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "messages", "content_policy_violation", err.Error())
+		return
+	}
 
-		// 创建HTTP客户端和请求
-		config, err := loadConfig()
-		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
-			return
-		}
+	// 检查上下文长度，超出时按配置报错或截断历史消息
+	if err := fitContext(model, settings, policies.maxContext(), &ollamaReq); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "messages", "context_length_exceeded", err.Error())
+		return
+	}
 
-		baseURL := "http://localhost:11434"
-		if config.Service.BaseURL != "" {
-			baseURL = config.Service.BaseURL
-		}
+	// n>1时并发生成多个结果，每一路使用不同的seed
+	n := openAIReq.N
+	if n < 1 {
+		n = 1
+	}
+	requests := make([]interface{}, n)
+	for i := range requests {
+		req := ollamaReq
+		req.Options = fanOutOptions(ollamaReq.Options, i, n, config.Service.Traffic.Mode != "")
+		requests[i] = req
+	}
 
-		// 将请求数据转换为JSON
-		jsonData, err := json.Marshal(ollamaReq)
-		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
-			return
-		}
+	// 仅缓存确定性的单路生成请求
+	var cache *cachePolicy
+	if n == 1 && ollamaReq.Options.IsDeterministic() {
+		cache = newCachePolicy(c, ollamaReq.Model, "/api/chat", ollamaReq)
+	}
+	entry, hit := cache.lookup(c)
 
-		// 创建请求
-		req, err := http.NewRequest("POST", baseURL+"/api/chat", strings.NewReader(string(jsonData)))
+	if openAIReq.Stream {
+		bodies, err := openStreams(c.Request.Context(), entry, hit, "/api/chat", requests)
 		if err != nil {
 			c.SSEvent("error", gin.H{"error": err.Error()})
 			return
 		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "text/event-stream")
-		req.Header.Set("Connection", "keep-alive")
+		defer closeAll(bodies)
 
-		// 发送请求
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// 有响应后钩子时需要读取完整的响应，检查通过后再输出
+		bodies, err = hookStreams(config, c, openAIReq.Model, requests, bodies)
 		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
 			return
 		}
-		defer resp.Body.Close()
 
-		// 读取流式响应
-		reader := bufio.NewReader(resp.Body)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		// 读取流式响应，同时记录原始内容用于写入缓存
+		lines := readStreams(c.Request.Context(), bodies)
+		var recorded bytes.Buffer
+		// 同一个流的所有分块使用相同的id和created
+		streamID, created := models.NewID(models.ChatCompletionIDPrefix), time.Now().Unix()
+		sawToolCalls := make([]bool, len(bodies))
+		splitters := make([]*models.ReasoningSplitter, len(bodies))
+		contentRedactors := make([]*streamRedactor, len(bodies))
+		reasoningRedactors := make([]*streamRedactor, len(bodies))
+		for i := range splitters {
+			splitters[i] = models.NewReasoningSplitter(settings.Reasoning)
+			contentRedactors[i] = policies.completionStream()
+			reasoningRedactors[i] = policies.completionStream()
+		}
+		// 记录第一路的完整回复，结束后保存到会话
+		reply := models.ChatMessage{Role: "assistant"}
+		var replyContent strings.Builder
 
 		c.Stream(func(w io.Writer) bool {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				if err != io.EOF {
-					c.SSEvent("error", gin.H{"error": fmt.Sprintf("读取响应流出错: %v", err)})
-				}
+			line, ok := <-lines
+			if !ok {
+				writeSSEData(w, []byte(sseDone))
 				return false
 			}
-
-			// 跳过空行
-			if len(bytes.TrimSpace(line)) == 0 {
+			if line.err != nil {
+				c.SSEvent("error", gin.H{"error": fmt.Sprintf("读取响应流出错: %v", line.err)})
 				return true
 			}
-
-			var result map[string]interface{}
-			if err := json.Unmarshal(line, &result); err != nil {
+			if errMsg, ok := line.result["error"].(string); ok && errMsg != "" {
+				c.SSEvent("error", gin.H{"error": errMsg})
 				return true
 			}
+			recorded.Write(line.raw)
+
+			// 转换为OpenAI流式响应格式，多路生成时通过index区分
+			openaiResp := models.ConvertOllamaChatStreamResponse(line.result, openAIReq.Model)
+			openaiResp.ID, openaiResp.Created = streamID, created
+			choice := &openaiResp.Choices[0]
+			choice.Index = line.index
+			done, _ := line.result["done"].(bool)
+			splitters[line.index].Process(&choice.Message, done)
+			choice.Message.Content = contentRedactors[line.index].feed(choice.Message.Content, done)
+			choice.Message.ReasoningContent = reasoningRedactors[line.index].feed(choice.Message.ReasoningContent, done)
+			policies.redactToolCalls(choice.Message.ToolCalls)
+			if policies.redactsCompletion() {
+				choice.Logprobs = nil
+			}
+			if line.index == 0 {
+				replyContent.WriteString(choice.Message.Content)
+				for _, call := range choice.Message.ToolCalls {
+					call.Index = nil
+					reply.ToolCalls = append(reply.ToolCalls, call)
+				}
+				if done {
+					reply.Content = replyContent.String()
+					sessions.appendMessages(owner, session, openAIReq.Model, append(openAIReq.Messages, reply)...)
+				}
+			}
 
-			// 转换为OpenAI流式响应格式
-			openaiResp := models.ConvertOllamaChatStreamResponse(result, ollamaReq.Model)
+			// 工具调用通常出现在结束前的分块中，结束时需要返回tool_calls
+			if len(choice.Message.ToolCalls) > 0 {
+				sawToolCalls[line.index] = true
+			}
+			if choice.FinishReason != nil && sawToolCalls[line.index] {
+				reason := "tool_calls"
+				choice.FinishReason = &reason
+			}
 			jsonData, err := json.Marshal(openaiResp)
 			if err != nil {
 				c.SSEvent("error", gin.H{"error": err.Error()})
 				return false
 			}
-			c.SSEvent("message", string(jsonData))
+			writeSSEData(w, jsonData)
 
-			// 检查是否是最后一条消息
-			if done, ok := result["done"].(bool); ok && done {
-				return false
+			// 单路生成结束后写入缓存
+			if done && !hit {
+				cache.store(recorded.Bytes())
 			}
-
 			return true
 		})
 		return
 	}
 
 	// 非流式请求处理
-	resp, err := sendToOllama("/api/chat", ollamaReq)
+	resps, err := sendAll(entry, hit, "/api/chat", requests)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-
-	// 检查响应中是否包含错误信息
-	if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
-		c.JSON(http.StatusOK, gin.H{
-			"error": errMsg,
-		})
-		return
+	if !hit {
+		cache.storeJSON(resps[0])
+	}
+	for i := range resps {
+		if err := runPostHooks(config, c, openAIReq.Model, ollamaReq, &resps[i]); err != nil {
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
+			return
+		}
 	}
 
 	// 转换为OpenAI响应格式
-	openaiResp := models.ConvertOllamaChatResponse(resp, openAIReq.Model)
-	c.JSON(http.StatusOK, openaiResp)
+	openaiResps := make([]models.OpenAIChatResponse, len(resps))
+	for i, resp := range resps {
+		openaiResps[i] = models.ConvertOllamaChatResponse(resp, openAIReq.Model)
+		message := &openaiResps[i].Choices[0].Message
+		models.NewReasoningSplitter(settings.Reasoning).Process(message, true)
+		message.Content = policies.redactCompletion(message.Content)
+		message.ReasoningContent = policies.redactCompletion(message.ReasoningContent)
+		policies.redactToolCalls(message.ToolCalls)
+		if policies.redactsCompletion() {
+			openaiResps[i].Choices[0].Logprobs = nil
+		}
+	}
+
+	// 保存新消息和第一路的回复到会话
+	reply := openaiResps[0].Choices[0].Message
+	reply.ReasoningContent = ""
+	sessions.appendMessages(owner, session, openAIReq.Model, append(openAIReq.Messages, reply)...)
+	c.JSON(http.StatusOK, models.MergeChatResponses(openaiResps))
 }
 
 func handleOpenAICompletion(c *gin.Context) {
@@ -301,118 +564,244 @@ func handleOpenAICompletion(c *gin.Context) {
 		return
 	}
 
-	// 转换为Ollama请求格式
-	ollamaReq := models.OllamaGenerateRequest{
-		Model:   openAIReq.Model,
-		Prompt:  openAIReq.Prompt,
-		Stream:  openAIReq.Stream,
-		Options: openAIReq.Options,
+	config, err := loadConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	if openAIReq.Stream {
-		c.Header("Content-Type", "text/event-stream")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
+	// 解析模型别名并应用模型默认配置
+	model, settings := resolveModel(config, openAIReq.Model)
+	options := models.MergeOptions(openAIReq.Options, openAIReq.Sampling())
 
-		// 创建HTTP客户端和请求
-		config, err := loadConfig()
-		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
+	// 未传入prompt时与之前一样使用空字符串
+	prompts := openAIReq.Prompt
+	if len(prompts) == 0 {
+		prompts = models.CompletionPrompt{""}
+	}
+
+	// 应用调用方和模型对应的策略以及外部策略钩子
+	policies, err := newPolicyPipeline(config, c, openAIReq.Model, model)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := policies.check(append([]string{openAIReq.Suffix}, prompts...)...); err != nil {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "prompt", "content_policy_violation", err.Error())
+		return
+	}
+	redactedPrompts := make(models.CompletionPrompt, len(prompts))
+	for i, prompt := range prompts {
+		redactedPrompts[i] = policies.redactPrompt(prompt)
+	}
+	prompts = redactedPrompts
+	system := settings.System
+	if policySystem := policies.systemPrompt(); policySystem != "" {
+		system = strings.TrimSpace(policySystem + "\n\n" + system)
+	}
+
+	// 转换为Ollama请求格式，logit_bias Ollama不支持，忽略
+	ollamaReq := models.OllamaGenerateRequest{
+		Model:    model,
+		Suffix:   policies.redactPrompt(openAIReq.Suffix),
+		System:   system,
+		Template: openAIReq.Template,
+		Raw:      openAIReq.Raw,
+		Stream:   openAIReq.Stream,
+		Options:  policies.applyOptions(models.ApplyDefaultOptions(options, settings.Options)),
+	}
+	// logprobs为返回的候选token数量，0表示只返回采样token的对数概率
+	if openAIReq.Logprobs != nil {
+		ollamaReq.Logprobs = true
+		ollamaReq.TopLogprobs = *openAIReq.Logprobs
+	}
+
+	// best_of大于n时生成best_of个候选，按平均对数概率选出最好的n个
+	n := openAIReq.N
+	if n < 1 {
+		n = 1
+	}
+	candidates := n
+	if openAIReq.BestOf > n {
+		if openAIReq.Stream {
+			c.JSON(http.StatusOK, gin.H{
+				"error": "stream模式下不支持best_of",
+			})
 			return
 		}
+		candidates = openAIReq.BestOf
+		// 候选排序依赖对数概率
+		ollamaReq.Logprobs = true
+	}
 
-		baseURL := "http://localhost:11434"
-		if config.Service.BaseURL != "" {
-			baseURL = config.Service.BaseURL
+	// 每个prompt分别调用外部策略钩子
+	promptReqs := make([]models.OllamaGenerateRequest, len(prompts))
+	for p, prompt := range prompts {
+		promptReqs[p] = ollamaReq
+		promptReqs[p].Prompt = prompt
+		if err := runPreHooks(config, c, openAIReq.Model, &promptReqs[p]); err != nil {
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "prompt", "content_policy_violation", err.Error())
+			return
 		}
+		prompts[p] = promptReqs[p].Prompt
+	}
 
-		// 将请求数据转换为JSON
-		jsonData, err := json.Marshal(ollamaReq)
-		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
-			return
+	// 每个prompt生成candidates个候选，第p个prompt的第i个候选位于p*candidates+i
+	requests := make([]interface{}, 0, len(prompts)*candidates)
+	for _, promptReq := range promptReqs {
+		for i := 0; i < candidates; i++ {
+			req := promptReq
+			req.Options = fanOutOptions(promptReq.Options, i, candidates, config.Service.Traffic.Mode != "")
+			requests = append(requests, req)
 		}
+	}
 
-		// 创建请求
-		req, err := http.NewRequest("POST", baseURL+"/api/generate", strings.NewReader(string(jsonData)))
+	// 仅缓存确定性的单路生成请求
+	var cache *cachePolicy
+	if len(requests) == 1 && ollamaReq.Options.IsDeterministic() {
+		cache = newCachePolicy(c, ollamaReq.Model, "/api/generate", requests[0])
+	}
+	entry, hit := cache.lookup(c)
+
+	if openAIReq.Stream {
+		bodies, err := openStreams(c.Request.Context(), entry, hit, "/api/generate", requests)
 		if err != nil {
 			c.SSEvent("error", gin.H{"error": err.Error()})
 			return
 		}
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Accept", "text/event-stream")
-		req.Header.Set("Connection", "keep-alive")
+		defer closeAll(bodies)
 
-		// 发送请求
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		// 有响应后钩子时需要读取完整的响应，检查通过后再输出
+		bodies, err = hookStreams(config, c, openAIReq.Model, requests, bodies)
 		if err != nil {
-			c.SSEvent("error", gin.H{"error": err.Error()})
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
 			return
 		}
-		defer resp.Body.Close()
 
-		// 读取流式响应
-		reader := bufio.NewReader(resp.Body)
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+
+		// 读取流式响应，同时记录原始内容用于写入缓存
+		lines := readStreams(c.Request.Context(), bodies)
+		var recorded bytes.Buffer
+		// 同一个流的所有分块使用相同的id和created
+		streamID, created := models.NewID(models.CompletionIDPrefix), time.Now().Unix()
+		textOffsets := make([]int, len(bodies))
+		started := make([]bool, len(bodies))
+		redactors := make([]*streamRedactor, len(bodies))
+		for i := range redactors {
+			redactors[i] = policies.completionStream()
+		}
 
 		c.Stream(func(w io.Writer) bool {
-			line, err := reader.ReadBytes('\n')
-			if err != nil {
-				if err != io.EOF {
-					c.SSEvent("error", gin.H{"error": fmt.Sprintf("读取响应流出错: %v", err)})
-				}
+			line, ok := <-lines
+			if !ok {
+				writeSSEData(w, []byte(sseDone))
 				return false
 			}
-
-			// 跳过空行
-			if len(bytes.TrimSpace(line)) == 0 {
+			if line.err != nil {
+				c.SSEvent("error", gin.H{"error": fmt.Sprintf("读取响应流出错: %v", line.err)})
 				return true
 			}
-
-			var result map[string]interface{}
-			if err := json.Unmarshal(line, &result); err != nil {
+			if errMsg, ok := line.result["error"].(string); ok && errMsg != "" {
+				c.SSEvent("error", gin.H{"error": errMsg})
 				return true
 			}
-
-			// 转换为OpenAI流式响应格式
-			openaiResp := models.ConvertOllamaGenerateStreamResponse(result, ollamaReq.Model)
+			recorded.Write(line.raw)
+
+			// 转换为OpenAI流式响应格式，多路生成时通过index区分
+			openaiResp := models.ConvertOllamaGenerateStreamResponse(line.result, openAIReq.Model)
+			openaiResp.ID, openaiResp.Created = streamID, created
+			choice := &openaiResp.Choices[0]
+			choice.Index = line.index
+			done, _ := line.result["done"].(bool)
+
+			// text_offset需要相对于该路完整输出计算
+			offset := textOffsets[line.index]
+			// echo时在每一路的第一个分块前拼接prompt
+			if !started[line.index] {
+				started[line.index] = true
+				if openAIReq.Echo {
+					prompt := prompts[line.index/candidates]
+					choice.Text = prompt + choice.Text
+					offset += utf8.RuneCountInString(prompt)
+				}
+			}
+			choice.Logprobs.Shift(offset)
+			choice.Text = redactors[line.index].feed(choice.Text, done)
+			if policies.redactsCompletion() {
+				choice.Logprobs = nil
+			}
+			textOffsets[line.index] += utf8.RuneCountInString(choice.Text)
 			jsonData, err := json.Marshal(openaiResp)
 			if err != nil {
 				c.SSEvent("error", gin.H{"error": err.Error()})
 				return false
 			}
-			c.SSEvent("message", string(jsonData))
+			writeSSEData(w, jsonData)
 
-			// 检查是否是最后一条消息
-			if done, ok := result["done"].(bool); ok && done {
-				return false
+			// 单路生成结束后写入缓存
+			if done && !hit {
+				cache.store(recorded.Bytes())
 			}
-
 			return true
 		})
 		return
 	}
 
 	// 非流式请求处理
-	resp, err := sendToOllama("/api/generate", ollamaReq)
+	resps, err := sendAll(entry, hit, "/api/generate", requests)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-
-	// 检查响应中是否包含错误信息
-	if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
-		c.JSON(http.StatusOK, gin.H{
-			"error": errMsg,
-		})
-		return
+	if !hit {
+		cache.storeJSON(resps[0])
+	}
+	for i := range resps {
+		if err := runPostHooks(config, c, openAIReq.Model, requests[i], &resps[i]); err != nil {
+			openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
+			return
+		}
 	}
 
-	// 转换为OpenAI响应格式
-	openaiResp := models.ConvertOllamaGenerateResponse(resp, openAIReq.Model)
-	c.JSON(http.StatusOK, openaiResp)
+	// 按prompt分组转换，每组内按需从候选中选出最好的n个
+	groups := make([]models.OpenAICompletionResponse, len(prompts))
+	for p, prompt := range prompts {
+		group := resps[p*candidates : (p+1)*candidates]
+		if candidates > n {
+			group = rankByLogprob(group)[:n]
+			// 客户端未请求logprobs时不返回排序用的对数概率
+			if openAIReq.Logprobs == nil {
+				for _, resp := range group {
+					delete(resp, "logprobs")
+				}
+			}
+		}
+
+		openaiResps := make([]models.OpenAICompletionResponse, len(group))
+		for i, resp := range group {
+			openaiResps[i] = models.ConvertOllamaGenerateResponse(resp, openAIReq.Model)
+			choice := &openaiResps[i].Choices[0]
+			if openAIReq.Echo {
+				choice.Text = prompt + choice.Text
+				choice.Logprobs.Shift(utf8.RuneCountInString(prompt))
+			}
+			choice.Text = policies.redactCompletion(choice.Text)
+			if policies.redactsCompletion() {
+				choice.Logprobs = nil
+			}
+		}
+		groups[p] = models.MergeCompletionResponses(openaiResps)
+	}
+	c.JSON(http.StatusOK, models.MergePromptResponses(groups))
 }
 
 func handleOpenAIEmbedding(c *gin.Context) {
@@ -424,15 +813,14 @@ func handleOpenAIEmbedding(c *gin.Context) {
 		return
 	}
 
-	// 直接将OpenAI请求中的输入数组传递给Ollama
-	ollamaReq := models.OllamaEmbeddingRequest{
-		Model: req.Model,
-		Input: req.Input,
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		c.JSON(http.StatusOK, gin.H{
+			"error": "encoding_format仅支持float或base64",
+		})
+		return
 	}
 
-	// 发送批量请求到Ollama服务
-	resp, err := sendToOllama("/api/embed", ollamaReq)
-
+	config, err := loadConfig()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"error": err.Error(),
@@ -440,43 +828,126 @@ func handleOpenAIEmbedding(c *gin.Context) {
 		return
 	}
 
-	// 检查响应中是否包含错误信息
-	if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
+	// 解析模型别名
+	model, _ := resolveModel(config, req.Model)
+	ollamaReq := models.OllamaEmbeddingRequest{
+		Model: model,
+		Input: req.Input,
+	}
+
+	// Ollama的/api/embed只接受文本输入，token数组只能发送到OpenAI兼容后端
+	if req.Input.Tokens != nil {
+		if backends, err := routeBackends(config, model); err != nil || !allOpenAI(backends) {
+			c.JSON(http.StatusOK, gin.H{
+				"error": "Ollama服务不支持token数组形式的input，请传入文本或使用OpenAI兼容后端",
+			})
+			return
+		}
+	}
+
+	// Embedding结果是确定的，可以直接缓存
+	cache := newCachePolicy(c, ollamaReq.Model, "/api/embed", ollamaReq)
+	entry, hit := cache.lookup(c)
+
+	var resp map[string]interface{}
+	if hit {
+		resp, err = sendOrReplay(entry, hit, "/api/embed", ollamaReq)
+	} else {
+		// 按批次发送请求到Ollama服务
+		resp, err = embedInBatches(ollamaReq)
+	}
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
-			"error": errMsg,
+			"error": err.Error(),
 		})
 		return
 	}
-	// 获取token使用量
-	totalTokens := 0.0
-	if prompt, ok := resp["prompt_eval_count"].(float64); ok {
-		totalTokens = prompt
+	if !hit {
+		cache.storeJSON(resp)
+	}
+
+	// 转换为OpenAI响应格式
+	openaiResp := models.ConvertOllamaEmbeddingResponse(resp, req.Model, models.EmbeddingOptions{
+		EncodingFormat: req.EncodingFormat,
+		Dimensions:     req.Dimensions,
+	})
+	c.JSON(http.StatusOK, openaiResp)
+}
+
+// defaultEmbeddingBatchSize 单次发送给Ollama的最大输入条数
+const defaultEmbeddingBatchSize = 64
+
+// embedInBatches 将输入拆分为多个批次请求Ollama，并按原始顺序合并结果
+func embedInBatches(ollamaReq models.OllamaEmbeddingRequest) (map[string]interface{}, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
 	}
+	batchSize := config.Embedding.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+
+	total := ollamaReq.Input.Len()
+	allEmbeddings := make([]interface{}, 0, total)
+	totalTokens := 0.0
+	for start := 0; start < total; start += batchSize {
+		end := start + batchSize
+		if end > total {
+			end = total
+		}
+
+		resp, err := sendToOllama("/api/embed", models.OllamaEmbeddingRequest{
+			Model: ollamaReq.Model,
+			Input: ollamaReq.Input.Slice(start, end),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// 检查响应中是否包含错误信息
+		if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
+			return nil, fmt.Errorf("%s", errMsg)
+		}
+
+		// 获取token使用量
+		if prompt, ok := resp["prompt_eval_count"].(float64); ok {
+			totalTokens += prompt
+		}
 
-	// 获取embeddings数据
-	var allEmbeddings []interface{}
-	if embeddings, ok := resp["embeddings"].([]interface{}); ok {
-		allEmbeddings = embeddings
-	} else if embedding, ok := resp["embedding"].([]interface{}); ok {
-		// 兼容单个embedding的情况
-		allEmbeddings = []interface{}{embedding}
+		// 获取embeddings数据
+		if embeddings, ok := resp["embeddings"].([]interface{}); ok {
+			allEmbeddings = append(allEmbeddings, embeddings...)
+		} else if embedding, ok := resp["embedding"].([]interface{}); ok {
+			// 兼容单个embedding的情况
+			allEmbeddings = append(allEmbeddings, embedding)
+		}
 	}
 
 	// 构造包含所有embeddings的响应
-	combinedResp := map[string]interface{}{
+	return map[string]interface{}{
 		"embeddings":        allEmbeddings,
 		"prompt_eval_count": totalTokens,
-	}
-
-	// 转换为OpenAI响应格式
-	openaiResp := models.ConvertOllamaEmbeddingResponse(combinedResp, req.Model)
-	c.JSON(http.StatusOK, openaiResp)
+	}, nil
 }
 
 // handleOpenAIModels 处理OpenAI风格的模型列表请求
 func handleOpenAIModels(c *gin.Context) {
+	config, err := loadConfig()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	backendModels := openAIBackendModels(config)
+
 	// 调用Ollama的tags接口获取模型列表
 	resp, err := sendToOllamaGet("/api/tags")
+	if err != nil && len(backendModels) > 0 {
+		// 只配置了OpenAI兼容后端时，只返回这些后端的模型
+		resp, err = map[string]interface{}{}, nil
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"error": err.Error(),
@@ -492,33 +963,67 @@ func handleOpenAIModels(c *gin.Context) {
 		return
 	}
 
-	// 转换为OpenAI响应格式
+	// 转换为OpenAI响应格式，并追加配置的模型别名
 	openaiResp := models.ConvertOllamaModelsResponse(resp)
+	openaiResp.Data = append(openaiResp.Data, backendModels...)
+	openaiResp.Data = append(openaiResp.Data, aliasModelData(config, openaiResp.Data)...)
 	c.JSON(http.StatusOK, openaiResp)
 }
 
-// 发送GET请求到Ollama服务的函数
-func sendToOllamaGet(path string) (map[string]interface{}, error) {
+// handleOpenAIModel 查询单个模型的信息，包括上下文长度、模型家族、参数量和量化方式
+func handleOpenAIModel(c *gin.Context) {
 	config, err := loadConfig()
 	if err != nil {
-		return nil, err
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
 	}
 
-	baseURL := "http://localhost:11434"
-	if config.Service.BaseURL != "" {
-		baseURL = config.Service.BaseURL
+	// 模型名称中可能包含/，例如hf.co/xxx/yyy
+	id := strings.TrimPrefix(c.Param("model"), "/")
+	model, _ := resolveModel(config, id)
+
+	// OpenAI兼容后端没有/api/show，只返回基本信息
+	if backends, err := routeBackends(config, model); err == nil && allOpenAI(backends) {
+		c.JSON(http.StatusOK, models.NewBackendModelData(id, backends[0].Name))
+		return
 	}
 
-	// 创建GET请求
-	req, err := http.NewRequest("GET", baseURL+path, nil)
+	resp, err := showModel(model)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.ConvertOllamaShowResponse(id, model, resp))
+}
+
+// showModel 调用Ollama的/api/show接口查询模型详情
+func showModel(model string) (map[string]interface{}, error) {
+	resp, err := sendToOllama("/api/show", gin.H{"model": model})
+	if err != nil {
+		return nil, err
+	}
+	if errMsg, ok := resp["error"].(string); ok && errMsg != "" {
+		return nil, fmt.Errorf("%s", errMsg)
+	}
+	return resp, nil
+}
+
+// 发送GET请求到Ollama服务的函数
+func sendToOllamaGet(path string) (map[string]interface{}, error) {
+	config, err := loadConfig()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doUpstream(context.Background(), config, http.MethodGet, path, nil, header)
 	if err != nil {
 		return nil, err
 	}
@@ -539,34 +1044,69 @@ func sendToOllamaGet(path string) (map[string]interface{}, error) {
 	return result, nil
 }
 
-// 发送请求到Ollama服务的通用函数
-func sendToOllama(path string, data interface{}) (map[string]interface{}, error) {
+// openOllamaStream 发送流式请求到Ollama服务，返回NDJSON响应体
+func openOllamaStream(ctx context.Context, path string, data interface{}) (io.ReadCloser, error) {
 	config, err := loadConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	baseURL := "http://localhost:11434"
-	if config.Service.BaseURL != "" {
-		baseURL = config.Service.BaseURL
-	}
-
 	// 将请求数据转换为JSON
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
 
-	// 创建请求
-	req, err := http.NewRequest("POST", baseURL+path, bytes.NewBuffer(jsonData))
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "text/event-stream")
+
+	// 发送请求，调用方断开连接时同时取消上游请求
+	resp, err := doUpstream(ctx, config, http.MethodPost, path, jsonData, header)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// openStream 命中缓存时回放缓存的流，否则请求Ollama服务
+func openStream(ctx context.Context, entry *cacheEntry, hit bool, path string, data interface{}) (io.ReadCloser, error) {
+	if hit {
+		return io.NopCloser(bytes.NewReader(entry.Body)), nil
+	}
+	return openOllamaStream(ctx, path, data)
+}
+
+// sendOrReplay 命中缓存时直接解析缓存内容，否则请求Ollama服务
+func sendOrReplay(entry *cacheEntry, hit bool, path string, data interface{}) (map[string]interface{}, error) {
+	if !hit {
+		return sendToOllama(path, data)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(entry.Body, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// 发送请求到Ollama服务的通用函数
+func sendToOllama(path string, data interface{}) (map[string]interface{}, error) {
+	config, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// 将请求数据转换为JSON
+	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
 
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doUpstream(context.Background(), config, http.MethodPost, path, jsonData, header)
 	if err != nil {
 		return nil, err
 	}
@@ -587,6 +1127,7 @@ func sendToOllama(path string, data interface{}) (map[string]interface{}, error)
 	return result, nil
 }
 
+// proxyOllama 创建一个代理处理函数
 func proxyOllama(path string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		config, err := loadConfig()
@@ -607,33 +1148,51 @@ func proxyOllama(path string) gin.HandlerFunc {
 			}
 		}
 
-		// 创建代理请求
-		baseURL := "http://localhost:11434"
-		if config.Service.BaseURL != "" {
-			baseURL = config.Service.BaseURL
+		// 对聊天和生成接口应用调用方和模型对应的策略
+		var policies *policyPipeline
+		model, _ := requestBody["model"].(string)
+		generative := path == "/api/chat" || path == "/api/generate"
+		if generative {
+			policies, err = newPolicyPipeline(config, c, model, model)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			if err := policies.applyNative(path, requestBody); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			if err := runPreHooks(config, c, model, &requestBody); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		// Embedding请求的结果是确定的，可以使用缓存
+		var cache *cachePolicy
+		if path == "/api/embed" {
+			cache = newCachePolicy(c, model, path, requestBody)
+			if entry, hit := cache.lookup(c); hit {
+				c.Data(http.StatusOK, "application/json; charset=utf-8", entry.Body)
+				return
+			}
 		}
 
 		// 重新创建请求体
 		jsonData, _ := json.Marshal(requestBody)
-		req, err := http.NewRequest(c.Request.Method, baseURL+path, bytes.NewReader(jsonData))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "Failed to create request",
-			})
-			return
-		}
 
-		// 复制原始请求的header
-		for name, values := range c.Request.Header {
-			for _, value := range values {
-				req.Header.Add(name, value)
-			}
-		}
-		req.Header.Set("Content-Type", "application/json")
+		// 按配置过滤原始请求的header，不转发客户端的认证信息和逐跳头部
+		header := upstreamRequestHeader(config.Service.Headers, c.Request.Header)
+		header.Set("Content-Type", "application/json")
 
 		// 发送请求到Ollama服务
-		client := &http.Client{}
-		resp, err := client.Do(req)
+		resp, err := doUpstream(c.Request.Context(), config, c.Request.Method, path, jsonData, header)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"error": "Failed to connect to Ollama service",
@@ -643,15 +1202,57 @@ func proxyOllama(path string) gin.HandlerFunc {
 		defer resp.Body.Close()
 
 		// 复制响应header
-		for name, values := range resp.Header {
-			for _, value := range values {
-				c.Header(name, value)
-			}
-		}
+		copyResponseHeader(c, config.Service.Headers, resp.Header)
 
 		// 设置响应状态码
 		c.Status(resp.StatusCode)
 
+		// 调用响应后钩子，流式响应（未指定stream时Ollama默认流式返回）需要读取完整的流，合并为一行后再调用
+		if generative && resp.StatusCode == http.StatusOK && hasPostHooks(config, model) {
+			var body []byte
+			var result map[string]interface{}
+			stream, ok := requestBody["stream"].(bool)
+			if streaming := !ok || stream; streaming {
+				result = mergeStream(resp.Body)
+			} else {
+				body, err = io.ReadAll(resp.Body)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{
+						"error": "Failed to read response body",
+					})
+					return
+				}
+				if json.Unmarshal(body, &result) != nil {
+					result = nil
+				}
+			}
+
+			if result != nil {
+				if _, failed := result["error"]; !failed {
+					if err := runPostHooks(config, c, model, requestBody, &result); err != nil {
+						c.JSON(http.StatusBadRequest, gin.H{
+							"error": err.Error(),
+						})
+						return
+					}
+				}
+				body, _ = json.Marshal(result)
+				if !ok || stream {
+					body = append(body, '\n')
+				}
+			}
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		// 需要对响应脱敏时逐行处理NDJSON
+		if resp.StatusCode == http.StatusOK && policies.completionStream() != nil {
+			c.Stream(func(w io.Writer) bool {
+				policies.redactNDJSON(w, resp.Body, c.Writer.Flush)
+				return false
+			})
+			return
+		}
+
 		// 检查是否为流式请求
 		if stream, ok := requestBody["stream"].(bool); ok && stream {
 			// 流式请求使用Stream方式返回
@@ -668,6 +1269,9 @@ func proxyOllama(path string) gin.HandlerFunc {
 				})
 				return
 			}
+			if resp.StatusCode == http.StatusOK {
+				cache.store(body)
+			}
 			// 其他接口直接返回原始响应
 			c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 		}
@@ -680,7 +1284,15 @@ func logMiddleware() gin.HandlerFunc {
 		// 获取请求体
 		var requestBody map[string]interface{}
 		if c.Request.Body != nil {
-			bodyBytes, _ := io.ReadAll(c.Request.Body)
+			bodyBytes, err := io.ReadAll(c.Request.Body)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+					"error": fmt.Sprintf("请求体超过 %d 字节的限制", maxBytesErr.Limit),
+				})
+				c.Abort()
+				return
+			}
 			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 			json.Unmarshal(bodyBytes, &requestBody)
 		}
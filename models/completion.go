@@ -0,0 +1,43 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// CompletionPrompt 生成请求的prompt，兼容字符串和字符串数组两种格式
+type CompletionPrompt []string
+
+// UnmarshalJSON 解析不同格式的prompt字段
+func (p *CompletionPrompt) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*p = CompletionPrompt{text}
+		return nil
+	}
+
+	var texts []string
+	if err := json.Unmarshal(data, &texts); err == nil {
+		*p = texts
+		return nil
+	}
+
+	return fmt.Errorf("prompt必须是字符串或字符串数组，Ollama服务不支持token数组形式的prompt")
+}
+
+// MergePromptResponses 合并多个prompt各自的生成结果，choice按prompt顺序编号，prompt的token数累加
+func MergePromptResponses(responses []OpenAICompletionResponse) OpenAICompletionResponse {
+	merged := MergeCompletionResponses(responses)
+	merged.Usage.PromptTokens = 0
+	for _, resp := range responses {
+		merged.Usage.PromptTokens += resp.Usage.PromptTokens
+	}
+	merged.Usage.TotalTokens = merged.Usage.PromptTokens + merged.Usage.CompletionTokens
+	return merged
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompletionPromptUnmarshal(t *testing.T) {
+	tests := []struct {
+		input string
+		want  CompletionPrompt
+	}{
+		{`"hi"`, CompletionPrompt{"hi"}},
+		{`["a","b"]`, CompletionPrompt{"a", "b"}},
+		{`null`, nil},
+	}
+	for _, tt := range tests {
+		var prompt CompletionPrompt
+		if err := json.Unmarshal([]byte(tt.input), &prompt); err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if !reflect.DeepEqual(prompt, tt.want) {
+			t.Errorf("%s: prompt = %v, want %v", tt.input, prompt, tt.want)
+		}
+	}
+
+	var prompt CompletionPrompt
+	if err := json.Unmarshal([]byte(`[1,2,3]`), &prompt); err == nil {
+		t.Errorf("token数组形式的prompt应返回错误")
+	}
+}
+
+func TestMergePromptResponses(t *testing.T) {
+	response := func(text string, prompt, completion float64) OpenAICompletionResponse {
+		return OpenAICompletionResponse{
+			Choices: []Choice{{Text: text}},
+			Usage:   Usage{PromptTokens: prompt, CompletionTokens: completion},
+		}
+	}
+	merged := MergePromptResponses([]OpenAICompletionResponse{response("a", 3, 1), response("b", 4, 2)})
+	if len(merged.Choices) != 2 || merged.Choices[1].Index != 1 || merged.Choices[1].Text != "b" {
+		t.Errorf("choices = %+v", merged.Choices)
+	}
+	// 每个prompt的token数都需要累加
+	if merged.Usage != (Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}) {
+		t.Errorf("usage = %+v", merged.Usage)
+	}
+}
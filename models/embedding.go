@@ -0,0 +1,115 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// EmbeddingInput Embedding请求的输入，兼容OpenAI支持的四种格式：
+// 字符串、字符串数组、token数组以及token数组的数组
+type EmbeddingInput struct {
+	Texts  []string
+	Tokens [][]int
+}
+
+// UnmarshalJSON 解析不同格式的input字段
+func (e *EmbeddingInput) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		e.Texts = []string{text}
+		return nil
+	}
+
+	var texts []string
+	if err := json.Unmarshal(data, &texts); err == nil {
+		e.Texts = texts
+		return nil
+	}
+
+	var tokens []int
+	if err := json.Unmarshal(data, &tokens); err == nil {
+		e.Tokens = [][]int{tokens}
+		return nil
+	}
+
+	var tokenLists [][]int
+	if err := json.Unmarshal(data, &tokenLists); err == nil {
+		e.Tokens = tokenLists
+		return nil
+	}
+
+	return fmt.Errorf("input必须是字符串、字符串数组、token数组或token数组的数组")
+}
+
+// MarshalJSON 按原始格式输出input字段
+func (e EmbeddingInput) MarshalJSON() ([]byte, error) {
+	if e.Tokens != nil {
+		return json.Marshal(e.Tokens)
+	}
+	return json.Marshal(e.Texts)
+}
+
+// Len 返回输入条数
+func (e EmbeddingInput) Len() int {
+	if e.Tokens != nil {
+		return len(e.Tokens)
+	}
+	return len(e.Texts)
+}
+
+// Slice 返回第start到end条输入
+func (e EmbeddingInput) Slice(start, end int) EmbeddingInput {
+	if e.Tokens != nil {
+		return EmbeddingInput{Tokens: e.Tokens[start:end]}
+	}
+	return EmbeddingInput{Texts: e.Texts[start:end]}
+}
+
+// EmbeddingOptions Embedding响应的输出选项
+type EmbeddingOptions struct {
+	// EncodingFormat 输出格式：float（默认）/ base64
+	EncodingFormat string
+	// Dimensions 截断后的向量维度，0表示保持原始维度
+	Dimensions int
+}
+
+// TruncateEmbedding 截断向量到指定维度并重新归一化
+func TruncateEmbedding(embedding []float64, dimensions int) []float64 {
+	if dimensions <= 0 || dimensions >= len(embedding) {
+		return embedding
+	}
+
+	truncated := make([]float64, dimensions)
+	copy(truncated, embedding[:dimensions])
+
+	norm := 0.0
+	for _, v := range truncated {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return truncated
+	}
+	for i := range truncated {
+		truncated[i] /= norm
+	}
+	return truncated
+}
+
+// EncodeEmbeddingBase64 与OpenAI一致，将向量按float32小端序打包后进行base64编码
+func EncodeEmbeddingBase64(embedding []float64) string {
+	buf := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
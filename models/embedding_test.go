@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestEmbeddingInputUnmarshal(t *testing.T) {
+	tests := []struct {
+		input  string
+		texts  int
+		tokens int
+	}{
+		{`"hello"`, 1, 0},
+		{`["a","b"]`, 2, 0},
+		{`[1,2,3]`, 0, 1},
+		{`[[1,2],[3]]`, 0, 2},
+	}
+	for _, tt := range tests {
+		var input EmbeddingInput
+		if err := json.Unmarshal([]byte(tt.input), &input); err != nil {
+			t.Fatalf("%s: %v", tt.input, err)
+		}
+		if len(input.Texts) != tt.texts || len(input.Tokens) != tt.tokens {
+			t.Errorf("%s: %+v", tt.input, input)
+		}
+	}
+
+	var input EmbeddingInput
+	if err := json.Unmarshal([]byte(`[{"text":"a"}]`), &input); err == nil {
+		t.Errorf("不支持的格式应返回错误")
+	}
+}
+
+func TestEmbeddingInputSlice(t *testing.T) {
+	input := EmbeddingInput{Tokens: [][]int{{1}, {2}, {3}}}
+	data, _ := json.Marshal(input.Slice(1, 3))
+	if string(data) != "[[2],[3]]" {
+		t.Errorf("slice = %s", data)
+	}
+}
+
+func TestTruncateEmbedding(t *testing.T) {
+	got := TruncateEmbedding([]float64{3, 4, 12}, 2)
+	if len(got) != 2 || math.Abs(got[0]-0.6) > 1e-9 || math.Abs(got[1]-0.8) > 1e-9 {
+		t.Errorf("truncated = %v", got)
+	}
+	// 维度不小于原始维度时保持不变
+	if got := TruncateEmbedding([]float64{3, 4}, 5); len(got) != 2 || got[0] != 3 {
+		t.Errorf("truncated = %v", got)
+	}
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// 各类对象ID的前缀，与OpenAI一致
+const (
+	ChatCompletionIDPrefix  = "chatcmpl-"
+	CompletionIDPrefix      = "cmpl-"
+	ModelPermissionIDPrefix = "modelperm-"
+	ToolCallIDPrefix        = "call_"
+)
+
+// NewID 生成带前缀的随机ID，并发请求之间不会重复
+func NewID(prefix string) string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return prefix + hex.EncodeToString(buf)
+}
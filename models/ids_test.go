@@ -0,0 +1,33 @@
+package models
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewIDUnique(t *testing.T) {
+	const n = 1000
+	ids := make(chan string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids <- NewID(ChatCompletionIDPrefix)
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := map[string]bool{}
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("重复的ID: %s", id)
+		}
+		seen[id] = true
+		if !strings.HasPrefix(id, "chatcmpl-") || len(id) != len("chatcmpl-")+24 {
+			t.Fatalf("ID格式错误: %s", id)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"unicode/utf8"
+)
+
+// MeanLogprob 计算Ollama响应中所有token对数概率的平均值
+func MeanLogprob(ollamaResp map[string]interface{}) (float64, bool) {
+	logprobs, ok := ollamaResp["logprobs"].([]interface{})
+	if !ok || len(logprobs) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, item := range logprobs {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		logprob, ok := entry["logprob"].(float64)
+		if !ok {
+			return 0, false
+		}
+		total += logprob
+	}
+	return total / float64(len(logprobs)), true
+}
+
+// ChatLogprobs 聊天响应中的对数概率
+type ChatLogprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+// TokenLogprob 单个token的对数概率
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+// TopLogprob 候选token的对数概率
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+// ConvertChatLogprobs 将Ollama返回的logprobs转换为OpenAI聊天格式，未返回时为nil
+func ConvertChatLogprobs(ollamaResp map[string]interface{}) *ChatLogprobs {
+	entries, ok := ollamaResp["logprobs"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := &ChatLogprobs{Content: []TokenLogprob{}}
+	for _, item := range entries {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		token, logprob, bytes := parseTokenLogprob(entry)
+		tokenLogprob := TokenLogprob{
+			Token:       token,
+			Logprob:     logprob,
+			Bytes:       bytes,
+			TopLogprobs: []TopLogprob{},
+		}
+		for _, top := range topLogprobEntries(entry) {
+			topToken, topLogprob, topBytes := parseTokenLogprob(top)
+			tokenLogprob.TopLogprobs = append(tokenLogprob.TopLogprobs, TopLogprob{
+				Token:   topToken,
+				Logprob: topLogprob,
+				Bytes:   topBytes,
+			})
+		}
+		result.Content = append(result.Content, tokenLogprob)
+	}
+	return result
+}
+
+// ConvertCompletionLogprobs 将Ollama返回的logprobs转换为OpenAI生成接口格式
+// offset为第一个token在完整文本中的字符偏移
+func ConvertCompletionLogprobs(ollamaResp map[string]interface{}, offset int) *Logprobs {
+	entries, ok := ollamaResp["logprobs"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := &Logprobs{
+		Tokens:        []string{},
+		TokenLogprobs: []float64{},
+		TopLogprobs:   []map[string]float64{},
+		TextOffset:    []int{},
+	}
+	for _, item := range entries {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		token, logprob, _ := parseTokenLogprob(entry)
+		top := map[string]float64{}
+		for _, candidate := range topLogprobEntries(entry) {
+			candidateToken, candidateLogprob, _ := parseTokenLogprob(candidate)
+			top[candidateToken] = candidateLogprob
+		}
+
+		result.Tokens = append(result.Tokens, token)
+		result.TokenLogprobs = append(result.TokenLogprobs, logprob)
+		result.TopLogprobs = append(result.TopLogprobs, top)
+		result.TextOffset = append(result.TextOffset, offset)
+		offset += utf8.RuneCountInString(token)
+	}
+	return result
+}
+
+// Shift 将text_offset整体后移offset个字符，用于在文本前拼接内容
+func (l *Logprobs) Shift(offset int) {
+	if l == nil {
+		return
+	}
+	for i := range l.TextOffset {
+		l.TextOffset[i] += offset
+	}
+}
+
+// parseTokenLogprob 解析Ollama logprobs中的单个条目，未返回bytes时根据token计算
+func parseTokenLogprob(entry map[string]interface{}) (string, float64, []int) {
+	token, _ := entry["token"].(string)
+	logprob, _ := entry["logprob"].(float64)
+
+	var bytes []int
+	if raw, ok := entry["bytes"].([]interface{}); ok {
+		for _, b := range raw {
+			if value, ok := b.(float64); ok {
+				bytes = append(bytes, int(value))
+			}
+		}
+	} else {
+		for _, b := range []byte(token) {
+			bytes = append(bytes, int(b))
+		}
+	}
+	return token, logprob, bytes
+}
+
+func topLogprobEntries(entry map[string]interface{}) []map[string]interface{} {
+	items, _ := entry["top_logprobs"].([]interface{})
+	var result []map[string]interface{}
+	for _, item := range items {
+		if top, ok := item.(map[string]interface{}); ok {
+			result = append(result, top)
+		}
+	}
+	return result
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// ollamaLogprobs 解析JSON格式的Ollama响应
+func ollamaLogprobs(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	var resp map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestConvertChatLogprobs(t *testing.T) {
+	resp := ollamaLogprobs(t, `{"logprobs":[
+		{"token":"你","logprob":-0.1,"top_logprobs":[{"token":"你","logprob":-0.1},{"token":"您","logprob":-2.3,"bytes":[230,130,168]}]},
+		{"token":"好","logprob":-0.2,"bytes":[229,165,189]}
+	]}`)
+	got := ConvertChatLogprobs(resp)
+	if got == nil || len(got.Content) != 2 {
+		t.Fatalf("logprobs = %+v", got)
+	}
+	first := got.Content[0]
+	// 未返回bytes时根据token的UTF-8编码计算
+	if first.Token != "你" || first.Logprob != -0.1 || !reflect.DeepEqual(first.Bytes, []int{228, 189, 160}) {
+		t.Errorf("first = %+v", first)
+	}
+	if len(first.TopLogprobs) != 2 || first.TopLogprobs[1].Token != "您" || first.TopLogprobs[1].Logprob != -2.3 {
+		t.Errorf("top_logprobs = %+v", first.TopLogprobs)
+	}
+	// 没有候选时输出空数组而不是null
+	if got.Content[1].TopLogprobs == nil {
+		t.Errorf("top_logprobs应为空数组")
+	}
+
+	if ConvertChatLogprobs(map[string]interface{}{"response": "x"}) != nil {
+		t.Errorf("Ollama未返回logprobs时应为nil")
+	}
+}
+
+func TestConvertCompletionLogprobs(t *testing.T) {
+	resp := ollamaLogprobs(t, `{"logprobs":[
+		{"token":"héllo","logprob":-0.5,"top_logprobs":[{"token":"héllo","logprob":-0.5},{"token":"hi","logprob":-1}]},
+		{"token":" world","logprob":-0.25}
+	]}`)
+	got := ConvertCompletionLogprobs(resp, 3)
+	want := &Logprobs{
+		Tokens:        []string{"héllo", " world"},
+		TokenLogprobs: []float64{-0.5, -0.25},
+		TopLogprobs:   []map[string]float64{{"héllo": -0.5, "hi": -1}, {}},
+		// text_offset按字符而不是字节计算
+		TextOffset: []int{3, 8},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("logprobs = %+v, want %+v", got, want)
+	}
+}
+
+func TestMeanLogprob(t *testing.T) {
+	if mean, ok := MeanLogprob(ollamaLogprobs(t, `{"logprobs":[{"logprob":-1},{"logprob":-2}]}`)); !ok || mean != -1.5 {
+		t.Errorf("mean = %v, %v", mean, ok)
+	}
+	for _, data := range []string{`{}`, `{"logprobs":[]}`, `{"logprobs":[{"token":"x"}]}`} {
+		if _, ok := MeanLogprob(ollamaLogprobs(t, data)); ok {
+			t.Errorf("%s 不应计算出平均值", data)
+		}
+	}
+}
+
+func TestLogprobsShift(t *testing.T) {
+	logprobs := &Logprobs{TextOffset: []int{0, 2}}
+	logprobs.Shift(5)
+	if !reflect.DeepEqual(logprobs.TextOffset, []int{5, 7}) {
+		t.Errorf("text_offset = %v", logprobs.TextOffset)
+	}
+	// 没有logprobs时不做处理
+	var empty *Logprobs
+	empty.Shift(5)
+}
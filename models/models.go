@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
@@ -19,6 +20,18 @@ type ModelData struct {
 	Permission []ModelPermission `json:"permission"`
 	Root       string            `json:"root"`
 	Parent     interface{}       `json:"parent"`
+	// 以下字段仅在查询单个模型时返回
+	ContextLength int           `json:"context_length,omitempty"`
+	Details       *ModelDetails `json:"details,omitempty"`
+}
+
+// ModelDetails 模型详情，来自Ollama的/api/show
+type ModelDetails struct {
+	Format            string   `json:"format,omitempty"`
+	Family            string   `json:"family,omitempty"`
+	Families          []string `json:"families,omitempty"`
+	ParameterSize     string   `json:"parameter_size,omitempty"`
+	QuantizationLevel string   `json:"quantization_level,omitempty"`
 }
 
 // ModelPermission 模型权限
@@ -62,29 +75,8 @@ func ConvertOllamaModelsResponse(ollamaResp map[string]interface{}) OpenAIModelR
 			continue
 		}
 
-		// 创建模型数据
-		modelData := ModelData{
-			ID:      modelName,
-			Object:  "model",
-			Created: time.Now().Unix(),
-			OwnedBy: "organization-owner",
-			Root:    modelName,
-			Permission: []ModelPermission{
-				{
-					ID:                 "modelperm-" + time.Now().Format("20060102150405"),
-					Object:             "model_permission",
-					Created:            time.Now().Unix(),
-					AllowCreateEngine:  false,
-					AllowSampling:      true,
-					AllowLogprobs:      true,
-					AllowSearchIndices: false,
-					AllowView:          true,
-					AllowFineTuning:    false,
-					Organization:       "*",
-					IsBlocking:         false,
-				},
-			},
-		}
+		// 创建模型数据，创建时间使用模型的修改时间
+		modelData := newModelData(modelName, modelName, "organization-owner", parseModifiedAt(modelInfo))
 
 		modelDataList = append(modelDataList, modelData)
 	}
@@ -94,3 +86,98 @@ func ConvertOllamaModelsResponse(ollamaResp map[string]interface{}) OpenAIModelR
 		Data:   modelDataList,
 	}
 }
+
+// NewAliasModelData 创建模型别名的模型数据，root为实际的上游模型
+func NewAliasModelData(alias, target string, created int64) ModelData {
+	return newModelData(alias, target, "ollama-proxy", created)
+}
+
+// NewBackendModelData 创建OpenAI兼容后端提供的模型数据
+func NewBackendModelData(id, ownedBy string) ModelData {
+	return newModelData(id, id, ownedBy, 0)
+}
+
+// ConvertOllamaShowResponse 将Ollama的/api/show响应转换为单个模型的数据
+func ConvertOllamaShowResponse(id, root string, showResp map[string]interface{}) ModelData {
+	ownedBy := "organization-owner"
+	if id != root {
+		ownedBy = "ollama-proxy"
+	}
+	modelData := newModelData(id, root, ownedBy, parseModifiedAt(showResp))
+	modelData.ContextLength = ModelContextLength(showResp)
+
+	details, _ := showResp["details"].(map[string]interface{})
+	modelData.Details = &ModelDetails{
+		Format:            stringValue(details["format"]),
+		Family:            stringValue(details["family"]),
+		ParameterSize:     stringValue(details["parameter_size"]),
+		QuantizationLevel: stringValue(details["quantization_level"]),
+	}
+	if families, ok := details["families"].([]interface{}); ok {
+		for _, family := range families {
+			if name, ok := family.(string); ok {
+				modelData.Details.Families = append(modelData.Details.Families, name)
+			}
+		}
+	}
+	return modelData
+}
+
+// ModelContextLength 从/api/show响应的model_info中读取模型的上下文长度，键名为<架构>.context_length
+func ModelContextLength(showResp map[string]interface{}) int {
+	info, ok := showResp["model_info"].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	if arch, ok := info["general.architecture"].(string); ok {
+		if length, ok := info[arch+".context_length"].(float64); ok {
+			return int(length)
+		}
+	}
+	for key, value := range info {
+		if length, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int(length)
+		}
+	}
+	return 0
+}
+
+// parseModifiedAt 解析Ollama返回的modified_at，失败时返回0
+func parseModifiedAt(info map[string]interface{}) int64 {
+	modifiedAt, _ := info["modified_at"].(string)
+	t, err := time.Parse(time.RFC3339Nano, modifiedAt)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}
+
+func newModelData(id, root, ownedBy string, created int64) ModelData {
+	return ModelData{
+		ID:      id,
+		Object:  "model",
+		Created: created,
+		OwnedBy: ownedBy,
+		Root:    root,
+		Permission: []ModelPermission{
+			{
+				ID:                 NewID(ModelPermissionIDPrefix),
+				Object:             "model_permission",
+				Created:            created,
+				AllowCreateEngine:  false,
+				AllowSampling:      true,
+				AllowLogprobs:      true,
+				AllowSearchIndices: false,
+				AllowView:          true,
+				AllowFineTuning:    false,
+				Organization:       "*",
+				IsBlocking:         false,
+			},
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertOllamaModelsResponse(t *testing.T) {
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(`{"models":[
+		{"name":"qwen2.5:7b","modified_at":"2024-06-01T08:00:00.123456789+08:00"},
+		{"name":"llama3.2:latest","modified_at":"invalid"},
+		{"model":"no-name"}
+	]}`), &resp)
+
+	result := ConvertOllamaModelsResponse(resp)
+	if result.Object != "list" || len(result.Data) != 2 {
+		t.Fatalf("result = %+v", result)
+	}
+	// created使用modified_at，无法解析时为0
+	if result.Data[0].Created != 1717200000 || result.Data[1].Created != 0 {
+		t.Errorf("created = %d, %d", result.Data[0].Created, result.Data[1].Created)
+	}
+	first, second := result.Data[0].Permission[0], result.Data[1].Permission[0]
+	if first.ID == second.ID || first.Created != result.Data[0].Created {
+		t.Errorf("permission = %+v, %+v", first, second)
+	}
+}
+
+func TestConvertOllamaShowResponse(t *testing.T) {
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(`{
+		"modified_at":"2024-06-01T00:00:00Z",
+		"details":{"format":"gguf","family":"llama","families":["llama","clip"],"parameter_size":"8.0B","quantization_level":"Q4_0"},
+		"model_info":{"general.architecture":"llama","llama.context_length":131072}
+	}`), &resp)
+
+	model := ConvertOllamaShowResponse("gpt-4o-mini", "llama3.1:8b", resp)
+	if model.ID != "gpt-4o-mini" || model.Root != "llama3.1:8b" || model.OwnedBy != "ollama-proxy" {
+		t.Errorf("别名的模型数据 = %+v", model)
+	}
+	if model.ContextLength != 131072 || model.Created != 1717200000 {
+		t.Errorf("context_length = %d, created = %d", model.ContextLength, model.Created)
+	}
+	if model.Details.Family != "llama" || len(model.Details.Families) != 2 || model.Details.QuantizationLevel != "Q4_0" {
+		t.Errorf("details = %+v", model.Details)
+	}
+	if ConvertOllamaShowResponse("x", "x", resp).OwnedBy != "organization-owner" {
+		t.Errorf("非别名的owned_by应为organization-owner")
+	}
+}
+
+func TestModelContextLength(t *testing.T) {
+	tests := []struct {
+		info string
+		want int
+	}{
+		{`{"general.architecture":"qwen2","qwen2.context_length":32768,"bert.context_length":512}`, 32768},
+		// 没有general.architecture时使用任意<架构>.context_length
+		{`{"gemma3.context_length":8192}`, 8192},
+		{`{"general.architecture":"qwen2"}`, 0},
+	}
+	for _, tt := range tests {
+		var info map[string]interface{}
+		json.Unmarshal([]byte(tt.info), &info)
+		if got := ModelContextLength(map[string]interface{}{"model_info": info}); got != tt.want {
+			t.Errorf("%s: context_length = %d, want %d", tt.info, got, tt.want)
+		}
+	}
+	if got := ModelContextLength(map[string]interface{}{}); got != 0 {
+		t.Errorf("没有model_info时 context_length = %d", got)
+	}
+}
@@ -0,0 +1,591 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 以下为Ollama格式到OpenAI格式的反向转换，用于将Ollama接口的请求发送到只支持OpenAI接口的后端（如vLLM、llama.cpp server）
+
+// OpenAI兼容后端的接口
+const (
+	OpenAIChatPath       = "/v1/chat/completions"
+	OpenAICompletionPath = "/v1/completions"
+	OpenAIEmbeddingPath  = "/v1/embeddings"
+	OpenAIModelsPath     = "/v1/models"
+)
+
+// optionNames Ollama选项与OpenAI请求参数的对应关系，top_k和min_p为vLLM和llama.cpp server支持的扩展参数
+var optionNames = map[string]string{
+	"temperature":       "temperature",
+	"top_p":             "top_p",
+	"top_k":             "top_k",
+	"min_p":             "min_p",
+	"seed":              "seed",
+	"stop":              "stop",
+	"presence_penalty":  "presence_penalty",
+	"frequency_penalty": "frequency_penalty",
+}
+
+// OllamaChatToOpenAI 将Ollama聊天请求转换为OpenAI聊天请求
+func OllamaChatToOpenAI(req map[string]interface{}) map[string]interface{} {
+	result := openAIRequestBase(req)
+	messages, _ := req["messages"].([]interface{})
+	result["messages"] = toOpenAIMessages(messages)
+	if tools, ok := req["tools"].([]interface{}); ok && len(tools) > 0 {
+		result["tools"] = tools
+	}
+	if logprobs, ok := req["logprobs"].(bool); ok && logprobs {
+		result["logprobs"] = true
+		if top, ok := req["top_logprobs"].(float64); ok && top > 0 {
+			result["top_logprobs"] = int(top)
+		}
+	}
+	return result
+}
+
+// OllamaGenerateToOpenAI 将Ollama生成请求转换为OpenAI请求，返回请求的接口
+// raw模式或带有suffix的请求不使用对话模板，发送到/v1/completions，其余请求作为单轮对话发送到/v1/chat/completions
+func OllamaGenerateToOpenAI(req map[string]interface{}) (string, map[string]interface{}) {
+	result := openAIRequestBase(req)
+	prompt, _ := req["prompt"].(string)
+	raw, _ := req["raw"].(bool)
+	suffix, _ := req["suffix"].(string)
+	if raw || suffix != "" {
+		result["prompt"] = prompt
+		if suffix != "" {
+			result["suffix"] = suffix
+		}
+		return OpenAICompletionPath, result
+	}
+
+	var messages []interface{}
+	if system, _ := req["system"].(string); system != "" {
+		messages = append(messages, map[string]interface{}{"role": "system", "content": system})
+	}
+	user := map[string]interface{}{"role": "user", "content": prompt}
+	if images, ok := req["images"].([]interface{}); ok && len(images) > 0 {
+		user["images"] = images
+	}
+	messages = append(messages, user)
+	result["messages"] = toOpenAIMessages(messages)
+	return OpenAIChatPath, result
+}
+
+// openAIRequestBase 转换聊天和生成请求共有的模型、流式和采样参数
+func openAIRequestBase(req map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{"model": req["model"]}
+
+	// Ollama默认使用流式响应
+	stream := true
+	if value, ok := req["stream"].(bool); ok {
+		stream = value
+	}
+	result["stream"] = stream
+	if stream {
+		// 在最后一个分块中返回token用量，用于生成eval_count
+		result["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	options, _ := req["options"].(map[string]interface{})
+	for name, param := range optionNames {
+		if value, ok := options[name]; ok && value != nil {
+			result[param] = value
+		}
+	}
+	if numPredict, ok := options["num_predict"].(float64); ok && numPredict > 0 {
+		result["max_tokens"] = int(numPredict)
+	}
+
+	switch format := req["format"].(type) {
+	case string:
+		if format == "json" {
+			result["response_format"] = map[string]interface{}{"type": "json_object"}
+		}
+	case map[string]interface{}:
+		result["response_format"] = map[string]interface{}{
+			"type":        "json_schema",
+			"json_schema": map[string]interface{}{"name": "response", "schema": format},
+		}
+	}
+	if effort, ok := req["think"].(string); ok && effort != "" {
+		result["reasoning_effort"] = effort
+	}
+	return result
+}
+
+// toOpenAIMessages 转换消息中的图片和工具调用
+// Ollama的工具调用没有ID，按顺序为工具调用生成ID，并关联到之后同名的工具结果消息
+func toOpenAIMessages(messages []interface{}) []map[string]interface{} {
+	type pendingCall struct {
+		id   string
+		name string
+	}
+	var pending []pendingCall
+
+	result := make([]map[string]interface{}, 0, len(messages))
+	for i, item := range messages {
+		message, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		role, _ := message["role"].(string)
+		content, _ := message["content"].(string)
+		converted := map[string]interface{}{"role": role, "content": content}
+
+		if images, ok := message["images"].([]interface{}); ok && len(images) > 0 {
+			parts := []interface{}{map[string]interface{}{"type": "text", "text": content}}
+			for _, image := range images {
+				data, _ := image.(string)
+				parts = append(parts, map[string]interface{}{
+					"type":      "image_url",
+					"image_url": map[string]interface{}{"url": "data:" + imageMediaType(data) + ";base64," + data},
+				})
+			}
+			converted["content"] = parts
+		}
+
+		if calls, ok := message["tool_calls"].([]interface{}); ok && len(calls) > 0 {
+			var toolCalls []ToolCall
+			for j, item := range calls {
+				call, _ := item.(map[string]interface{})
+				function, _ := call["function"].(map[string]interface{})
+				name, _ := function["name"].(string)
+				arguments, _ := json.Marshal(function["arguments"])
+				if function["arguments"] == nil {
+					arguments = []byte("{}")
+				}
+				quoted, _ := json.Marshal(string(arguments))
+				id := fmt.Sprintf("%s%d_%d", ToolCallIDPrefix, i, j)
+				toolCalls = append(toolCalls, ToolCall{ID: id, Type: "function", Function: ToolCallFunction{Name: name, Arguments: quoted}})
+				pending = append(pending, pendingCall{id: id, name: name})
+			}
+			converted["tool_calls"] = toolCalls
+		}
+
+		if role == "tool" {
+			name, _ := message["tool_name"].(string)
+			for j, call := range pending {
+				if name == "" || call.name == name {
+					converted["tool_call_id"] = call.id
+					pending = append(pending[:j], pending[j+1:]...)
+					break
+				}
+			}
+		}
+		result = append(result, converted)
+	}
+	return result
+}
+
+// imageMediaType 根据base64编码的文件头判断图片格式
+func imageMediaType(data string) string {
+	switch {
+	case strings.HasPrefix(data, "iVBORw0KGgo"):
+		return "image/png"
+	case strings.HasPrefix(data, "R0lGOD"):
+		return "image/gif"
+	case strings.HasPrefix(data, "UklGR"):
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// OllamaResponseBuilder 将OpenAI兼容后端的响应转换为Ollama格式
+type OllamaResponseBuilder struct {
+	// Model 返回给客户端的模型名称
+	Model string
+	// Generate 为true时转换为/api/generate的响应格式，否则为/api/chat
+	Generate bool
+	// Start 请求开始的时间，用于计算total_duration
+	Start time.Time
+	// PromptEstimate 后端没有返回token用量时使用的prompt_eval_count估算值
+	PromptEstimate int
+
+	doneReason   string
+	promptTokens float64
+	evalTokens   float64
+	toolCalls    []*streamToolCall
+	// firstToken 收到第一个输出分块的时间，之前的时间计入prompt_eval_duration
+	firstToken time.Time
+	// chunks、output 用于在后端没有返回token用量时估算eval_count
+	chunks int
+	output strings.Builder
+}
+
+// streamToolCall 流式响应中按index逐步拼接的工具调用
+type streamToolCall struct {
+	name      string
+	arguments strings.Builder
+}
+
+// Response 转换非流式响应
+func (b *OllamaResponseBuilder) Response(resp map[string]interface{}) map[string]interface{} {
+	b.usage(resp)
+	choice := firstChoice(resp)
+	b.finishReason(choice)
+
+	message, _ := choice["message"].(map[string]interface{})
+	text, _ := choice["text"].(string)
+	if message != nil {
+		text, _ = message["content"].(string)
+	}
+	reasoning := reasoningText(message)
+	b.output.WriteString(reasoning)
+	b.output.WriteString(text)
+	result := b.line(text, reasoning, true)
+	if calls := ollamaToolCalls(message); len(calls) > 0 && !b.Generate {
+		result["message"].(map[string]interface{})["tool_calls"] = calls
+	}
+	items, _ := message["tool_calls"].([]interface{})
+	for _, item := range items {
+		call, _ := item.(map[string]interface{})
+		function, _ := call["function"].(map[string]interface{})
+		arguments, _ := function["arguments"].(string)
+		b.output.WriteString(arguments)
+	}
+	b.addDoneStats(result)
+	return result
+}
+
+// Chunk 转换一个流式分块，没有需要输出的内容时返回nil
+func (b *OllamaResponseBuilder) Chunk(chunk map[string]interface{}) map[string]interface{} {
+	b.usage(chunk)
+	choice := firstChoice(chunk)
+	if choice == nil {
+		return nil
+	}
+	b.finishReason(choice)
+
+	delta, _ := choice["delta"].(map[string]interface{})
+	text, _ := choice["text"].(string)
+	if delta != nil {
+		text, _ = delta["content"].(string)
+	}
+	calls := b.addToolCallDeltas(delta)
+
+	reasoning := reasoningText(delta)
+	if text != "" || reasoning != "" || calls > 0 {
+		// vLLM和llama.cpp server每个分块通常对应一个token
+		if b.firstToken.IsZero() {
+			b.firstToken = time.Now()
+		}
+		b.chunks++
+		b.output.WriteString(reasoning)
+		b.output.WriteString(text)
+	}
+	if text == "" && reasoning == "" {
+		return nil
+	}
+	return b.line(text, reasoning, false)
+}
+
+// Done 流式响应结束时输出的分块：拼接完成的工具调用以及带有统计信息的结束分块
+func (b *OllamaResponseBuilder) Done() []map[string]interface{} {
+	var lines []map[string]interface{}
+	if len(b.toolCalls) > 0 && !b.Generate {
+		var calls []interface{}
+		for _, call := range b.toolCalls {
+			calls = append(calls, map[string]interface{}{
+				"function": map[string]interface{}{
+					"name":      call.name,
+					"arguments": json.RawMessage(argumentsObject(mustQuote(call.arguments.String()))),
+				},
+			})
+		}
+		line := b.line("", "", false)
+		line["message"].(map[string]interface{})["tool_calls"] = calls
+		lines = append(lines, line)
+	}
+	done := b.line("", "", true)
+	b.addDoneStats(done)
+	return append(lines, done)
+}
+
+// line 构造一行Ollama响应
+func (b *OllamaResponseBuilder) line(text, reasoning string, done bool) map[string]interface{} {
+	result := map[string]interface{}{
+		"model":      b.Model,
+		"created_at": time.Now().UTC().Format(time.RFC3339Nano),
+		"done":       done,
+	}
+	if b.Generate {
+		result["response"] = text
+		if reasoning != "" {
+			result["thinking"] = reasoning
+		}
+		return result
+	}
+	message := map[string]interface{}{"role": "assistant", "content": text}
+	if reasoning != "" {
+		message["thinking"] = reasoning
+	}
+	result["message"] = message
+	return result
+}
+
+// addDoneStats 添加结束分块的统计信息
+// OpenAI接口没有返回耗时，第一个输出分块之前的时间作为prompt_eval_duration，之后的时间作为eval_duration，
+// 非流式响应无法区分两个阶段，全部计入eval_duration；后端没有返回token用量时按输出内容估算
+func (b *OllamaResponseBuilder) addDoneStats(result map[string]interface{}) {
+	reason := b.doneReason
+	if reason == "" {
+		reason = "stop"
+	}
+	end := time.Now()
+	first := b.firstToken
+	if first.IsZero() {
+		first = b.Start
+	}
+
+	promptTokens := int(b.promptTokens)
+	if promptTokens == 0 {
+		promptTokens = b.PromptEstimate
+	}
+	evalTokens := int(b.evalTokens)
+	if evalTokens == 0 {
+		evalTokens = b.chunks
+		if evalTokens == 0 {
+			evalTokens = EstimateTokens(b.output.String())
+		}
+	}
+
+	result["done_reason"] = reason
+	result["total_duration"] = end.Sub(b.Start).Nanoseconds()
+	result["load_duration"] = 0
+	result["prompt_eval_count"] = promptTokens
+	result["prompt_eval_duration"] = first.Sub(b.Start).Nanoseconds()
+	result["eval_count"] = evalTokens
+	result["eval_duration"] = end.Sub(first).Nanoseconds()
+}
+
+// usage 记录OpenAI响应中的token用量，流式响应在最后一个分块中返回
+func (b *OllamaResponseBuilder) usage(resp map[string]interface{}) {
+	usage, ok := resp["usage"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if tokens, ok := usage["prompt_tokens"].(float64); ok {
+		b.promptTokens = tokens
+	}
+	if tokens, ok := usage["completion_tokens"].(float64); ok {
+		b.evalTokens = tokens
+	}
+}
+
+// finishReason 将OpenAI的finish_reason转换为Ollama的done_reason
+func (b *OllamaResponseBuilder) finishReason(choice map[string]interface{}) {
+	switch reason, _ := choice["finish_reason"].(string); reason {
+	case "":
+	case "length":
+		b.doneReason = "length"
+	default:
+		b.doneReason = "stop"
+	}
+}
+
+// addToolCallDeltas 按index拼接流式响应中的工具调用，参数会被拆分到多个分块中，返回分块中工具调用的数量
+func (b *OllamaResponseBuilder) addToolCallDeltas(delta map[string]interface{}) int {
+	calls, _ := delta["tool_calls"].([]interface{})
+	for i, item := range calls {
+		call, _ := item.(map[string]interface{})
+		index := i
+		if value, ok := call["index"].(float64); ok {
+			index = int(value)
+		}
+		for len(b.toolCalls) <= index {
+			b.toolCalls = append(b.toolCalls, &streamToolCall{})
+		}
+		function, _ := call["function"].(map[string]interface{})
+		if name, _ := function["name"].(string); name != "" {
+			b.toolCalls[index].name = name
+		}
+		arguments, _ := function["arguments"].(string)
+		b.toolCalls[index].arguments.WriteString(arguments)
+		b.output.WriteString(arguments)
+	}
+	return len(calls)
+}
+
+func firstChoice(resp map[string]interface{}) map[string]interface{} {
+	choices, _ := resp["choices"].([]interface{})
+	if len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	return choice
+}
+
+// reasoningText 读取推理内容，vLLM使用reasoning_content，部分服务使用reasoning
+func reasoningText(message map[string]interface{}) string {
+	if text, ok := message["reasoning_content"].(string); ok {
+		return text
+	}
+	text, _ := message["reasoning"].(string)
+	return text
+}
+
+// ollamaToolCalls 将OpenAI格式的工具调用转换为Ollama格式，参数由JSON字符串转换为JSON对象
+func ollamaToolCalls(message map[string]interface{}) []interface{} {
+	items, _ := message["tool_calls"].([]interface{})
+	var calls []interface{}
+	for _, item := range items {
+		call, _ := item.(map[string]interface{})
+		function, _ := call["function"].(map[string]interface{})
+		arguments, _ := function["arguments"].(string)
+		calls = append(calls, map[string]interface{}{
+			"function": map[string]interface{}{
+				"name":      function["name"],
+				"arguments": json.RawMessage(argumentsObject(mustQuote(arguments))),
+			},
+		})
+	}
+	return calls
+}
+
+// mustQuote 将字符串编码为JSON字符串，空字符串视为没有参数
+func mustQuote(text string) json.RawMessage {
+	if text == "" {
+		text = "{}"
+	}
+	quoted, _ := json.Marshal(text)
+	return quoted
+}
+
+// EstimatePromptTokens 估算转换后的OpenAI请求的prompt token数，用于后端没有返回token用量的情况
+func EstimatePromptTokens(req map[string]interface{}) int {
+	if prompt, ok := req["prompt"].(string); ok {
+		return EstimateTokens(prompt)
+	}
+	messages, _ := req["messages"].([]map[string]interface{})
+	tokens := 0
+	for _, message := range messages {
+		tokens += messageOverheadTokens
+		switch content := message["content"].(type) {
+		case string:
+			tokens += EstimateTokens(content)
+		case []interface{}:
+			for _, item := range content {
+				part, _ := item.(map[string]interface{})
+				if text, ok := part["text"].(string); ok {
+					tokens += EstimateTokens(text)
+				}
+			}
+		}
+	}
+	if tools, ok := req["tools"]; ok {
+		data, _ := json.Marshal(tools)
+		tokens += EstimateTokens(string(data))
+	}
+	return tokens
+}
+
+// OllamaEmbedToOpenAI 将Ollama的/api/embed请求转换为OpenAI的/v1/embeddings请求
+func OllamaEmbedToOpenAI(req map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"model":           req["model"],
+		"input":           req["input"],
+		"encoding_format": "float",
+	}
+	if dimensions, ok := req["dimensions"].(float64); ok && dimensions > 0 {
+		result["dimensions"] = int(dimensions)
+	}
+	return result
+}
+
+// OpenAIEmbeddingToOllama 将OpenAI的embeddings响应转换为Ollama的/api/embed响应，向量按index排序
+func OpenAIEmbeddingToOllama(model string, resp map[string]interface{}, start time.Time) map[string]interface{} {
+	data, _ := resp["data"].([]interface{})
+	embeddings := make([]interface{}, len(data))
+	for i, item := range data {
+		entry, _ := item.(map[string]interface{})
+		index := i
+		if value, ok := entry["index"].(float64); ok && int(value) >= 0 && int(value) < len(data) {
+			index = int(value)
+		}
+		embeddings[index] = entry["embedding"]
+	}
+
+	promptTokens := 0
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		if tokens, ok := usage["prompt_tokens"].(float64); ok {
+			promptTokens = int(tokens)
+		}
+	}
+	return map[string]interface{}{
+		"model":             model,
+		"embeddings":        embeddings,
+		"total_duration":    time.Since(start).Nanoseconds(),
+		"load_duration":     0,
+		"prompt_eval_count": promptTokens,
+	}
+}
+
+// OpenAIModelsToOllamaTags 将OpenAI的模型列表转换为Ollama的/api/tags响应
+// OpenAI接口没有模型大小、digest和模型详情，这些字段为空
+func OpenAIModelsToOllamaTags(resp map[string]interface{}) map[string]interface{} {
+	data, _ := resp["data"].([]interface{})
+	tags := make([]interface{}, 0, len(data))
+	for _, item := range data {
+		entry, _ := item.(map[string]interface{})
+		id, ok := entry["id"].(string)
+		if !ok || id == "" {
+			continue
+		}
+		tag := map[string]interface{}{
+			"name":   id,
+			"model":  id,
+			"size":   0,
+			"digest": "",
+			"details": map[string]interface{}{
+				"parent_model":       "",
+				"format":             "",
+				"family":             "",
+				"families":           nil,
+				"parameter_size":     "",
+				"quantization_level": "",
+			},
+		}
+		if created, ok := entry["created"].(float64); ok && created > 0 {
+			tag["modified_at"] = time.Unix(int64(created), 0).UTC().Format(time.RFC3339)
+		}
+		tags = append(tags, tag)
+	}
+	return map[string]interface{}{"models": tags}
+}
+
+// OpenAIModelToOllamaShow 在OpenAI的模型列表中查找模型，转换为Ollama的/api/show响应，模型不存在时返回nil
+// OpenAI接口没有模型模板和参数信息，只返回基本字段，vLLM返回的max_model_len作为上下文长度
+func OpenAIModelToOllamaShow(model string, resp map[string]interface{}) map[string]interface{} {
+	data, _ := resp["data"].([]interface{})
+	for _, item := range data {
+		entry, _ := item.(map[string]interface{})
+		if id, _ := entry["id"].(string); id != model {
+			continue
+		}
+		modelInfo := map[string]interface{}{}
+		if length, ok := entry["max_model_len"].(float64); ok && length > 0 {
+			modelInfo["general.architecture"] = "openai"
+			modelInfo["openai.context_length"] = int(length)
+		}
+		return map[string]interface{}{
+			"modelfile":  "",
+			"parameters": "",
+			"template":   "",
+			"details": map[string]interface{}{
+				"parent_model":       "",
+				"format":             "",
+				"family":             "",
+				"families":           nil,
+				"parameter_size":     "",
+				"quantization_level": "",
+			},
+			"model_info":   modelInfo,
+			"capabilities": []string{"completion"},
+		}
+	}
+	return nil
+}
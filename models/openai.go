@@ -2,7 +2,8 @@ package models
 
 import (
 	"encoding/json"
-	"fmt"
+	"reflect"
+	"strings"
 	"time"
 )
 
@@ -12,74 +13,274 @@ type OpenAIChatRequest struct {
 	Messages         []ChatMessage   `json:"messages"`
 	Stream           bool            `json:"stream"`
 	MaxTokens        int             `json:"max_tokens,omitempty"`
-	Temperature      float64         `json:"temperature,omitempty"`
+	Temperature      *float64        `json:"temperature,omitempty"`
 	TopP             float64         `json:"top_p,omitempty"`
 	N                int             `json:"n,omitempty"`
 	Stop             []string        `json:"stop,omitempty"`
 	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
 	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
-	LogitBias        map[string]int  `json:"logit_bias,omitempty"`
+	LogitBias        map[string]int  `json:"logit_bias,omitempty"` // Ollama不支持，解析后忽略
+	Logprobs         bool            `json:"logprobs,omitempty"`
+	TopLogprobs      int             `json:"top_logprobs,omitempty"`
+	Seed             *int            `json:"seed,omitempty"`
+	Tools            []Tool          `json:"tools,omitempty"`
+	ToolChoice       interface{}     `json:"tool_choice,omitempty"`
+	ReasoningEffort  string          `json:"reasoning_effort,omitempty"`
+	Think            *bool           `json:"think,omitempty"`
 	User             string          `json:"user,omitempty"`
 	Options          *RequestOptions `json:"options,omitempty"`
 }
 
 // OpenAICompletionRequest OpenAI风格的生成请求
 type OpenAICompletionRequest struct {
-	Model            string          `json:"model"`
-	Prompt           string          `json:"prompt"`
-	MaxTokens        int             `json:"max_tokens,omitempty"`
-	Temperature      float64         `json:"temperature,omitempty"`
-	TopP             float64         `json:"top_p,omitempty"`
-	N                int             `json:"n,omitempty"`
-	Stream           bool            `json:"stream"`
-	Logprobs         int             `json:"logprobs,omitempty"`
-	Stop             []string        `json:"stop,omitempty"`
-	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
-	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
-	BestOf           int             `json:"best_of,omitempty"`
-	User             string          `json:"user,omitempty"`
-	Options          *RequestOptions `json:"options,omitempty"`
+	Model            string           `json:"model"`
+	Prompt           CompletionPrompt `json:"prompt"`
+	Suffix           string           `json:"suffix,omitempty"`
+	Echo             bool             `json:"echo,omitempty"`
+	MaxTokens        int              `json:"max_tokens,omitempty"`
+	Temperature      *float64         `json:"temperature,omitempty"`
+	TopP             float64          `json:"top_p,omitempty"`
+	N                int              `json:"n,omitempty"`
+	Stream           bool             `json:"stream"`
+	Logprobs         *int             `json:"logprobs,omitempty"`
+	Stop             []string         `json:"stop,omitempty"`
+	PresencePenalty  float64          `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64          `json:"frequency_penalty,omitempty"`
+	BestOf           int              `json:"best_of,omitempty"`
+	LogitBias        map[string]int   `json:"logit_bias,omitempty"` // Ollama不支持，解析后忽略
+	Seed             *int             `json:"seed,omitempty"`
+	Raw              bool             `json:"raw,omitempty"`
+	Template         string           `json:"template,omitempty"`
+	User             string           `json:"user,omitempty"`
+	Options          *RequestOptions  `json:"options,omitempty"`
 }
 
 // OpenAIEmbeddingRequest OpenAI风格的Embedding请求
 type OpenAIEmbeddingRequest struct {
-	Model string   `json:"model"`
-	Input []string `json:"input"`
-	User  string   `json:"user,omitempty"`
+	Model          string         `json:"model"`
+	Input          EmbeddingInput `json:"input"`
+	EncodingFormat string         `json:"encoding_format,omitempty"`
+	Dimensions     int            `json:"dimensions,omitempty"`
+	User           string         `json:"user,omitempty"`
 }
 
 // ChatMessage 聊天消息结构
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	// ReasoningContent 推理内容，与DeepSeek接口的字段一致
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string     `json:"tool_call_id,omitempty"`
+	Name             string     `json:"name,omitempty"`
 }
 
 // RequestOptions 请求选项
 type RequestOptions struct {
-	Temperature float64 `json:"temperature,omitempty"`
-	TopP        float64 `json:"top_p,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	TopP        float64  `json:"top_p,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	NumCtx      int      `json:"num_ctx,omitempty"`
+	NumPredict  int      `json:"num_predict,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	// Extra 其他Ollama选项，如num_gpu、mirostat、repeat_last_n，原样转发
+	Extra map[string]interface{} `json:"-"`
+}
+
+// requestOptionsFields 与RequestOptions字段相同，不带自定义的JSON方法
+type requestOptionsFields RequestOptions
+
+// optionFieldNames RequestOptions中有对应字段的选项名称
+var optionFieldNames = func() map[string]bool {
+	names := map[string]bool{}
+	typ := reflect.TypeOf(RequestOptions{})
+	for i := 0; i < typ.NumField(); i++ {
+		if name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}()
+
+// MarshalJSON 输出已知字段和Extra中的选项，已知字段优先
+func (o RequestOptions) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(requestOptionsFields(o))
+	if err != nil || len(o.Extra) == 0 {
+		return data, err
+	}
+	merged := make(map[string]interface{}, len(o.Extra))
+	for key, value := range o.Extra {
+		merged[key] = value
+	}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON 解析已知字段，其余选项保存到Extra
+func (o *RequestOptions) UnmarshalJSON(data []byte) error {
+	var fields requestOptionsFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	for key, value := range all {
+		if optionFieldNames[key] {
+			continue
+		}
+		if fields.Extra == nil {
+			fields.Extra = map[string]interface{}{}
+		}
+		fields.Extra[key] = value
+	}
+	*o = RequestOptions(fields)
+	return nil
+}
+
+// SamplingParams OpenAI请求顶层的采样参数
+type SamplingParams struct {
+	Temperature *float64
+	TopP        float64
+	Seed        *int
+	MaxTokens   int
+	Stop        []string
+}
+
+// Sampling 返回聊天请求顶层的采样参数
+func (r *OpenAIChatRequest) Sampling() SamplingParams {
+	return SamplingParams{
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+		Seed:        r.Seed,
+		MaxTokens:   r.MaxTokens,
+		Stop:        r.Stop,
+	}
+}
+
+// Sampling 返回生成请求顶层的采样参数
+func (r *OpenAICompletionRequest) Sampling() SamplingParams {
+	return SamplingParams{
+		Temperature: r.Temperature,
+		TopP:        r.TopP,
+		Seed:        r.Seed,
+		MaxTokens:   r.MaxTokens,
+		Stop:        r.Stop,
+	}
+}
+
+// MergeOptions 将OpenAI请求顶层的采样参数合并到Ollama选项中，options中已设置的值优先
+func MergeOptions(options *RequestOptions, params SamplingParams) *RequestOptions {
+	merged := RequestOptions{}
+	if options != nil {
+		merged = *options
+	}
+	if merged.Temperature == nil {
+		merged.Temperature = params.Temperature
+	}
+	if merged.TopP == 0 {
+		merged.TopP = params.TopP
+	}
+	if merged.Seed == nil {
+		merged.Seed = params.Seed
+	}
+	// max_tokens对应Ollama的num_predict，超出后done_reason为length
+	if merged.NumPredict == 0 {
+		merged.NumPredict = params.MaxTokens
+	}
+	if merged.Stop == nil {
+		merged.Stop = params.Stop
+	}
+	if reflect.DeepEqual(merged, RequestOptions{}) {
+		return nil
+	}
+	return &merged
+}
+
+// ApplyDefaultOptions 使用默认选项补全请求中未设置的字段
+func ApplyDefaultOptions(options *RequestOptions, defaults map[string]interface{}) *RequestOptions {
+	return mergeOptionMap(options, defaults, false)
+}
+
+// OverrideOptions 使用给定的选项覆盖请求中的值，用于强制生效的策略
+func OverrideOptions(options *RequestOptions, overrides map[string]interface{}) *RequestOptions {
+	return mergeOptionMap(options, overrides, true)
+}
+
+// mergeOptionMap 在map层面合并选项，override为true时values优先，否则请求中已设置的字段优先
+// 没有对应字段的选项保存在Extra中，不会在合并时丢失
+func mergeOptionMap(options *RequestOptions, values map[string]interface{}, override bool) *RequestOptions {
+	if len(values) == 0 {
+		return options
+	}
+
+	merged := map[string]interface{}{}
+	if options != nil {
+		data, _ := json.Marshal(options)
+		json.Unmarshal(data, &merged)
+	}
+	for key, value := range values {
+		if _, ok := merged[key]; ok && !override {
+			continue
+		}
+		merged[key] = value
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return options
+	}
+	var result RequestOptions
+	if err := json.Unmarshal(data, &result); err != nil {
+		return options
+	}
+	return &result
+}
+
+// IsDeterministic 判断请求在相同模型下是否会产生确定的输出（temperature为0或指定了seed）
+func (o *RequestOptions) IsDeterministic() bool {
+	if o == nil {
+		return false
+	}
+	return o.Seed != nil || (o.Temperature != nil && *o.Temperature == 0)
 }
 
 // OllamaChatRequest Ollama聊天请求
 type OllamaChatRequest struct {
-	Model    string          `json:"model"`
-	Messages []ChatMessage   `json:"messages"`
-	Stream   bool            `json:"stream"`
-	Options  *RequestOptions `json:"options,omitempty"`
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Tools    []Tool        `json:"tools,omitempty"`
+	Stream   bool          `json:"stream"`
+	// Think 开启或关闭思考，bool或low/medium/high
+	Think       interface{}     `json:"think,omitempty"`
+	Logprobs    bool            `json:"logprobs,omitempty"`
+	TopLogprobs int             `json:"top_logprobs,omitempty"`
+	Options     *RequestOptions `json:"options,omitempty"`
 }
 
 // OllamaGenerateRequest Ollama生成请求
 type OllamaGenerateRequest struct {
-	Model   string          `json:"model"`
-	Prompt  string          `json:"prompt"`
-	Stream  bool            `json:"stream"`
-	Options *RequestOptions `json:"options,omitempty"`
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	// Suffix 插入位置之后的内容，用于代码补全模型的FIM
+	Suffix      string          `json:"suffix,omitempty"`
+	System      string          `json:"system,omitempty"`
+	Template    string          `json:"template,omitempty"`
+	Raw         bool            `json:"raw,omitempty"`
+	Stream      bool            `json:"stream"`
+	Logprobs    bool            `json:"logprobs,omitempty"`
+	TopLogprobs int             `json:"top_logprobs,omitempty"`
+	Options     *RequestOptions `json:"options,omitempty"`
 }
 
 // OllamaEmbeddingRequest Ollama Embedding请求
+// token数组形式的输入只有OpenAI兼容后端支持，由代理原样转发
 type OllamaEmbeddingRequest struct {
-	Model string   `json:"model"`
-	Input []string `json:"input"`
+	Model string         `json:"model"`
+	Input EmbeddingInput `json:"input"`
 }
 
 // OpenAIChatResponse OpenAI风格的聊天响应
@@ -139,32 +340,34 @@ type Usage struct {
 
 // ChatChoice 聊天响应选项
 type ChatChoice struct {
-	Index        int         `json:"index"`
-	Message      ChatMessage `json:"message"`
-	FinishReason string      `json:"finish_reason"`
+	Index        int           `json:"index"`
+	Message      ChatMessage   `json:"message"`
+	Logprobs     *ChatLogprobs `json:"logprobs"`
+	FinishReason string        `json:"finish_reason"`
 }
 
-// StreamChatChoice 聊天响应选项
+// StreamChatChoice 聊天响应选项，FinishReason在最后一个分块之前为null
 type StreamChatChoice struct {
-	Index        int         `json:"index"`
-	Message      ChatMessage `json:"delta"`
-	FinishReason string      `json:"finish_reason"`
+	Index        int           `json:"index"`
+	Message      ChatMessage   `json:"delta"`
+	Logprobs     *ChatLogprobs `json:"logprobs"`
+	FinishReason *string       `json:"finish_reason"`
 }
 
 // Choice 响应选项
 type Choice struct {
 	Text         string    `json:"text"`
 	Index        int       `json:"index"`
-	Logprobs     *Logprobs `json:"logprobs,omitempty"`
+	Logprobs     *Logprobs `json:"logprobs"`
 	FinishReason string    `json:"finish_reason"`
 }
 
-// StreamChoice 响应选项
+// StreamChoice 响应选项，FinishReason在最后一个分块之前为null
 type StreamChoice struct {
 	Text         string    `json:"text"`
 	Index        int       `json:"index"`
-	Logprobs     *Logprobs `json:"logprobs,omitempty"`
-	FinishReason string    `json:"finish_reason"`
+	Logprobs     *Logprobs `json:"logprobs"`
+	FinishReason *string   `json:"finish_reason"`
 }
 
 // Logprobs 日志概率
@@ -177,9 +380,10 @@ type Logprobs struct {
 
 // EmbeddingResult Embedding结果
 type EmbeddingResult struct {
-	Object    string    `json:"object"`
-	Embedding []float64 `json:"embedding"`
-	Index     int       `json:"index"`
+	Object string `json:"object"`
+	// Embedding 默认为float数组，encoding_format为base64时为base64字符串
+	Embedding interface{} `json:"embedding"`
+	Index     int         `json:"index"`
 }
 
 // ConvertOllamaChatResponse 将Ollama响应转换为OpenAI格式
@@ -197,19 +401,27 @@ func ConvertOllamaChatResponse(ollamaResp map[string]interface{}, model string)
 		evalCount = eval
 	}
 
+	message, _ := ollamaResp["message"].(map[string]interface{})
+	content, _ := message["content"].(string)
+	thinking, _ := message["thinking"].(string)
+	toolCalls := convertOllamaToolCalls(message, false)
+
 	return OpenAIChatResponse{
-		ID:      generateResponseID(),
+		ID:      NewID(ChatCompletionIDPrefix),
 		Object:  "chat.completion",
 		Created: time.Now().Unix(),
 		Model:   model,
 		Choices: []ChatChoice{
 			{
 				Message: ChatMessage{
-					Role:    "assistant",
-					Content: ollamaResp["message"].(map[string]interface{})["content"].(string),
+					Role:             "assistant",
+					Content:          content,
+					ReasoningContent: thinking,
+					ToolCalls:        toolCalls,
 				},
 				Index:        0,
-				FinishReason: "stop",
+				Logprobs:     ConvertChatLogprobs(ollamaResp),
+				FinishReason: FinishReason(ollamaResp, len(toolCalls) > 0),
 			},
 		},
 		Usage: Usage{
@@ -236,7 +448,7 @@ func ConvertOllamaGenerateResponse(ollamaResp map[string]interface{}, model stri
 	}
 
 	return OpenAICompletionResponse{
-		ID:      generateResponseID(),
+		ID:      NewID(CompletionIDPrefix),
 		Object:  "text_completion",
 		Created: time.Now().Unix(),
 		Model:   model,
@@ -244,7 +456,8 @@ func ConvertOllamaGenerateResponse(ollamaResp map[string]interface{}, model stri
 			{
 				Text:         ollamaResp["response"].(string),
 				Index:        0,
-				FinishReason: "stop",
+				Logprobs:     ConvertCompletionLogprobs(ollamaResp, 0),
+				FinishReason: FinishReason(ollamaResp, false),
 			},
 		},
 		Usage: Usage{
@@ -255,25 +468,58 @@ func ConvertOllamaGenerateResponse(ollamaResp map[string]interface{}, model stri
 	}
 }
 
-// ConvertOllamaEmbeddingResponse 将Ollama响应转换为OpenAI格式
-func ConvertOllamaEmbeddingResponse(ollamaResp map[string]interface{}, model string) OpenAIEmbeddingResponse {
-	str, _ := json.Marshal(ollamaResp)
-	fmt.Println(string(str))
-	// 检查响应中是否包含embeddings字段
-	embeddings, ok := ollamaResp["embeddings"]
-	if !ok || embeddings == nil {
-		fmt.Printf("Error: embeddings field missing in response: %+v\n", ollamaResp)
-		return OpenAIEmbeddingResponse{
-			Object: "error",
-			Data:   []EmbeddingResult{},
-			Model:  model,
+// FinishReason 根据Ollama的done_reason计算OpenAI的finish_reason
+func FinishReason(ollamaResp map[string]interface{}, hasToolCalls bool) string {
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	switch doneReason, _ := ollamaResp["done_reason"].(string); doneReason {
+	case "length":
+		// 达到num_predict或上下文长度上限被截断
+		return "length"
+	default:
+		// stop为正常结束；load/unload为模型加载或卸载请求，没有生成内容，同样视为正常结束
+		return "stop"
+	}
+}
+
+// MergeChatResponses 合并多路生成的聊天响应，choices按顺序重新编号
+func MergeChatResponses(responses []OpenAIChatResponse) OpenAIChatResponse {
+	merged := responses[0]
+	merged.Choices = nil
+	merged.Usage.CompletionTokens = 0
+	for _, resp := range responses {
+		for _, choice := range resp.Choices {
+			choice.Index = len(merged.Choices)
+			merged.Choices = append(merged.Choices, choice)
 		}
+		merged.Usage.CompletionTokens += resp.Usage.CompletionTokens
 	}
+	merged.Usage.TotalTokens = merged.Usage.PromptTokens + merged.Usage.CompletionTokens
+	return merged
+}
+
+// MergeCompletionResponses 合并多路生成的文本生成响应，choices按顺序重新编号
+func MergeCompletionResponses(responses []OpenAICompletionResponse) OpenAICompletionResponse {
+	merged := responses[0]
+	merged.Choices = nil
+	merged.Usage.CompletionTokens = 0
+	for _, resp := range responses {
+		for _, choice := range resp.Choices {
+			choice.Index = len(merged.Choices)
+			merged.Choices = append(merged.Choices, choice)
+		}
+		merged.Usage.CompletionTokens += resp.Usage.CompletionTokens
+	}
+	merged.Usage.TotalTokens = merged.Usage.PromptTokens + merged.Usage.CompletionTokens
+	return merged
+}
 
+// ConvertOllamaEmbeddingResponse 将Ollama响应转换为OpenAI格式
+func ConvertOllamaEmbeddingResponse(ollamaResp map[string]interface{}, model string, opts EmbeddingOptions) OpenAIEmbeddingResponse {
 	// 检查embeddings类型是否正确（应该是二维数组）
-	embeddingsSlice, isSlice := embeddings.([]interface{})
+	embeddingsSlice, isSlice := ollamaResp["embeddings"].([]interface{})
 	if !isSlice || len(embeddingsSlice) == 0 {
-		fmt.Printf("Error: invalid embeddings type %T in response: %+v\n", embeddings, ollamaResp)
 		return OpenAIEmbeddingResponse{
 			Object: "error",
 			Data:   []EmbeddingResult{},
@@ -284,13 +530,18 @@ func ConvertOllamaEmbeddingResponse(ollamaResp map[string]interface{}, model str
 	// 处理所有embedding向量
 	var embeddingResults []EmbeddingResult
 	for i, embedding := range embeddingsSlice {
-		// 转换embedding数据为float64切片
-		embeddingData := convertToFloat64Slice(embedding)
+		// 转换embedding数据为float64切片，并按需截断维度
+		embeddingData := TruncateEmbedding(convertToFloat64Slice(embedding), opts.Dimensions)
+
+		var encoded interface{} = embeddingData
+		if opts.EncodingFormat == "base64" {
+			encoded = EncodeEmbeddingBase64(embeddingData)
+		}
 
 		// 创建EmbeddingResult对象
 		embeddingResults = append(embeddingResults, EmbeddingResult{
 			Object:    "embedding",
-			Embedding: embeddingData,
+			Embedding: encoded,
 			Index:     i,
 		})
 	}
@@ -314,11 +565,6 @@ func ConvertOllamaEmbeddingResponse(ollamaResp map[string]interface{}, model str
 	}
 }
 
-// 辅助函数
-func generateResponseID() string {
-	return "chatcmpl-" + time.Now().Format("20060102150405")
-}
-
 // convertToFloat64Slice 将interface{}类型的embedding数据转换为float64切片
 func convertToFloat64Slice(data interface{}) []float64 {
 	if data == nil {
@@ -328,7 +574,6 @@ func convertToFloat64Slice(data interface{}) []float64 {
 	// 尝试将数据转换为[]interface{}
 	slice, ok := data.([]interface{})
 	if !ok {
-		fmt.Printf("Error: embedding data is not a slice: %+v\n", data)
 		return []float64{}
 	}
 
@@ -345,7 +590,6 @@ func convertToFloat64Slice(data interface{}) []float64 {
 		case int64:
 			result[i] = float64(value)
 		default:
-			fmt.Printf("Error: unexpected type in embedding data: %T\n", v)
 			return []float64{}
 		}
 	}
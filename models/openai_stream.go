@@ -7,19 +7,34 @@ import (
 // ConvertOllamaChatStreamResponse 将Ollama流式响应转换为OpenAI格式
 func ConvertOllamaChatStreamResponse(ollamaResp map[string]interface{}, model string) StreamOpenAIChatResponse {
 
+	message, _ := ollamaResp["message"].(map[string]interface{})
+	content, _ := message["content"].(string)
+	thinking, _ := message["thinking"].(string)
+	toolCalls := convertOllamaToolCalls(message, true)
+
+	// 只有最后一个分块携带finish_reason
+	var finishReason *string
+	if done, ok := ollamaResp["done"].(bool); ok && done {
+		reason := FinishReason(ollamaResp, len(toolCalls) > 0)
+		finishReason = &reason
+	}
+
 	return StreamOpenAIChatResponse{
-		ID:      generateResponseID(),
+		ID:      NewID(ChatCompletionIDPrefix),
 		Object:  "chat.completion.chunk",
 		Created: time.Now().Unix(),
 		Model:   model,
 		Choices: []StreamChatChoice{
 			{
 				Message: ChatMessage{
-					Role:    "assistant",
-					Content: ollamaResp["message"].(map[string]interface{})["content"].(string),
+					Role:             "assistant",
+					Content:          content,
+					ReasoningContent: thinking,
+					ToolCalls:        toolCalls,
 				},
 				Index:        0,
-				FinishReason: "",
+				Logprobs:     ConvertChatLogprobs(ollamaResp),
+				FinishReason: finishReason,
 			},
 		},
 	}
@@ -27,16 +42,24 @@ func ConvertOllamaChatStreamResponse(ollamaResp map[string]interface{}, model st
 
 // ConvertOllamaGenerateStreamResponse 将Ollama流式响应转换为OpenAI格式
 func ConvertOllamaGenerateStreamResponse(ollamaResp map[string]interface{}, model string) StreamOpenAICompletionResponse {
+	// 只有最后一个分块携带finish_reason
+	var finishReason *string
+	if done, ok := ollamaResp["done"].(bool); ok && done {
+		reason := FinishReason(ollamaResp, false)
+		finishReason = &reason
+	}
+
 	return StreamOpenAICompletionResponse{
-		ID:      generateResponseID(),
-		Object:  "text_completion.chunk",
+		ID:      NewID(CompletionIDPrefix),
+		Object:  "text_completion",
 		Created: time.Now().Unix(),
 		Model:   model,
 		Choices: []StreamChoice{
 			{
 				Text:         ollamaResp["response"].(string),
 				Index:        0,
-				FinishReason: "",
+				Logprobs:     ConvertCompletionLogprobs(ollamaResp, 0),
+				FinishReason: finishReason,
 			},
 		},
 	}
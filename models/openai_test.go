@@ -0,0 +1,138 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRequestOptionsKeepsUnknownKeys(t *testing.T) {
+	var options RequestOptions
+	if err := json.Unmarshal([]byte(`{"temperature":0.5,"num_gpu":1,"mirostat":2}`), &options); err != nil {
+		t.Fatal(err)
+	}
+	if options.Temperature == nil || *options.Temperature != 0.5 {
+		t.Errorf("temperature = %v", options.Temperature)
+	}
+	if len(options.Extra) != 2 || options.Extra["num_gpu"] != float64(1) {
+		t.Errorf("extra = %v", options.Extra)
+	}
+
+	data, _ := json.Marshal(options)
+	if string(data) != `{"mirostat":2,"num_gpu":1,"temperature":0.5}` {
+		t.Errorf("marshal = %s", data)
+	}
+	// 没有额外选项时与原有字段的输出一致
+	data, _ = json.Marshal(RequestOptions{NumCtx: 4096})
+	if string(data) != `{"num_ctx":4096}` {
+		t.Errorf("marshal = %s", data)
+	}
+}
+
+func TestApplyDefaultOptions(t *testing.T) {
+	temperature := 0.2
+	options := &RequestOptions{Temperature: &temperature, Extra: map[string]interface{}{"repeat_last_n": 32}}
+	merged := ApplyDefaultOptions(options, map[string]interface{}{
+		"temperature":   0.7,
+		"num_ctx":       8192,
+		"num_gpu":       1,
+		"repeat_last_n": 64,
+	})
+
+	data, _ := json.Marshal(merged)
+	// 请求中已设置的值优先，默认选项中没有对应字段的也会转发
+	if string(data) != `{"num_ctx":8192,"num_gpu":1,"repeat_last_n":32,"temperature":0.2}` {
+		t.Errorf("merged = %s", data)
+	}
+	if ApplyDefaultOptions(nil, nil) != nil {
+		t.Errorf("没有默认选项时应保持nil")
+	}
+}
+
+func TestOverrideOptions(t *testing.T) {
+	temperature := 0.9
+	merged := OverrideOptions(&RequestOptions{Temperature: &temperature, NumPredict: 10}, map[string]interface{}{
+		"temperature": 0,
+		"mirostat":    0,
+	})
+	data, _ := json.Marshal(merged)
+	if string(data) != `{"mirostat":0,"num_predict":10,"temperature":0}` {
+		t.Errorf("merged = %s", data)
+	}
+}
+
+func TestFinishReason(t *testing.T) {
+	tests := []struct {
+		doneReason string
+		toolCalls  bool
+		want       string
+	}{
+		{"stop", false, "stop"},
+		{"length", false, "length"},
+		{"length", true, "tool_calls"},
+		{"unload", false, "stop"},
+		{"", false, "stop"},
+	}
+	for _, tt := range tests {
+		resp := map[string]interface{}{"done": true}
+		if tt.doneReason != "" {
+			resp["done_reason"] = tt.doneReason
+		}
+		if got := FinishReason(resp, tt.toolCalls); got != tt.want {
+			t.Errorf("FinishReason(%q, %v) = %q, want %q", tt.doneReason, tt.toolCalls, got, tt.want)
+		}
+	}
+}
+
+func TestConvertOllamaChatToolCalls(t *testing.T) {
+	var resp map[string]interface{}
+	json.Unmarshal([]byte(`{"done":true,"done_reason":"stop","message":{"role":"assistant","content":"","tool_calls":[
+		{"function":{"name":"get_weather","arguments":{"city":"北京"}}},
+		{"function":{"name":"now"}}
+	]}}`), &resp)
+
+	choice := ConvertOllamaChatResponse(resp, "qwen2.5:7b").Choices[0]
+	if choice.FinishReason != "tool_calls" || len(choice.Message.ToolCalls) != 2 {
+		t.Fatalf("choice = %+v", choice)
+	}
+	call := choice.Message.ToolCalls[0]
+	// OpenAI格式的arguments为JSON字符串
+	var arguments string
+	if err := json.Unmarshal(call.Function.Arguments, &arguments); err != nil || arguments != `{"city":"北京"}` {
+		t.Errorf("arguments = %s", call.Function.Arguments)
+	}
+	if call.Type != "function" || call.ID == "" || call.Index != nil {
+		t.Errorf("call = %+v", call)
+	}
+	if string(choice.Message.ToolCalls[1].Function.Arguments) != `"{}"` {
+		t.Errorf("没有参数时arguments = %s, want \"{}\"", choice.Message.ToolCalls[1].Function.Arguments)
+	}
+
+	// 流式响应中的工具调用带有index
+	stream := ConvertOllamaChatStreamResponse(resp, "qwen2.5:7b").Choices[0]
+	if index := stream.Message.ToolCalls[1].Index; index == nil || *index != 1 {
+		t.Errorf("index = %v, want 1", index)
+	}
+}
+
+func TestToOllamaMessages(t *testing.T) {
+	messages := ToOllamaMessages([]ChatMessage{
+		{Role: "assistant", ReasoningContent: "hmm", ToolCalls: []ToolCall{
+			{ID: "call_1", Type: "function", Function: ToolCallFunction{Name: "get_weather", Arguments: json.RawMessage(`"{\"city\":\"北京\"}"`)}},
+			{Function: ToolCallFunction{Name: "echo", Arguments: json.RawMessage(`"not json"`)}},
+		}},
+		{Role: "tool", ToolCallID: "call_1", Content: "晴"},
+	})
+	if messages[0].ReasoningContent != "" {
+		t.Errorf("历史消息中的推理内容不应发送给模型")
+	}
+	// Ollama格式的arguments为JSON对象，无法解析的字符串保持原样
+	if got := string(messages[0].ToolCalls[0].Function.Arguments); got != `{"city":"北京"}` {
+		t.Errorf("arguments = %s", got)
+	}
+	if got := string(messages[0].ToolCalls[1].Function.Arguments); got != `"not json"` {
+		t.Errorf("arguments = %s", got)
+	}
+	if messages[1].ToolCallID != "call_1" {
+		t.Errorf("tool消息 = %+v", messages[1])
+	}
+}
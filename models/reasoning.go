@@ -0,0 +1,143 @@
+package models
+
+import (
+	"strings"
+)
+
+// 推理内容的处理模式
+const (
+	// ReasoningPassthrough 保持原样，<think>标签留在content中
+	ReasoningPassthrough = "passthrough"
+	// ReasoningExtract 将推理内容提取到reasoning_content
+	ReasoningExtract = "extract"
+	// ReasoningStrip 丢弃全部推理内容
+	ReasoningStrip = "strip"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// ThinkValue 根据请求中的think和reasoning_effort计算Ollama的think参数，未指定时返回nil
+// reasoning_effort为none时关闭思考，low/medium/high原样传给Ollama
+func ThinkValue(think *bool, effort string) interface{} {
+	if think != nil {
+		return *think
+	}
+	switch effort {
+	case "":
+		return nil
+	case "none":
+		return false
+	case "low", "medium", "high":
+		return effort
+	default:
+		return true
+	}
+}
+
+// ReasoningSplitter 从content中分离<think>推理内容，流式响应中标签可能被拆分到多个分块
+type ReasoningSplitter struct {
+	mode    string
+	inThink bool
+	// pending 可能是不完整标签的尾部内容，等待下一个分块
+	pending string
+	// trimLeading 推理结束后去掉正文开头的空白
+	trimLeading bool
+}
+
+// NewReasoningSplitter 创建推理内容分离器，passthrough模式下返回nil
+func NewReasoningSplitter(mode string) *ReasoningSplitter {
+	if mode != ReasoningExtract && mode != ReasoningStrip {
+		return nil
+	}
+	return &ReasoningSplitter{mode: mode}
+}
+
+// Process 按模式处理消息中的推理内容，流式响应中每个分块依次调用，done表示最后一个分块
+func (s *ReasoningSplitter) Process(message *ChatMessage, done bool) {
+	if s == nil {
+		return
+	}
+	content, reasoning := s.feed(message.Content)
+	if done {
+		restContent, restReasoning := s.flush()
+		content += restContent
+		reasoning += restReasoning
+	}
+
+	message.Content = content
+	if s.mode == ReasoningStrip {
+		message.ReasoningContent = ""
+		return
+	}
+	// Ollama的thinking字段与<think>标签不会同时出现，这里合并以防万一
+	message.ReasoningContent += reasoning
+}
+
+// feed 处理一段新内容，返回可以确定归属的正文和推理内容
+func (s *ReasoningSplitter) feed(text string) (string, string) {
+	var content, reasoning strings.Builder
+	text = s.pending + text
+	s.pending = ""
+
+	for text != "" {
+		tag := thinkOpenTag
+		if s.inThink {
+			tag = thinkCloseTag
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			s.emit(&content, &reasoning, text[:i])
+			text = text[i+len(tag):]
+			s.inThink = !s.inThink
+			s.trimLeading = !s.inThink
+			continue
+		}
+
+		// 结尾可能是被拆分的标签，保留到下一个分块再判断
+		keep := partialTagSuffix(text, tag)
+		s.emit(&content, &reasoning, text[:len(text)-keep])
+		s.pending = text[len(text)-keep:]
+		break
+	}
+	return content.String(), reasoning.String()
+}
+
+// flush 输出剩余内容，未闭合的<think>内容视为推理内容
+func (s *ReasoningSplitter) flush() (string, string) {
+	var content, reasoning strings.Builder
+	s.emit(&content, &reasoning, s.pending)
+	s.pending = ""
+	return content.String(), reasoning.String()
+}
+
+func (s *ReasoningSplitter) emit(content, reasoning *strings.Builder, text string) {
+	if s.inThink {
+		reasoning.WriteString(text)
+		return
+	}
+	if s.trimLeading {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return
+		}
+		s.trimLeading = false
+	}
+	content.WriteString(text)
+}
+
+// partialTagSuffix 返回text结尾与tag前缀相同的最大长度
+func partialTagSuffix(text, tag string) int {
+	n := len(tag) - 1
+	if n > len(text) {
+		n = len(text)
+	}
+	for ; n > 0; n-- {
+		if strings.HasPrefix(tag, text[len(text)-n:]) {
+			return n
+		}
+	}
+	return 0
+}
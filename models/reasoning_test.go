@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+)
+
+// splitChunks 依次处理流式分块，返回拼接后的正文和推理内容
+func splitChunks(mode string, chunks ...string) (string, string) {
+	splitter := NewReasoningSplitter(mode)
+	var content, reasoning string
+	for i, chunk := range chunks {
+		message := ChatMessage{Content: chunk}
+		splitter.Process(&message, i == len(chunks)-1)
+		content += message.Content
+		reasoning += message.ReasoningContent
+	}
+	return content, reasoning
+}
+
+func TestReasoningSplitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		chunks    []string
+		content   string
+		reasoning string
+	}{
+		{"完整标签", ReasoningExtract, []string{"<think>想一想</think>\n\n答案"}, "答案", "想一想"},
+		{"标签跨分块", ReasoningExtract, []string{"<thi", "nk>想", "一想</th", "ink>", "\n答案"}, "答案", "想一想"},
+		{"未闭合", ReasoningExtract, []string{"<think>还没想完"}, "", "还没想完"},
+		{"类似标签的正文", ReasoningExtract, []string{"a <b", "> c<", ""}, "a <b> c<", ""},
+		{"strip", ReasoningStrip, []string{"<think>想一想</think>", "答案"}, "答案", ""},
+		{"passthrough", ReasoningPassthrough, []string{"<think>想</think>答"}, "<think>想</think>答", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, reasoning := splitChunks(tt.mode, tt.chunks...)
+			if content != tt.content || reasoning != tt.reasoning {
+				t.Errorf("content = %q, reasoning = %q, want %q, %q", content, reasoning, tt.content, tt.reasoning)
+			}
+		})
+	}
+}
+
+func TestReasoningSplitterThinkingField(t *testing.T) {
+	// Ollama的thinking字段在extract模式下保留，strip模式下丢弃
+	message := ChatMessage{Content: "答案", ReasoningContent: "想一想"}
+	NewReasoningSplitter(ReasoningExtract).Process(&message, true)
+	if message.Content != "答案" || message.ReasoningContent != "想一想" {
+		t.Errorf("extract: %+v", message)
+	}
+	message = ChatMessage{Content: "答案", ReasoningContent: "想一想"}
+	NewReasoningSplitter(ReasoningStrip).Process(&message, true)
+	if message.Content != "答案" || message.ReasoningContent != "" {
+		t.Errorf("strip: %+v", message)
+	}
+}
+
+func TestThinkValue(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		think  *bool
+		effort string
+		want   interface{}
+	}{
+		{nil, "", nil},
+		{&disabled, "high", false},
+		{&enabled, "", true},
+		{nil, "none", false},
+		{nil, "medium", "medium"},
+		{nil, "minimal", true},
+	}
+	for _, tt := range tests {
+		if got := ThinkValue(tt.think, tt.effort); got != tt.want {
+			t.Errorf("ThinkValue(%v, %q) = %v, want %v", tt.think, tt.effort, got, tt.want)
+		}
+	}
+}
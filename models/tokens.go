@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"unicode"
+)
+
+// 估算消息token数时每条消息额外计入的token，对应聊天模板中的角色标记
+const messageOverheadTokens = 4
+
+// EstimateTokens 估算文本的token数
+// Ollama没有提供分词接口，这里按常见BPE分词器的平均情况估算：
+// 中日韩文字每个字约1个token，其余文本约4个字符1个token
+func EstimateTokens(text string) int {
+	tokens := 0
+	others := 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			tokens++
+			continue
+		}
+		others++
+	}
+	return tokens + (others+3)/4
+}
+
+// EstimateMessagesTokens 估算聊天消息及工具定义的token数
+func EstimateMessagesTokens(messages []ChatMessage, tools []Tool) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += EstimateMessageTokens(message)
+	}
+	if len(tools) > 0 {
+		data, _ := json.Marshal(tools)
+		tokens += EstimateTokens(string(data))
+	}
+	return tokens
+}
+
+// EstimateMessageTokens 估算单条消息的token数
+func EstimateMessageTokens(message ChatMessage) int {
+	tokens := messageOverheadTokens + EstimateTokens(message.Content)
+	for _, call := range message.ToolCalls {
+		tokens += EstimateTokens(call.Function.Name) + EstimateTokens(string(call.Function.Arguments))
+	}
+	return tokens
+}
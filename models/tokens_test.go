@@ -0,0 +1,28 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"你好", 2},
+		{"你好 world", 4},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+
+	// 每条消息额外计入角色标记
+	if got := EstimateMessagesTokens([]ChatMessage{{Content: "abcd"}, {Content: ""}}, nil); got != 9 {
+		t.Errorf("EstimateMessagesTokens = %d, want 9", got)
+	}
+}
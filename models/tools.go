@@ -0,0 +1,112 @@
+package models
+
+import (
+	"encoding/json"
+)
+
+// Tool 工具定义，OpenAI与Ollama格式相同
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolFunction 工具函数定义
+type ToolFunction struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+// ToolCall 工具调用
+type ToolCall struct {
+	// Index 仅在流式响应中使用
+	Index    *int             `json:"index,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+// ToolCallFunction 工具调用的函数及参数
+// OpenAI格式的Arguments为JSON字符串，Ollama格式为JSON对象
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToOllamaMessages 将OpenAI格式的消息转换为Ollama格式，主要是工具调用参数的格式
+func ToOllamaMessages(messages []ChatMessage) []ChatMessage {
+	result := make([]ChatMessage, len(messages))
+	for i, message := range messages {
+		result[i] = message
+		// 历史消息中的推理内容不需要再发给模型
+		result[i].ReasoningContent = ""
+		if len(message.ToolCalls) == 0 {
+			continue
+		}
+		calls := make([]ToolCall, len(message.ToolCalls))
+		for j, call := range message.ToolCalls {
+			calls[j] = ToolCall{
+				Function: ToolCallFunction{
+					Name:      call.Function.Name,
+					Arguments: argumentsObject(call.Function.Arguments),
+				},
+			}
+		}
+		result[i].ToolCalls = calls
+	}
+	return result
+}
+
+// convertOllamaToolCalls 将Ollama响应中的工具调用转换为OpenAI格式
+func convertOllamaToolCalls(message map[string]interface{}, stream bool) []ToolCall {
+	items, ok := message["tool_calls"].([]interface{})
+	if !ok || len(items) == 0 {
+		return nil
+	}
+
+	var calls []ToolCall
+	for i, item := range items {
+		call, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		function, _ := call["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+
+		// OpenAI要求arguments为JSON字符串
+		arguments, _ := json.Marshal(function["arguments"])
+		if function["arguments"] == nil {
+			arguments = []byte("{}")
+		}
+		quoted, _ := json.Marshal(string(arguments))
+
+		toolCall := ToolCall{
+			ID:   NewID(ToolCallIDPrefix),
+			Type: "function",
+			Function: ToolCallFunction{
+				Name:      name,
+				Arguments: quoted,
+			},
+		}
+		if stream {
+			index := i
+			toolCall.Index = &index
+		}
+		calls = append(calls, toolCall)
+	}
+	return calls
+}
+
+// argumentsObject 将OpenAI格式的字符串参数转换为JSON对象
+func argumentsObject(arguments json.RawMessage) json.RawMessage {
+	var text string
+	if err := json.Unmarshal(arguments, &text); err != nil {
+		// 已经是JSON对象
+		return arguments
+	}
+	if !json.Valid([]byte(text)) {
+		quoted, _ := json.Marshal(text)
+		return quoted
+	}
+	return json.RawMessage(text)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAIModelDetail(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, aliasConfig(fake.URL()))
+
+	model := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/v1/models/gpt-4o-mini", ""))
+	details, _ := model["details"].(map[string]interface{})
+	if model["id"] != "gpt-4o-mini" || model["root"] != "qwen2.5:7b" || model["created"] != float64(1717228800) || details["parameter_size"] != "7.6B" {
+		t.Errorf("model = %v", model)
+	}
+	if got := fake.received("/api/show")[0].Body["model"]; got != "qwen2.5:7b" {
+		t.Errorf("应使用别名对应的模型查询/api/show, got %v", got)
+	}
+
+	// 模型名称可以包含/
+	doRequest(t, proxy, http.MethodGet, "/v1/models/hf.co/org/model:Q4_K_M", "")
+	if got := fake.received("/api/show")[1].Body["model"]; got != "hf.co/org/model:Q4_K_M" {
+		t.Errorf("model = %v", got)
+	}
+}
+
+func TestOpenAIModelDetailNotFound(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/show", fakeReply{Status: http.StatusNotFound, Body: `{"error":"model 'missing' not found"}`})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	if got := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/v1/models/missing", ""))["error"]; got != "model 'missing' not found" {
+		t.Errorf("error = %v", got)
+	}
+}
+
+func TestResponseIDs(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+			`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+		ids[result["id"].(string)] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("每个响应应使用不同的id: %v", ids)
+	}
+
+	// 生成接口使用cmpl-前缀
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/completions", `{"model":"qwen2.5:7b","prompt":"hi"}`))
+	if id, _ := result["id"].(string); !strings.HasPrefix(id, "cmpl-") || result["object"] != "text_completion" {
+		t.Errorf("id = %v, object = %v", result["id"], result["object"])
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOllama 进程内的Ollama模拟服务
+// 未编排的请求返回默认响应：聊天和生成接口回显最后一条用户消息或prompt，流式请求按词拆分为多个分块
+type fakeOllama struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	scripts  map[string][]fakeReply
+	requests []fakeRequest
+	// models /api/tags返回的模型
+	models []string
+}
+
+// fakeReply 编排的一次响应
+type fakeReply struct {
+	// Status 状态码，默认为200
+	Status int
+	// Delay 返回响应头前的延迟
+	Delay time.Duration
+	// Header 额外的响应头
+	Header http.Header
+	// Body 非流式响应的内容
+	Body string
+	// Chunks 流式响应的分块，设置后忽略Body
+	Chunks []fakeChunk
+}
+
+// fakeChunk 流式响应中的一行
+type fakeChunk struct {
+	// Line 写入的内容，不包含换行符，可以是无法解析的内容
+	Line string
+	// Delay 写入前的延迟
+	Delay time.Duration
+	// Disconnect 在写入该行之前断开连接
+	Disconnect bool
+}
+
+// fakeRequest 模拟服务收到的请求
+type fakeRequest struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   map[string]interface{}
+}
+
+func newFakeOllama(t *testing.T) *fakeOllama {
+	t.Helper()
+	f := &fakeOllama{
+		scripts: map[string][]fakeReply{},
+		models:  []string{"qwen2.5:7b", "llama3.2:latest"},
+	}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL 模拟服务的地址
+func (f *fakeOllama) URL() string {
+	return f.server.URL
+}
+
+// script 为接口编排后续的响应，按顺序各使用一次，用完后返回默认响应
+func (f *fakeOllama) script(path string, replies ...fakeReply) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scripts[path] = append(f.scripts[path], replies...)
+}
+
+// received 返回接口收到的所有请求
+func (f *fakeOllama) received(path string) []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var result []fakeRequest
+	for _, req := range f.requests {
+		if req.Path == path {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeRequest{Method: r.Method, Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+	reply, scripted := fakeReply{}, false
+	if queue := f.scripts[r.URL.Path]; len(queue) > 0 {
+		reply, scripted = queue[0], true
+		f.scripts[r.URL.Path] = queue[1:]
+	}
+	f.mu.Unlock()
+
+	if !scripted {
+		reply = f.defaultReply(r.URL.Path, body)
+	}
+	f.write(w, reply)
+}
+
+// write 按编排写入响应
+func (f *fakeOllama) write(w http.ResponseWriter, reply fakeReply) {
+	time.Sleep(reply.Delay)
+	for name, values := range reply.Header {
+		w.Header()[name] = values
+	}
+	status := reply.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	if reply.Chunks == nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(reply.Body))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(status)
+	w.(http.Flusher).Flush()
+	for _, chunk := range reply.Chunks {
+		time.Sleep(chunk.Delay)
+		if chunk.Disconnect {
+			// 直接关闭连接，客户端读取到不完整的chunked响应
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		fmt.Fprintf(w, "%s\n", chunk.Line)
+		w.(http.Flusher).Flush()
+	}
+}
+
+// defaultReply 未编排时的默认响应，stream未指定时与Ollama一样按流式返回
+func (f *fakeOllama) defaultReply(path string, body map[string]interface{}) fakeReply {
+	stream := true
+	if value, ok := body["stream"].(bool); ok {
+		stream = value
+	}
+	model, _ := body["model"].(string)
+
+	switch path {
+	case "/api/chat":
+		messages, _ := body["messages"].([]interface{})
+		var content string
+		for _, message := range messages {
+			if m, ok := message.(map[string]interface{}); ok && m["role"] == "user" {
+				content, _ = m["content"].(string)
+			}
+		}
+		return echoReply(model, "echo: "+content, stream, chatLine)
+	case "/api/generate":
+		prompt, _ := body["prompt"].(string)
+		return echoReply(model, "echo: "+prompt, stream, generateLine)
+	case "/api/embed":
+		var inputs []interface{}
+		switch input := body["input"].(type) {
+		case string:
+			inputs = []interface{}{input}
+		case []interface{}:
+			inputs = input
+		}
+		embeddings := make([][]float64, len(inputs))
+		for i, input := range inputs {
+			text, _ := input.(string)
+			embeddings[i] = []float64{float64(len(text)), 0.5, float64(i)}
+		}
+		return jsonReply(map[string]interface{}{
+			"model":             model,
+			"embeddings":        embeddings,
+			"prompt_eval_count": len(inputs),
+		})
+	case "/api/tags":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		tags := make([]map[string]interface{}, len(f.models))
+		for i, name := range f.models {
+			tags[i] = map[string]interface{}{
+				"name":        name,
+				"model":       name,
+				"modified_at": "2024-06-01T08:00:00.000000000Z",
+				"size":        4683087332,
+				"digest":      fmt.Sprintf("digest-%s", name),
+				"details":     map[string]interface{}{"family": "qwen2", "parameter_size": "7.6B", "quantization_level": "Q4_K_M"},
+			}
+		}
+		return jsonReply(map[string]interface{}{"models": tags})
+	case "/api/show":
+		return jsonReply(map[string]interface{}{
+			"modified_at": "2024-06-01T08:00:00.000000000Z",
+			"details":     map[string]interface{}{"format": "gguf", "family": "qwen2", "parameter_size": "7.6B", "quantization_level": "Q4_K_M"},
+			"model_info":  map[string]interface{}{"general.architecture": "qwen2", "qwen2.context_length": 32768},
+		})
+	case "/api/version":
+		return jsonReply(map[string]interface{}{"version": "0.5.7"})
+	case "/api/ps":
+		return jsonReply(map[string]interface{}{"models": []map[string]interface{}{{
+			"name":       "qwen2.5:7b",
+			"model":      "qwen2.5:7b",
+			"size":       5963737088,
+			"size_vram":  5963737088,
+			"expires_at": "2024-06-01T08:05:00Z",
+		}}})
+	default:
+		return fakeReply{Status: http.StatusNotFound, Body: `{"error":"404 page not found"}`}
+	}
+}
+
+// echoReply 返回回显文本的响应，流式请求按词拆分为多个分块，最后是一个不带内容的结束分块
+func echoReply(model, text string, stream bool, line func(model, text string, done bool) string) fakeReply {
+	if !stream {
+		return fakeReply{Body: line(model, text, true)}
+	}
+	var chunks []fakeChunk
+	words := strings.SplitAfter(text, " ")
+	for _, word := range words {
+		chunks = append(chunks, fakeChunk{Line: line(model, word, false)})
+	}
+	chunks = append(chunks, fakeChunk{Line: line(model, "", true)})
+	return fakeReply{Chunks: chunks}
+}
+
+// chatLine 构造/api/chat的一行响应
+func chatLine(model, content string, done bool) string {
+	resp := map[string]interface{}{
+		"model":      model,
+		"created_at": "2024-06-01T08:00:00Z",
+		"message":    map[string]interface{}{"role": "assistant", "content": content},
+		"done":       done,
+	}
+	addDoneStats(resp, done)
+	return mustJSON(resp)
+}
+
+// generateLine 构造/api/generate的一行响应
+func generateLine(model, text string, done bool) string {
+	resp := map[string]interface{}{
+		"model":      model,
+		"created_at": "2024-06-01T08:00:00Z",
+		"response":   text,
+		"done":       done,
+	}
+	addDoneStats(resp, done)
+	return mustJSON(resp)
+}
+
+func addDoneStats(resp map[string]interface{}, done bool) {
+	if !done {
+		return
+	}
+	resp["done_reason"] = "stop"
+	resp["total_duration"] = 1200000000
+	resp["prompt_eval_count"] = 12
+	resp["eval_count"] = 5
+}
+
+func jsonReply(value interface{}) fakeReply {
+	return fakeReply{Body: mustJSON(value)}
+}
+
+func mustJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+const (
+	backendOllama = "ollama"
+	backendOpenAI = "openai"
+)
+
+// isOpenAIBackend 后端是否只支持OpenAI兼容接口
+func isOpenAIBackend(backend BackendConfig) bool {
+	return strings.EqualFold(backend.Type, backendOpenAI)
+}
+
+// servesModel 后端的models中是否包含该模型，没有tag的名称与:latest视为同一个模型
+func servesModel(backend BackendConfig, model string) bool {
+	for _, name := range backend.Models {
+		if name == model || withDefaultTag(name) == withDefaultTag(model) {
+			return true
+		}
+	}
+	return false
+}
+
+// routeBackends 按模型选择后端：列出了该模型的后端优先，其余模型发送到没有配置models的后端
+// 没有模型的请求（如/api/tags）同样发送到没有配置models的后端
+func routeBackends(config *Config, model string) ([]BackendConfig, error) {
+	backends := upstreamBackends(config)
+	var matched, fallback []BackendConfig
+	for _, backend := range backends {
+		switch {
+		case len(backend.Models) == 0:
+			fallback = append(fallback, backend)
+		case model != "" && servesModel(backend, model):
+			matched = append(matched, backend)
+		}
+	}
+	if len(matched) > 0 {
+		return matched, nil
+	}
+	if len(fallback) > 0 {
+		return fallback, nil
+	}
+	if model == "" {
+		return backends, nil
+	}
+	return nil, fmt.Errorf("没有提供模型 %s 的后端", model)
+}
+
+// requestModel 读取请求体中的模型名称，/api/show等接口也可以使用name字段
+func requestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	if req.Model != "" {
+		return req.Model
+	}
+	return req.Name
+}
+
+// sendTranslated 将Ollama接口的请求转换为OpenAI格式发送到OpenAI兼容的后端，并将响应转换回Ollama格式
+func sendTranslated(ctx context.Context, config *Config, backend BackendConfig, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	switch path {
+	case "/api/tags", "/api/show":
+		return sendTranslatedModels(ctx, config, backend, path, requestModel(body), header)
+	}
+	if path != "/api/chat" && path != "/api/generate" && path != "/api/embed" {
+		return nil, fmt.Errorf("OpenAI兼容后端 %s 不支持 %s", backend.Name, path)
+	}
+	var req map[string]interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("无法解析请求: %v", err)
+	}
+	model, _ := req["model"].(string)
+	start := time.Now()
+
+	if path == "/api/embed" {
+		resp, result, err := postTranslated(ctx, config, backend, models.OpenAIEmbeddingPath, models.OllamaEmbedToOpenAI(req), header)
+		if err != nil || result == nil {
+			return resp, err
+		}
+		return translatedResponse(resp, models.OpenAIEmbeddingToOllama(model, result, start))
+	}
+
+	builder := &models.OllamaResponseBuilder{Model: model, Start: start}
+	var target string
+	var openAIReq map[string]interface{}
+	if path == "/api/chat" {
+		target, openAIReq = models.OpenAIChatPath, models.OllamaChatToOpenAI(req)
+	} else {
+		target, openAIReq = models.OllamaGenerateToOpenAI(req)
+		builder.Generate = true
+	}
+	builder.PromptEstimate = models.EstimatePromptTokens(openAIReq)
+
+	if stream, _ := openAIReq["stream"].(bool); stream {
+		resp, err := sendJSON(ctx, config, backend, target, openAIReq, header)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return translatedError(resp, err)
+		}
+		resp.Body = &ollamaStreamBody{upstream: resp.Body, reader: bufio.NewReader(resp.Body), builder: builder}
+		resp.Header.Set("Content-Type", "application/x-ndjson")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		return resp, nil
+	}
+
+	resp, result, err := postTranslated(ctx, config, backend, target, openAIReq, header)
+	if err != nil || result == nil {
+		return resp, err
+	}
+	return translatedResponse(resp, builder.Response(result))
+}
+
+// sendTranslatedModels 使用/v1/models生成/api/tags的模型列表或/api/show的模型详情
+func sendTranslatedModels(ctx context.Context, config *Config, backend BackendConfig, path, model string, header http.Header) (*http.Response, error) {
+	resp, err := sendToBackend(ctx, config, backend, http.MethodGet, models.OpenAIModelsPath, nil, header)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return translatedError(resp, err)
+	}
+	result, err := decodeTranslated(backend, resp)
+	if err != nil {
+		return nil, err
+	}
+	if path == "/api/tags" {
+		return translatedResponse(resp, models.OpenAIModelsToOllamaTags(result))
+	}
+	if show := models.OpenAIModelToOllamaShow(model, result); show != nil {
+		return translatedResponse(resp, show)
+	}
+	resp.StatusCode, resp.Status = http.StatusNotFound, "404 Not Found"
+	return translatedResponse(resp, map[string]interface{}{"error": fmt.Sprintf("model '%s' not found", model)})
+}
+
+// sendJSON 将转换后的请求以JSON格式发送到后端
+func sendJSON(ctx context.Context, config *Config, backend BackendConfig, path string, value interface{}, header http.Header) (*http.Response, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	return sendToBackend(ctx, config, backend, http.MethodPost, path, data, header)
+}
+
+// postTranslated 发送转换后的非流式请求并解析响应，后端返回错误时result为nil，resp为转换后的错误响应
+func postTranslated(ctx context.Context, config *Config, backend BackendConfig, path string, value interface{}, header http.Header) (*http.Response, map[string]interface{}, error) {
+	resp, err := sendJSON(ctx, config, backend, path, value, header)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		resp, err = translatedError(resp, err)
+		return resp, nil, err
+	}
+	result, err := decodeTranslated(backend, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, result, nil
+}
+
+// decodeTranslated 解析后端的JSON响应，解析失败时关闭响应体
+func decodeTranslated(backend BackendConfig, resp *http.Response) (map[string]interface{}, error) {
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("无法解析后端 %s 的响应: %v", backend.Name, err)
+	}
+	return result, nil
+}
+
+// translatedError 将后端返回的错误响应转换为Ollama格式，状态码保持不变
+func translatedError(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	return translatedResponse(resp, openAIErrorBody(resp.Body))
+}
+
+// translatedResponse 使用转换后的JSON替换响应体
+func translatedResponse(resp *http.Response, value interface{}) (*http.Response, error) {
+	resp.Body.Close()
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.Header.Set("Content-Type", "application/json; charset=utf-8")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(data))
+	return resp, nil
+}
+
+// openAIErrorBody 将OpenAI格式的错误响应转换为Ollama格式
+func openAIErrorBody(body io.Reader) map[string]interface{} {
+	data, _ := io.ReadAll(body)
+	var resp map[string]interface{}
+	if json.Unmarshal(data, &resp) == nil {
+		switch e := resp["error"].(type) {
+		case map[string]interface{}:
+			if message, ok := e["message"].(string); ok {
+				return map[string]interface{}{"error": message}
+			}
+		case string:
+			return map[string]interface{}{"error": e}
+		}
+		if message, ok := resp["message"].(string); ok {
+			return map[string]interface{}{"error": message}
+		}
+	}
+	return map[string]interface{}{"error": strings.TrimSpace(string(data))}
+}
+
+// ollamaStreamBody 将OpenAI的SSE流转换为Ollama的NDJSON流
+type ollamaStreamBody struct {
+	upstream io.ReadCloser
+	reader   *bufio.Reader
+	builder  *models.OllamaResponseBuilder
+	pending  bytes.Buffer
+	finished bool
+}
+
+func (b *ollamaStreamBody) Read(p []byte) (int, error) {
+	for b.pending.Len() == 0 {
+		if b.finished {
+			return 0, io.EOF
+		}
+		line, err := b.reader.ReadString('\n')
+		if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+			b.event(strings.TrimSpace(data))
+		}
+		if err == io.EOF && !b.finished {
+			// 后端没有发送[DONE]就正常结束
+			b.finish()
+		} else if err != nil && err != io.EOF {
+			return 0, err
+		}
+	}
+	return b.pending.Read(p)
+}
+
+// event 处理一个SSE数据事件
+func (b *ollamaStreamBody) event(data string) {
+	if data == sseDone {
+		b.finish()
+		return
+	}
+	var chunk map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	if _, ok := chunk["error"]; ok {
+		b.write(openAIErrorBody(strings.NewReader(data)))
+		b.finished = true
+		return
+	}
+	if line := b.builder.Chunk(chunk); line != nil {
+		b.write(line)
+	}
+}
+
+func (b *ollamaStreamBody) finish() {
+	for _, line := range b.builder.Done() {
+		b.write(line)
+	}
+	b.finished = true
+}
+
+func (b *ollamaStreamBody) write(value interface{}) {
+	data, _ := json.Marshal(value)
+	b.pending.Write(data)
+	b.pending.WriteByte('\n')
+}
+
+func (b *ollamaStreamBody) Close() error {
+	return b.upstream.Close()
+}
+
+// allOpenAI 是否所有后端都是OpenAI兼容后端
+func allOpenAI(backends []BackendConfig) bool {
+	for _, backend := range backends {
+		if !isOpenAIBackend(backend) {
+			return false
+		}
+	}
+	return len(backends) > 0
+}
+
+// openAIBackendModels 返回OpenAI兼容后端配置的模型，用于/v1/models
+func openAIBackendModels(config *Config) []models.ModelData {
+	var data []models.ModelData
+	seen := make(map[string]bool)
+	for _, backend := range upstreamBackends(config) {
+		if !isOpenAIBackend(backend) {
+			continue
+		}
+		for _, name := range backend.Models {
+			if !seen[name] {
+				seen[name] = true
+				data = append(data, models.NewBackendModelData(name, backend.Name))
+			}
+		}
+	}
+	return data
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// openAIBackendConfig 返回Ollama后端加上提供gpt-oss:20b的OpenAI兼容后端的配置
+func openAIBackendConfig(ollama, openai string) string {
+	return fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  backends:
+    - name: ollama
+      base_url: %q
+    - name: vllm
+      type: openai
+      base_url: %q
+      models: ["gpt-oss:20b"]
+`, testToken, ollama, openai)
+}
+
+// sseChunk 构造OpenAI流式响应中的一个事件
+func sseChunk(value interface{}) []fakeChunk {
+	return []fakeChunk{{Line: "data: " + mustJSON(value)}, {Line: ""}}
+}
+
+func TestOpenAIBackendChat(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{
+			"finish_reason": "tool_calls",
+			"message": map[string]interface{}{
+				"role":    "assistant",
+				"content": "",
+				"tool_calls": []interface{}{map[string]interface{}{
+					"id":       "call_1",
+					"type":     "function",
+					"function": map[string]interface{}{"name": "get_weather", "arguments": `{"city":"北京"}`},
+				}},
+			},
+		}},
+		"usage": map[string]interface{}{"prompt_tokens": 12, "completion_tokens": 5},
+	}))
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat", `{"model":"gpt-oss:20b","stream":false,
+		"messages":[{"role":"user","content":"天气"}],
+		"options":{"temperature":0.2,"num_predict":64}}`)
+	result := decodeJSON(t, resp)
+	message, _ := result["message"].(map[string]interface{})
+	calls, _ := message["tool_calls"].([]interface{})
+	if len(calls) != 1 || result["done"] != true || result["eval_count"] != float64(5) || result["prompt_eval_count"] != float64(12) {
+		t.Fatalf("unexpected response: %v", result)
+	}
+	function := calls[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "get_weather" || function["arguments"].(map[string]interface{})["city"] != "北京" {
+		t.Errorf("tool call = %v", function)
+	}
+
+	received := vllm.received("/v1/chat/completions")
+	if len(received) != 1 {
+		t.Fatalf("OpenAI后端收到 %d 个请求, want 1", len(received))
+	}
+	body := received[0].Body
+	if body["model"] != "gpt-oss:20b" || body["stream"] != false || body["temperature"] != 0.2 || body["max_tokens"] != float64(64) {
+		t.Errorf("转换后的请求 = %v", body)
+	}
+	if len(ollama.received("/api/chat")) != 0 {
+		t.Errorf("gpt-oss:20b的请求不应发送到Ollama")
+	}
+}
+
+func TestOpenAIBackendChatStream(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	var chunks []fakeChunk
+	for _, text := range []string{"你", "好"} {
+		chunks = append(chunks, sseChunk(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{"content": text}}},
+		})...)
+	}
+	chunks = append(chunks, sseChunk(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{}, "finish_reason": "length"}},
+	})...)
+	chunks = append(chunks, sseChunk(map[string]interface{}{
+		"choices": []interface{}{},
+		"usage":   map[string]interface{}{"prompt_tokens": 3, "completion_tokens": 2},
+	})...)
+	chunks = append(chunks, fakeChunk{Line: "data: [DONE]"})
+	vllm.script("/v1/chat/completions", fakeReply{Header: http.Header{"Content-Type": {"text/event-stream"}}, Chunks: chunks})
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"gpt-oss:20b","messages":[{"role":"user","content":"hi"}]}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("无法解析的行: %s", scanner.Text())
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("收到 %d 行, want 3: %v", len(lines), lines)
+	}
+	last := lines[2]
+	if last["done"] != true || last["done_reason"] != "length" || last["eval_count"] != float64(2) {
+		t.Errorf("结束分块 = %v", last)
+	}
+
+	body := vllm.received("/v1/chat/completions")[0].Body
+	options, _ := body["stream_options"].(map[string]interface{})
+	if body["stream"] != true || options["include_usage"] != true {
+		t.Errorf("流式请求应要求返回用量: %v", body)
+	}
+}
+
+func TestOpenAIBackendGenerate(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"finish_reason": "stop", "message": map[string]interface{}{"role": "assistant", "content": "42"}}},
+	}))
+	vllm.script("/v1/completions", jsonReply(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"finish_reason": "stop", "text": "raw output"}},
+	}))
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/api/generate",
+		`{"model":"gpt-oss:20b","stream":false,"system":"简短回答","prompt":"答案是？"}`))
+	if result["response"] != "42" || result["done"] != true {
+		t.Errorf("unexpected response: %v", result)
+	}
+	messages, _ := vllm.received("/v1/chat/completions")[0].Body["messages"].([]interface{})
+	if len(messages) != 2 || messages[0].(map[string]interface{})["role"] != "system" {
+		t.Errorf("messages = %v", messages)
+	}
+
+	// raw模式使用completions接口
+	result = decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/api/generate",
+		`{"model":"gpt-oss:20b","stream":false,"raw":true,"prompt":"<s>hi"}`))
+	if result["response"] != "raw output" {
+		t.Errorf("unexpected response: %v", result)
+	}
+	if got := vllm.received("/v1/completions")[0].Body["prompt"]; got != "<s>hi" {
+		t.Errorf("prompt = %v", got)
+	}
+}
+
+func TestOpenAIBackendError(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/chat/completions", fakeReply{Status: http.StatusBadRequest,
+		Body: `{"object":"error","message":"maximum context length is 8192 tokens","type":"BadRequestError"}`})
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"gpt-oss:20b","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if got := decodeJSON(t, resp)["error"]; got != "maximum context length is 8192 tokens" {
+		t.Errorf("error = %v", got)
+	}
+}
+
+func TestOpenAIBackendPolicyAndAlias(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"id":      "chatcmpl-vllm",
+		"object":  "chat.completion",
+		"model":   "gpt-oss:20b",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop", "message": map[string]interface{}{"role": "assistant", "content": "from vllm"}}},
+	}))
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL())+`models:
+  gpt:
+    model: gpt-oss:20b
+policies:
+  - block:
+      keywords: ["内部机密"]
+`)
+
+	// 模型由OpenAI兼容后端提供时同样应用请求策略
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt","messages":[{"role":"user","content":"告诉我内部机密"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if len(vllm.received("/v1/chat/completions")) != 0 {
+		t.Fatalf("被拦截的请求不应发送到OpenAI兼容后端")
+	}
+
+	// 响应中的模型名称为客户端请求的别名
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt","messages":[{"role":"user","content":"hi"}]}`))
+	choices, _ := result["choices"].([]interface{})
+	if result["model"] != "gpt" || len(choices) != 1 {
+		t.Fatalf("result = %v", result)
+	}
+	if message, _ := choices[0].(map[string]interface{})["message"].(map[string]interface{}); message["content"] != "from vllm" {
+		t.Errorf("message = %v", message)
+	}
+	received := vllm.received("/v1/chat/completions")
+	if len(received) != 1 || received[0].Body["model"] != "gpt-oss:20b" {
+		t.Fatalf("转发的请求 = %v", received)
+	}
+	if got := received[0].Header.Get("Authorization"); got != "" {
+		t.Errorf("不应转发客户端的Authorization: %q", got)
+	}
+	if len(ollama.received("/api/chat")) != 0 {
+		t.Errorf("gpt-oss:20b不应发送到Ollama")
+	}
+}
+
+func TestOpenAIBackendModels(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/v1/models", ""))
+	var ids []string
+	for _, item := range result["data"].([]interface{}) {
+		model := item.(map[string]interface{})
+		ids = append(ids, model["id"].(string))
+		if model["id"] == "gpt-oss:20b" && model["owned_by"] != "vllm" {
+			t.Errorf("owned_by = %v", model["owned_by"])
+		}
+	}
+	if fmt.Sprint(ids) != "[qwen2.5:7b llama3.2:latest gpt-oss:20b]" {
+		t.Errorf("models = %v", ids)
+	}
+
+	result = decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/v1/models/gpt-oss:20b", ""))
+	if result["id"] != "gpt-oss:20b" || len(ollama.received("/api/show")) != 0 {
+		t.Errorf("unexpected response: %v", result)
+	}
+}
+
+func TestRouteBackends(t *testing.T) {
+	config := &Config{}
+	config.Service.Backends = []BackendConfig{
+		{Name: "a", BaseURL: "http://a", Models: []string{"llama3.2"}},
+		{Name: "b", BaseURL: "http://b", Models: []string{"qwen2.5:7b"}},
+	}
+
+	backends, err := routeBackends(config, "llama3.2:latest")
+	if err != nil || len(backends) != 1 || backends[0].Name != "a" {
+		t.Errorf("llama3.2:latest -> %v, %v", backends, err)
+	}
+	if _, err := routeBackends(config, "mistral"); err == nil {
+		t.Errorf("没有后端提供的模型应返回错误")
+	}
+	if backends, _ := routeBackends(config, ""); len(backends) != 2 {
+		t.Errorf("没有模型的请求应发送到所有后端: %v", backends)
+	}
+}
+
+// openAIOnlyConfig 返回只有一个OpenAI兼容后端的配置
+func openAIOnlyConfig(openai string) string {
+	return fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  backends:
+    - name: vllm
+      type: openai
+      base_url: %q
+`, testToken, openai)
+}
+
+func TestOpenAIOnlyBackendTagsAndShow(t *testing.T) {
+	vllm := newFakeOllama(t)
+	models := jsonReply(map[string]interface{}{
+		"object": "list",
+		"data": []interface{}{
+			map[string]interface{}{"id": "Qwen/Qwen2.5-7B-Instruct", "object": "model", "created": 1735689600, "max_model_len": 32768},
+		},
+	})
+	vllm.script("/v1/models", models, models, models)
+	proxy := newTestProxy(t, openAIOnlyConfig(vllm.URL()))
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/api/tags", ""))
+	tags, _ := result["models"].([]interface{})
+	if len(tags) != 1 {
+		t.Fatalf("tags = %v", result)
+	}
+	tag := tags[0].(map[string]interface{})
+	if tag["name"] != "Qwen/Qwen2.5-7B-Instruct" || tag["modified_at"] != "2025-01-01T00:00:00Z" {
+		t.Errorf("tag = %v", tag)
+	}
+
+	result = decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/api/show", `{"model":"Qwen/Qwen2.5-7B-Instruct"}`))
+	info, _ := result["model_info"].(map[string]interface{})
+	if info["openai.context_length"] != float64(32768) {
+		t.Errorf("show = %v", result)
+	}
+
+	resp := doRequest(t, proxy, http.MethodGet, "/api/show", `{"model":"missing"}`)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestOpenAIOnlyBackendEmbed(t *testing.T) {
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/embeddings", jsonReply(map[string]interface{}{
+		"data": []interface{}{
+			map[string]interface{}{"index": 1, "embedding": []float64{0.3, 0.4}},
+			map[string]interface{}{"index": 0, "embedding": []float64{0.1, 0.2}},
+		},
+		"usage": map[string]interface{}{"prompt_tokens": 6},
+	}))
+	proxy := newTestProxy(t, openAIOnlyConfig(vllm.URL()))
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/api/embed",
+		`{"model":"bge-m3","input":["a","b"],"dimensions":2}`))
+	if fmt.Sprint(result["embeddings"]) != "[[0.1 0.2] [0.3 0.4]]" || result["prompt_eval_count"] != float64(6) {
+		t.Errorf("unexpected response: %v", result)
+	}
+	body := vllm.received("/v1/embeddings")[0].Body
+	if fmt.Sprint(body["input"]) != "[a b]" || body["dimensions"] != float64(2) {
+		t.Errorf("转换后的请求 = %v", body)
+	}
+}
+
+func TestOpenAIOnlyBackendEstimatesUsage(t *testing.T) {
+	vllm := newFakeOllama(t)
+	var chunks []fakeChunk
+	for _, text := range []string{"Hello", ",", " world"} {
+		chunks = append(chunks, sseChunk(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"delta": map[string]interface{}{"content": text}}},
+		})...)
+	}
+	chunks = append(chunks, fakeChunk{Line: "data: [DONE]"})
+	vllm.script("/v1/completions", fakeReply{Chunks: chunks})
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"message": map[string]interface{}{"role": "assistant", "content": "你好世界"}}},
+	}))
+	proxy := newTestProxy(t, openAIOnlyConfig(vllm.URL()))
+
+	// 后端没有返回用量时，流式响应按分块数计算eval_count
+	resp := doRequest(t, proxy, http.MethodPost, "/api/generate", `{"model":"llama","raw":true,"prompt":"Say hello world"}`)
+	var last map[string]interface{}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		last = nil
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("无法解析的行: %s", scanner.Text())
+		}
+	}
+	if last["done"] != true || last["eval_count"] != float64(3) || last["prompt_eval_count"] != float64(4) {
+		t.Errorf("结束分块 = %v", last)
+	}
+	for _, name := range []string{"total_duration", "load_duration", "prompt_eval_duration", "eval_duration"} {
+		if _, ok := last[name].(float64); !ok {
+			t.Errorf("缺少 %s: %v", name, last)
+		}
+	}
+
+	// 非流式响应按输出内容估算
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"llama","stream":false,"messages":[{"role":"user","content":"hi"}]}`))
+	if result["eval_count"] != float64(4) || result["prompt_eval_count"] != float64(5) {
+		t.Errorf("unexpected response: %v", result)
+	}
+}
+
+func TestOpenAIBackendPassthroughParams(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/completions", jsonReply(map[string]interface{}{
+		"object":  "text_completion",
+		"model":   "gpt-oss:20b",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop", "text": "  return 1"}},
+	}))
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"object":  "chat.completion",
+		"model":   "gpt-oss:20b",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop", "message": map[string]interface{}{"role": "assistant", "content": `{"ok":true}`}}},
+	}))
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	// 补全请求发送到后端的/v1/completions，不转换为聊天请求
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/completions",
+		`{"model":"gpt-oss:20b","prompt":"def f(","presence_penalty":0.5,"logit_bias":{"50256":-100}}`))
+	choices, _ := result["choices"].([]interface{})
+	if len(choices) != 1 || choices[0].(map[string]interface{})["text"] != "  return 1" {
+		t.Fatalf("result = %v", result)
+	}
+	received := vllm.received("/v1/completions")
+	if len(received) != 1 || len(vllm.received("/v1/chat/completions")) != 0 {
+		t.Fatalf("补全请求应只发送到/v1/completions")
+	}
+	body := received[0].Body
+	if body["prompt"] != "def f(" || body["presence_penalty"] != 0.5 || fmt.Sprint(body["logit_bias"]) != "map[50256:-100]" {
+		t.Errorf("转发的请求 = %v", body)
+	}
+
+	// Ollama不支持的聊天参数原样转发
+	decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/v1/chat/completions", `{"model":"gpt-oss:20b",
+		"messages":[{"role":"user","content":"北京天气"}],"tools":[`+weatherTool+`],"tool_choice":"required",
+		"frequency_penalty":0.3,"response_format":{"type":"json_object"}}`))
+	body = vllm.received("/v1/chat/completions")[0].Body
+	format, _ := body["response_format"].(map[string]interface{})
+	if body["tool_choice"] != "required" || body["frequency_penalty"] != 0.3 || format["type"] != "json_object" {
+		t.Errorf("转发的请求 = %v", body)
+	}
+	if len(ollama.received("/api/chat"))+len(ollama.received("/api/generate")) != 0 {
+		t.Errorf("gpt-oss:20b不应发送到Ollama")
+	}
+}
+
+func TestOpenAIBackendPassthroughRedactStream(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	var chunks []fakeChunk
+	for _, text := range []string{"联系 ali", "ce@example.com 获取"} {
+		chunks = append(chunks, sseChunk(map[string]interface{}{
+			"model":   "gpt-oss:20b",
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"content": text}, "logprobs": nil}},
+		})...)
+	}
+	chunks = append(chunks, sseChunk(map[string]interface{}{
+		"model":   "gpt-oss:20b",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": "stop"}},
+	})...)
+	chunks = append(chunks, fakeChunk{Line: "data: [DONE]"})
+	vllm.script("/v1/chat/completions", fakeReply{Header: http.Header{"Content-Type": {"text/event-stream"}}, Chunks: chunks})
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL())+`models:
+  gpt:
+    model: gpt-oss:20b
+policies:
+  - redact:
+      builtin: [email]
+`)
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt","stream":true,"messages":[{"role":"user","content":"我的邮箱是 bob@example.com"}]}`)
+	events := readSSE(t, resp.Body)
+	var content string
+	for _, chunk := range chatChunks(t, events) {
+		if chunk.Model != "gpt" {
+			t.Errorf("model = %q, want gpt", chunk.Model)
+		}
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	if content != "联系 [REDACTED] 获取" {
+		t.Errorf("content = %q, 跨分块的邮箱应被脱敏", content)
+	}
+	for _, event := range events {
+		if strings.Contains(event.Data, "logprobs") {
+			t.Errorf("脱敏时应丢弃logprobs: %s", event.Data)
+		}
+	}
+
+	messages, _ := vllm.received("/v1/chat/completions")[0].Body["messages"].([]interface{})
+	if len(messages) != 1 || messages[0].(map[string]interface{})["content"] != "我的邮箱是 [REDACTED]" {
+		t.Errorf("转发的消息 = %v", messages)
+	}
+}
+
+func TestOpenAIBackendPassthroughPostHookStream(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+		"id":      "chatcmpl-vllm",
+		"object":  "chat.completion",
+		"model":   "gpt-oss:20b",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop", "message": map[string]interface{}{"role": "assistant", "content": "from vllm"}}},
+	}))
+	hook := newFakeHook(t, nil)
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL())+
+		fmt.Sprintf("hooks:\n  - name: moderation\n    type: webhook\n    url: %q\n    stage: both\n", hook.URL))
+
+	// 响应后钩子需要完整响应，后端收到非流式请求，客户端仍收到流式响应
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"gpt-oss:20b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	chunks := chatChunks(t, readSSE(t, resp.Body))
+	if len(chunks) != 1 || chunks[0].Object != "chat.completion.chunk" || chunks[0].Choices[0].Delta.Content != "from vllm" {
+		t.Errorf("chunks = %+v", chunks)
+	}
+	if body := vllm.received("/v1/chat/completions")[0].Body; body["stream"] != false {
+		t.Errorf("转发的请求 = %v", body)
+	}
+
+	// 钩子收到OpenAI格式的请求和响应
+	pre, post := hook.received("pre"), hook.received("post")
+	if len(pre) != 1 || len(post) != 1 {
+		t.Fatalf("钩子收到 %d 个pre请求和 %d 个post请求, want 1", len(pre), len(post))
+	}
+	if request, _ := pre[0]["request"].(map[string]interface{}); request["messages"] == nil {
+		t.Errorf("request = %v", request)
+	}
+	if response, _ := post[0]["response"].(map[string]interface{}); response["object"] != "chat.completion" {
+		t.Errorf("response = %v", response)
+	}
+}
+
+func TestOpenAIBackendPassthroughSession(t *testing.T) {
+	ollama := newFakeOllama(t)
+	vllm := newFakeOllama(t)
+	for _, content := range []string{"first", "second"} {
+		vllm.script("/v1/chat/completions", jsonReply(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": "stop", "message": map[string]interface{}{"role": "assistant", "content": content}}},
+		}))
+	}
+	withSessions(t, SessionConfig{})
+	proxy := newTestProxy(t, openAIBackendConfig(ollama.URL(), vllm.URL()))
+
+	for _, content := range []string{"hi", "again"} {
+		req, err := http.NewRequest(http.MethodPost, proxy.URL+"/v1/chat/completions", strings.NewReader(fmt.Sprintf(
+			`{"model":"gpt-oss:20b","messages":[{"role":"user","content":%q}]}`, content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set(sessionHeader, "s1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.Header.Get(sessionHeader) != "s1" {
+			t.Errorf("%s = %q", sessionHeader, resp.Header.Get(sessionHeader))
+		}
+	}
+
+	received := vllm.received("/v1/chat/completions")
+	messages, _ := received[len(received)-1].Body["messages"].([]interface{})
+	var contents []string
+	for _, message := range messages {
+		contents = append(contents, fmt.Sprint(message.(map[string]interface{})["content"]))
+	}
+	if strings.Join(contents, "|") != "hi|first|again" {
+		t.Errorf("转发的消息 = %q", contents)
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/douguohai/ollama-proxy/models"
+	"github.com/gin-gonic/gin"
+)
+
+// openAIPassthrough 模型只由OpenAI兼容后端提供时，将/v1请求以OpenAI格式直接转发给后端。
+// 请求不转换为Ollama格式，presence_penalty、response_format、logit_bias等参数都由后端处理。
+// 转发前解析模型别名、应用请求策略和外部钩子，聊天请求同样使用服务端会话；
+// 响应经过脱敏，模型名称替换为客户端请求的名称。OpenAI兼容后端没有模型digest，不使用响应缓存
+func openAIPassthrough(path string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config, err := loadConfig()
+		if err != nil {
+			c.Next()
+			return
+		}
+		data, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		if err != nil {
+			c.Next()
+			return
+		}
+		value, _ := decodeJSONValue(data)
+		body, ok := value.(map[string]interface{})
+		if !ok {
+			c.Next()
+			return
+		}
+		name, _ := body["model"].(string)
+		model, settings := resolveModel(config, name)
+		backends, err := routeBackends(config, model)
+		if err != nil || !allOpenAI(backends) {
+			c.Next()
+			return
+		}
+		c.Abort()
+		body["model"] = model
+
+		// 启用会话时，客户端只发送新消息，由代理补充会话的历史消息
+		var owner, session string
+		var newMessages []interface{}
+		if path == models.OpenAIChatPath {
+			user, _ := body["user"].(string)
+			owner, session = sessionOwner(c), sessionID(c, user)
+			if session != "" {
+				c.Header(sessionHeader, session)
+			}
+			newMessages, _ = body["messages"].([]interface{})
+			messages := append(jsonMessages(sessions.history(owner, session)), newMessages...)
+			body["messages"] = withDefaultSystemMessage(messages, settings.System)
+		}
+
+		// 应用调用方和模型对应的策略以及外部策略钩子，Embedding请求与转换为Ollama接口时一样不应用
+		var policies *policyPipeline
+		generative := path != models.OpenAIEmbeddingPath
+		if generative {
+			policies, err = newPolicyPipeline(config, c, name, model)
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"error": err.Error(),
+				})
+				return
+			}
+			if err := policies.applyOpenAI(path, body); err != nil {
+				openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
+				return
+			}
+			if err := runPreHooks(config, c, name, &body); err != nil {
+				openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
+				return
+			}
+		}
+
+		// 响应后钩子需要完整的响应，向后端发送非流式请求，检查通过后再按流式格式返回
+		stream, _ := body["stream"].(bool)
+		includeUsage := false
+		if options, ok := body["stream_options"].(map[string]interface{}); ok {
+			includeUsage, _ = options["include_usage"].(bool)
+		}
+		buffered := stream && generative && hasPostHooks(config, name)
+		if buffered {
+			body["stream"] = false
+			delete(body, "stream_options")
+		}
+
+		data, _ = json.Marshal(body)
+		header := upstreamRequestHeader(config.Service.Headers, c.Request.Header)
+		header.Set("Content-Type", "application/json")
+		resp, err := doBackends(c.Request.Context(), config, backends, http.MethodPost, path, data, header)
+		if err != nil {
+			openAIError(c, http.StatusBadGateway, "api_error", "", "upstream_error", err.Error())
+			return
+		}
+		defer resp.Body.Close()
+		copyResponseHeader(c, config.Service.Headers, resp.Header)
+
+		// 后端返回的错误原样返回
+		if resp.StatusCode != http.StatusOK {
+			c.Status(resp.StatusCode)
+			io.Copy(c.Writer, resp.Body)
+			return
+		}
+
+		if stream && !buffered {
+			reply := &streamReply{}
+			var streamErr error
+			c.Stream(func(w io.Writer) bool {
+				streamErr = rewriteSSE(w, resp.Body, c.Writer.Flush, name, policies, reply)
+				return false
+			})
+			if streamErr == nil && reply.done {
+				saveSession(owner, session, name, newMessages, reply.message())
+			}
+			return
+		}
+
+		raw, err := io.ReadAll(resp.Body)
+		if err != nil {
+			openAIError(c, http.StatusBadGateway, "api_error", "", "upstream_error", err.Error())
+			return
+		}
+		value, _ = decodeJSONValue(raw)
+		result, ok := value.(map[string]interface{})
+		if !ok {
+			c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), raw)
+			return
+		}
+		result["model"] = name
+		choices, _ := result["choices"].([]interface{})
+		for _, choice := range choices {
+			if choice, ok := choice.(map[string]interface{}); ok {
+				policies.redactOpenAIChoice(choice)
+			}
+		}
+		if generative {
+			if err := runPostHooks(config, c, name, body, &result); err != nil {
+				openAIError(c, http.StatusBadRequest, "invalid_request_error", "", "content_policy_violation", err.Error())
+				return
+			}
+		}
+
+		// 保存新消息和第一个结果的回复到会话
+		if choices, _ := result["choices"].([]interface{}); len(choices) > 0 {
+			if choice, ok := choices[0].(map[string]interface{}); ok && choice["message"] != nil {
+				saveSession(owner, session, name, newMessages, choice["message"])
+			}
+		}
+
+		if buffered {
+			writeResponseSSE(c, path, result, includeUsage)
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// jsonMessages 将会话中的消息转换为JSON值，用于直接转发的请求
+func jsonMessages(messages []models.ChatMessage) []interface{} {
+	if len(messages) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(messages)
+	var values []interface{}
+	json.Unmarshal(data, &values)
+	return values
+}
+
+// withDefaultSystemMessage 与withDefaultSystem相同，用于直接转发的消息
+func withDefaultSystemMessage(messages []interface{}, system string) []interface{} {
+	if system == "" {
+		return messages
+	}
+	for _, message := range messages {
+		if message, ok := message.(map[string]interface{}); ok && message["role"] == "system" {
+			return messages
+		}
+	}
+	return append([]interface{}{map[string]interface{}{"role": "system", "content": system}}, messages...)
+}
+
+// saveSession 保存本次的新消息和回复到会话，消息无法转换为会话格式（例如多模态消息）时不保存
+func saveSession(owner, session, model string, messages []interface{}, reply interface{}) {
+	if sessions == nil || session == "" {
+		return
+	}
+	data, _ := json.Marshal(append(append([]interface{}{}, messages...), reply))
+	var history []models.ChatMessage
+	if json.Unmarshal(data, &history) != nil || len(history) == 0 {
+		return
+	}
+	history[len(history)-1].ReasoningContent = ""
+	sessions.appendMessages(owner, session, model, history...)
+}
+
+// rewriteSSE 逐个事件处理OpenAI兼容后端的流式响应：替换模型名称、对输出内容脱敏，
+// 每写出一行调用一次flush，同时拼接第一个结果的回复用于保存会话
+func rewriteSSE(w io.Writer, r io.Reader, flush func(), model string, policies *policyPipeline, reply *streamReply) error {
+	// 每个结果的每个字段使用独立的滑动窗口
+	redacts := policies.redactsCompletion()
+	streams := map[string]*streamRedactor{}
+	redactField := func(name string, fields map[string]interface{}, key string, done bool) {
+		if !redacts {
+			return
+		}
+		stream := streams[name]
+		if stream == nil {
+			stream = policies.completionStream()
+			streams[name] = stream
+		}
+		text, ok := fields[key].(string)
+		if out := stream.feed(text, done); ok || out != "" {
+			fields[key] = out
+		}
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if payload, ok := bytes.CutPrefix(bytes.TrimRight(line, "\r\n"), []byte("data:")); ok {
+			value, _ := decodeJSONValue(bytes.TrimSpace(payload))
+			if event, ok := value.(map[string]interface{}); ok {
+				if _, ok := event["model"]; ok {
+					event["model"] = model
+				}
+				choices, _ := event["choices"].([]interface{})
+				for _, choice := range choices {
+					choice, ok := choice.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					index := fmt.Sprint(choice["index"])
+					done := choice["finish_reason"] != nil
+					if redacts {
+						delete(choice, "logprobs")
+					}
+					redactField(index+".text", choice, "text", done)
+					delta, _ := choice["delta"].(map[string]interface{})
+					if delta != nil {
+						redactField(index+".content", delta, "content", done)
+						redactField(index+".reasoning_content", delta, "reasoning_content", done)
+						redactToolCallDeltas(delta, index, done, redactField)
+					}
+					if index == "0" {
+						reply.add(delta, done)
+					}
+				}
+				data, _ := json.Marshal(event)
+				line = append(append([]byte("data: "), data...), '\n')
+			}
+		}
+		if len(line) > 0 {
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+			flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// redactToolCallDeltas 对流式响应中工具调用参数的片段脱敏，每个工具调用使用独立的滑动窗口
+func redactToolCallDeltas(delta map[string]interface{}, index string, done bool, redactField func(string, map[string]interface{}, string, bool)) {
+	calls, _ := delta["tool_calls"].([]interface{})
+	for _, call := range calls {
+		call, _ := call.(map[string]interface{})
+		if function, ok := call["function"].(map[string]interface{}); ok {
+			redactField(fmt.Sprintf("%s.tool.%v", index, call["index"]), function, "arguments", done)
+		}
+	}
+}
+
+// streamReply 从流式响应中拼接出的完整回复
+type streamReply struct {
+	content   strings.Builder
+	toolCalls []map[string]interface{}
+	// done 是否收到了finish_reason
+	done bool
+}
+
+// add 追加一个分块的delta
+func (r *streamReply) add(delta map[string]interface{}, done bool) {
+	r.done = r.done || done
+	if text, ok := delta["content"].(string); ok {
+		r.content.WriteString(text)
+	}
+	calls, _ := delta["tool_calls"].([]interface{})
+	for _, call := range calls {
+		call, _ := call.(map[string]interface{})
+		index, err := strconv.Atoi(fmt.Sprint(call["index"]))
+		if err != nil || index < 0 || index > len(r.toolCalls) {
+			continue
+		}
+		if index == len(r.toolCalls) {
+			r.toolCalls = append(r.toolCalls, map[string]interface{}{
+				"type":     "function",
+				"function": map[string]interface{}{"name": "", "arguments": ""},
+			})
+		}
+		target := r.toolCalls[index]
+		if id, ok := call["id"].(string); ok {
+			target["id"] = id
+		}
+		function, _ := call["function"].(map[string]interface{})
+		targetFunction := target["function"].(map[string]interface{})
+		for _, key := range []string{"name", "arguments"} {
+			if text, ok := function[key].(string); ok {
+				targetFunction[key] = targetFunction[key].(string) + text
+			}
+		}
+	}
+}
+
+// message 返回OpenAI格式的助手消息
+func (r *streamReply) message() map[string]interface{} {
+	message := map[string]interface{}{"role": "assistant", "content": r.content.String()}
+	if len(r.toolCalls) > 0 {
+		calls := make([]interface{}, len(r.toolCalls))
+		for i, call := range r.toolCalls {
+			calls[i] = call
+		}
+		message["tool_calls"] = calls
+	}
+	return message
+}
+
+// writeResponseSSE 将完整的响应按流式格式返回，每个结果作为一个分块
+func writeResponseSSE(c *gin.Context, path string, result map[string]interface{}, includeUsage bool) {
+	object := "text_completion"
+	if path == models.OpenAIChatPath {
+		object = "chat.completion.chunk"
+	}
+	chunk := func(choices []interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      result["id"],
+			"object":  object,
+			"created": result["created"],
+			"model":   result["model"],
+			"choices": choices,
+		}
+	}
+
+	var events []map[string]interface{}
+	choices, _ := result["choices"].([]interface{})
+	for _, choice := range choices {
+		choice, ok := choice.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if message, ok := choice["message"].(map[string]interface{}); ok {
+			// 流式响应中的工具调用需要带上index
+			calls, _ := message["tool_calls"].([]interface{})
+			for i, call := range calls {
+				if call, ok := call.(map[string]interface{}); ok {
+					call["index"] = i
+				}
+			}
+			choice["delta"] = message
+			delete(choice, "message")
+		}
+		events = append(events, chunk([]interface{}{choice}))
+	}
+	if usage, ok := result["usage"]; ok && includeUsage {
+		event := chunk([]interface{}{})
+		event["usage"] = usage
+		events = append(events, event)
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Stream(func(w io.Writer) bool {
+		for _, event := range events {
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		return false
+	})
+}
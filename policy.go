@@ -0,0 +1,677 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/douguohai/ollama-proxy/models"
+	"github.com/gin-gonic/gin"
+)
+
+// PolicyConfig 请求策略，所有匹配的策略按配置顺序依次生效
+type PolicyConfig struct {
+	Name string `yaml:"name"`
+	// Keys 生效的调用方，为token或cert:<证书名称>，为空时对所有调用方生效
+	Keys []string `yaml:"keys"`
+	// Models 生效的模型，支持*通配符，为空时对所有模型生效
+	Models []string `yaml:"models"`
+	// SystemPrompt 插入到所有消息之前的系统提示词
+	SystemPrompt string `yaml:"system_prompt"`
+	// Options 强制使用的Ollama选项，覆盖请求中的值
+	Options map[string]interface{} `yaml:"options"`
+	// MaxNumCtx num_ctx的上限
+	MaxNumCtx int          `yaml:"max_num_ctx"`
+	Block     BlockConfig  `yaml:"block"`
+	Redact    RedactConfig `yaml:"redact"`
+}
+
+// BlockConfig 请求内容拦截配置
+type BlockConfig struct {
+	// Keywords 关键词，不区分大小写
+	Keywords []string `yaml:"keywords"`
+	// Patterns 正则表达式
+	Patterns []string `yaml:"patterns"`
+	// Message 拦截时返回的错误信息
+	Message string `yaml:"message"`
+}
+
+// RedactConfig 敏感信息脱敏配置
+type RedactConfig struct {
+	// Builtin 内置的敏感信息类型：email / phone / id_card
+	Builtin []string `yaml:"builtin"`
+	// Patterns 自定义正则表达式
+	Patterns []string `yaml:"patterns"`
+	// Replacement 替换后的内容，默认为[REDACTED]
+	Replacement string `yaml:"replacement"`
+	// Scope 脱敏范围：all（默认）/ prompt / completion
+	Scope string `yaml:"scope"`
+	// Window 流式响应的滑动窗口长度（字符数），跨分块的敏感信息长度不能超过该值
+	Window int `yaml:"window"`
+}
+
+const (
+	defaultBlockMessage      = "请求内容不符合使用策略"
+	defaultRedactReplacement = "[REDACTED]"
+	defaultRedactWindow      = 64
+)
+
+// builtinRedactPatterns 内置的敏感信息正则表达式
+var builtinRedactPatterns = map[string]string{
+	"email":   `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"phone":   `(?:\+?86[- ]?)?\b1[3-9]\d{9}\b`,
+	"id_card": `\b\d{17}[\dXx]\b`,
+}
+
+// regexCache 编译后的正则表达式，配置每次请求都会重新加载，避免重复编译
+var regexCache sync.Map
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("策略中的正则表达式 %q 无效: %v", pattern, err)
+	}
+	regexCache.Store(pattern, re)
+	return re, nil
+}
+
+// blockRule 拦截规则
+type blockRule struct {
+	re      *regexp.Regexp
+	message string
+}
+
+// policyPipeline 对单个请求生效的策略集合
+type policyPipeline struct {
+	systemPrompts []string
+	options       map[string]interface{}
+	maxNumCtx     int
+	blocks        []blockRule
+	prompt        *redactor
+	completion    *redactor
+}
+
+// newPolicyPipeline 根据调用方和模型选出生效的策略，没有匹配的策略时返回nil
+// model为客户端请求的模型名称，target为解析别名后的上游模型
+func newPolicyPipeline(config *Config, c *gin.Context, model, target string) (*policyPipeline, error) {
+	identity := c.GetString(identityKey)
+	var p *policyPipeline
+	for _, policy := range config.Policies {
+		if !matchPolicyKey(policy.Keys, identity) || !matchPolicyModel(policy.Models, model, target) {
+			continue
+		}
+		if p == nil {
+			p = &policyPipeline{
+				options:    map[string]interface{}{},
+				prompt:     &redactor{window: defaultRedactWindow},
+				completion: &redactor{window: defaultRedactWindow},
+			}
+		}
+		if err := p.add(policy); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func matchPolicyKey(keys []string, identity string) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	// keys可以配置token原文或身份标识，token按摘要匹配
+	for _, key := range keys {
+		if key == identity || tokenIdentity(key) == identity {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPolicyModel(patterns []string, names ...string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// add 合并一条策略
+func (p *policyPipeline) add(policy PolicyConfig) error {
+	if policy.SystemPrompt != "" {
+		p.systemPrompts = append(p.systemPrompts, policy.SystemPrompt)
+	}
+	for key, value := range policy.Options {
+		p.options[key] = value
+	}
+	if policy.MaxNumCtx > 0 && (p.maxNumCtx == 0 || policy.MaxNumCtx < p.maxNumCtx) {
+		p.maxNumCtx = policy.MaxNumCtx
+	}
+
+	message := policy.Block.Message
+	if message == "" {
+		message = defaultBlockMessage
+	}
+	for _, keyword := range policy.Block.Keywords {
+		re, err := compileRegex("(?i)" + regexp.QuoteMeta(keyword))
+		if err != nil {
+			return err
+		}
+		p.blocks = append(p.blocks, blockRule{re: re, message: message})
+	}
+	for _, pattern := range policy.Block.Patterns {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return err
+		}
+		p.blocks = append(p.blocks, blockRule{re: re, message: message})
+	}
+
+	redact := policy.Redact
+	replacement := redact.Replacement
+	if replacement == "" {
+		replacement = defaultRedactReplacement
+	}
+	var rules []redactRule
+	for _, name := range redact.Builtin {
+		pattern, ok := builtinRedactPatterns[name]
+		if !ok {
+			return fmt.Errorf("不支持的内置脱敏类型: %s", name)
+		}
+		re, _ := compileRegex(pattern)
+		rules = append(rules, redactRule{re: re, replacement: replacement})
+	}
+	for _, pattern := range redact.Patterns {
+		re, err := compileRegex(pattern)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, redactRule{re: re, replacement: replacement})
+	}
+	if redact.Scope != "completion" {
+		p.prompt.rules = append(p.prompt.rules, rules...)
+	}
+	if redact.Scope != "prompt" {
+		p.completion.rules = append(p.completion.rules, rules...)
+		if redact.Window > p.completion.window {
+			p.completion.window = redact.Window
+		}
+	}
+	return nil
+}
+
+// check 检查请求内容是否命中拦截规则
+func (p *policyPipeline) check(texts ...string) error {
+	if p == nil {
+		return nil
+	}
+	for _, text := range texts {
+		for _, rule := range p.blocks {
+			if rule.re.MatchString(text) {
+				return fmt.Errorf("%s", rule.message)
+			}
+		}
+	}
+	return nil
+}
+
+// systemPrompt 返回需要插入的系统提示词
+func (p *policyPipeline) systemPrompt() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.systemPrompts, "\n\n")
+}
+
+// redactPrompt 对请求内容脱敏
+func (p *policyPipeline) redactPrompt(text string) string {
+	if p == nil {
+		return text
+	}
+	return p.prompt.redact(text)
+}
+
+// redactCompletion 对非流式响应内容脱敏
+func (p *policyPipeline) redactCompletion(text string) string {
+	if p == nil {
+		return text
+	}
+	return p.completion.redact(text)
+}
+
+// completionStream 创建流式响应的脱敏器，不需要脱敏时返回nil
+func (p *policyPipeline) completionStream() *streamRedactor {
+	if !p.redactsCompletion() {
+		return nil
+	}
+	return &streamRedactor{redactor: p.completion}
+}
+
+// redactsCompletion 判断是否需要对响应内容脱敏
+// 需要脱敏时logprobs中的token无法可靠地脱敏，不返回logprobs
+func (p *policyPipeline) redactsCompletion() bool {
+	return p != nil && len(p.completion.rules) > 0
+}
+
+// redactToolCalls 对OpenAI格式的工具调用参数脱敏
+func (p *policyPipeline) redactToolCalls(calls []models.ToolCall) {
+	if !p.redactsCompletion() {
+		return
+	}
+	for i := range calls {
+		calls[i].Function.Arguments = p.redactArguments(calls[i].Function.Arguments)
+	}
+}
+
+// redactArguments 对工具调用参数中的字符串脱敏，OpenAI格式的参数为JSON字符串，Ollama格式为JSON对象
+func (p *policyPipeline) redactArguments(arguments json.RawMessage) json.RawMessage {
+	value, ok := decodeJSONValue(arguments)
+	if !ok {
+		return arguments
+	}
+	if text, ok := value.(string); ok {
+		if inner, ok := decodeJSONValue([]byte(text)); ok {
+			data, _ := json.Marshal(p.redactValue(inner))
+			text = string(data)
+		} else {
+			text = p.completion.redact(text)
+		}
+		quoted, _ := json.Marshal(text)
+		return quoted
+	}
+	data, _ := json.Marshal(p.redactValue(value))
+	return data
+}
+
+// redactValue 递归对JSON值中的所有字符串脱敏
+func (p *policyPipeline) redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return p.completion.redact(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = p.redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = p.redactValue(item)
+		}
+	}
+	return value
+}
+
+// decodeJSONValue 解析JSON，数字保持原样避免精度损失
+func decodeJSONValue(data []byte) (interface{}, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// maxContext 返回num_ctx的上限，0表示不限制
+func (p *policyPipeline) maxContext() int {
+	if p == nil {
+		return 0
+	}
+	return p.maxNumCtx
+}
+
+// applyOptions 应用强制选项和num_ctx上限
+func (p *policyPipeline) applyOptions(options *models.RequestOptions) *models.RequestOptions {
+	if p == nil {
+		return options
+	}
+	options = models.OverrideOptions(options, p.options)
+	if p.maxNumCtx > 0 && options != nil && options.NumCtx > p.maxNumCtx {
+		options.NumCtx = p.maxNumCtx
+	}
+	return options
+}
+
+// applyChat 对Ollama聊天请求应用策略：拦截、脱敏、插入系统提示词和强制选项
+func (p *policyPipeline) applyChat(req *models.OllamaChatRequest) error {
+	if p == nil {
+		return nil
+	}
+	for _, message := range req.Messages {
+		if err := p.check(message.Content); err != nil {
+			return err
+		}
+	}
+
+	var messages []models.ChatMessage
+	if system := p.systemPrompt(); system != "" {
+		messages = append(messages, models.ChatMessage{Role: "system", Content: system})
+	}
+	for _, message := range req.Messages {
+		message.Content = p.redactPrompt(message.Content)
+		messages = append(messages, message)
+	}
+	req.Messages = messages
+	req.Options = p.applyOptions(req.Options)
+	return nil
+}
+
+// applyNative 对原生/api/chat、/api/generate请求体应用策略
+func (p *policyPipeline) applyNative(apiPath string, body map[string]interface{}) error {
+	if p == nil {
+		return nil
+	}
+
+	switch apiPath {
+	case "/api/chat":
+		messages, _ := body["messages"].([]interface{})
+		for _, item := range messages {
+			if message, ok := item.(map[string]interface{}); ok {
+				content, _ := message["content"].(string)
+				if err := p.check(content); err != nil {
+					return err
+				}
+				message["content"] = p.redactPrompt(content)
+			}
+		}
+		if system := p.systemPrompt(); system != "" {
+			body["messages"] = append([]interface{}{map[string]interface{}{"role": "system", "content": system}}, messages...)
+		}
+	case "/api/generate":
+		for _, key := range []string{"prompt", "suffix", "system"} {
+			text, _ := body[key].(string)
+			if err := p.check(text); err != nil {
+				return err
+			}
+			if text != "" {
+				body[key] = p.redactPrompt(text)
+			}
+		}
+		if system := p.systemPrompt(); system != "" {
+			if current, _ := body["system"].(string); current != "" {
+				system += "\n\n" + current
+			}
+			body["system"] = system
+		}
+	}
+
+	options, _ := body["options"].(map[string]interface{})
+	if options == nil {
+		options = map[string]interface{}{}
+	}
+	for key, value := range p.options {
+		options[key] = value
+	}
+	if numCtx, ok := options["num_ctx"].(float64); ok && p.maxNumCtx > 0 && int(numCtx) > p.maxNumCtx {
+		options["num_ctx"] = p.maxNumCtx
+	}
+	if numCtx, ok := options["num_ctx"].(int); ok && p.maxNumCtx > 0 && numCtx > p.maxNumCtx {
+		options["num_ctx"] = p.maxNumCtx
+	}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+	return nil
+}
+
+// applyOpenAI 对直接转发给OpenAI兼容后端的/v1请求体应用策略：拦截、脱敏和插入系统提示词
+// 强制选项和num_ctx上限是Ollama选项，对OpenAI兼容后端不生效；补全接口没有系统提示词
+func (p *policyPipeline) applyOpenAI(path string, body map[string]interface{}) error {
+	if p == nil {
+		return nil
+	}
+
+	// checkText 检查并脱敏fields中key对应的文本
+	checkText := func(fields map[string]interface{}, key string) error {
+		text, ok := fields[key].(string)
+		if !ok {
+			return nil
+		}
+		if err := p.check(text); err != nil {
+			return err
+		}
+		fields[key] = p.redactPrompt(text)
+		return nil
+	}
+
+	switch path {
+	case models.OpenAIChatPath:
+		messages, _ := body["messages"].([]interface{})
+		for _, item := range messages {
+			message, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := checkText(message, "content"); err != nil {
+				return err
+			}
+			// 多模态消息只检查文本部分
+			parts, _ := message["content"].([]interface{})
+			for _, part := range parts {
+				if part, ok := part.(map[string]interface{}); ok {
+					if err := checkText(part, "text"); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if system := p.systemPrompt(); system != "" {
+			body["messages"] = append([]interface{}{map[string]interface{}{"role": "system", "content": system}}, messages...)
+		}
+	case models.OpenAICompletionPath:
+		if err := checkText(body, "suffix"); err != nil {
+			return err
+		}
+		if err := checkText(body, "prompt"); err != nil {
+			return err
+		}
+		prompts, _ := body["prompt"].([]interface{})
+		for i, prompt := range prompts {
+			if text, ok := prompt.(string); ok {
+				if err := p.check(text); err != nil {
+					return err
+				}
+				prompts[i] = p.redactPrompt(text)
+			}
+		}
+	}
+	return nil
+}
+
+// redactOpenAIChoice 对OpenAI格式的非流式结果脱敏，需要脱敏时丢弃logprobs
+func (p *policyPipeline) redactOpenAIChoice(choice map[string]interface{}) {
+	if !p.redactsCompletion() {
+		return
+	}
+	delete(choice, "logprobs")
+	if text, ok := choice["text"].(string); ok {
+		choice["text"] = p.completion.redact(text)
+	}
+	message, _ := choice["message"].(map[string]interface{})
+	for _, key := range []string{"content", "reasoning_content"} {
+		if text, ok := message[key].(string); ok {
+			message[key] = p.completion.redact(text)
+		}
+	}
+	calls, _ := message["tool_calls"].([]interface{})
+	for _, call := range calls {
+		call, _ := call.(map[string]interface{})
+		function, _ := call["function"].(map[string]interface{})
+		if arguments, ok := function["arguments"].(string); ok {
+			quoted, _ := json.Marshal(arguments)
+			json.Unmarshal(p.redactArguments(quoted), &arguments)
+			function["arguments"] = arguments
+		}
+	}
+}
+
+// redactNDJSON 对原生接口的NDJSON响应逐行脱敏，每写出一行调用一次flush
+// 工具调用参数一并脱敏，logprobs被丢弃
+func (p *policyPipeline) redactNDJSON(w io.Writer, r io.Reader, flush func()) error {
+	// 每个字段使用独立的滑动窗口
+	streams := map[string]*streamRedactor{}
+	redactField := func(name string, fields map[string]interface{}, key string, done bool) {
+		text, ok := fields[key].(string)
+		if !ok {
+			return
+		}
+		stream := streams[name]
+		if stream == nil {
+			stream = p.completionStream()
+			streams[name] = stream
+		}
+		fields[key] = stream.feed(text, done)
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		raw, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(bytes.TrimSpace(raw)) > 0 {
+			var line map[string]interface{}
+			if json.Unmarshal(raw, &line) == nil {
+				done, _ := line["done"].(bool)
+				if message, ok := line["message"].(map[string]interface{}); ok {
+					redactField("content", message, "content", done)
+					redactField("thinking", message, "thinking", done)
+					if calls, ok := message["tool_calls"]; ok {
+						message["tool_calls"] = p.redactValue(calls)
+					}
+				}
+				delete(line, "logprobs")
+				redactField("response", line, "response", done)
+				redactField("thinking", line, "thinking", done)
+				raw, _ = json.Marshal(line)
+				raw = append(raw, '\n')
+			}
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+			flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// redactRule 脱敏规则
+type redactRule struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// redactor 按规则替换文本中的敏感信息
+type redactor struct {
+	rules  []redactRule
+	window int
+}
+
+// redactSpan 文本中需要替换的一段内容
+type redactSpan struct {
+	start, end  int
+	replacement string
+}
+
+// spans 查找所有规则的匹配位置，重叠时保留起始位置靠前、长度更长的匹配
+func (r *redactor) spans(text string) []redactSpan {
+	var spans []redactSpan
+	for _, rule := range r.rules {
+		for _, loc := range rule.re.FindAllStringIndex(text, -1) {
+			if loc[1] > loc[0] {
+				spans = append(spans, redactSpan{start: loc[0], end: loc[1], replacement: rule.replacement})
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	var result []redactSpan
+	for _, span := range spans {
+		if len(result) > 0 && span.start < result[len(result)-1].end {
+			continue
+		}
+		result = append(result, span)
+	}
+	return result
+}
+
+func (r *redactor) redact(text string) string {
+	if len(r.rules) == 0 {
+		return text
+	}
+	out, _ := r.replace(text, r.spans(text), len(text))
+	return out
+}
+
+// replace 替换cut之前的匹配内容，返回替换结果以及实际处理到的位置
+// 跨越cut的匹配可能尚未完整，处理位置会提前到该匹配的起始位置
+func (r *redactor) replace(text string, spans []redactSpan, cut int) (string, int) {
+	for _, span := range spans {
+		if span.start < cut && span.end > cut {
+			cut = span.start
+			break
+		}
+	}
+
+	var out strings.Builder
+	pos := 0
+	for _, span := range spans {
+		if span.end > cut {
+			break
+		}
+		out.WriteString(text[pos:span.start])
+		out.WriteString(span.replacement)
+		pos = span.end
+	}
+	out.WriteString(text[pos:cut])
+	return out.String(), cut
+}
+
+// streamRedactor 流式响应的脱敏器，保留末尾window个字符的滑动窗口，
+// 以便识别被拆分到多个分块中的敏感信息
+type streamRedactor struct {
+	*redactor
+	pending string
+}
+
+// feed 输入一个分块，返回可以确定的脱敏结果，done为true时输出全部剩余内容
+func (s *streamRedactor) feed(text string, done bool) string {
+	if s == nil {
+		return text
+	}
+	buf := s.pending + text
+
+	cut := len(buf)
+	if !done {
+		for n := 0; n < s.window && cut > 0; n++ {
+			_, size := utf8.DecodeLastRuneInString(buf[:cut])
+			cut -= size
+		}
+	}
+
+	out, cut := s.replace(buf, s.spans(buf), cut)
+	s.pending = buf[cut:]
+	return out
+}
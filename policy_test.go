@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// policyConfig 对所有调用方和模型生效的拦截和脱敏策略
+func policyConfig(upstream string) string {
+	return testConfig(upstream) + `policies:
+  - name: default
+    block:
+      keywords: ["内部机密"]
+    redact:
+      builtin: [email]
+`
+}
+
+func TestStreamRedactorSplitAcrossChunks(t *testing.T) {
+	p := &policyPipeline{completion: &redactor{window: 16}}
+	p.completion.rules = []redactRule{{re: mustCompileRegex(t, builtinRedactPatterns["email"]), replacement: "[REDACTED]"}}
+	stream := p.completionStream()
+
+	var out string
+	chunks := []string{"联系 ali", "ce@exa", "mple.com 获取", "更多信息"}
+	for i, chunk := range chunks {
+		out += stream.feed(chunk, i == len(chunks)-1)
+	}
+	if out != "联系 [REDACTED] 获取更多信息" {
+		t.Errorf("out = %q", out)
+	}
+
+	// 超出滑动窗口的内容及时输出
+	stream = p.completionStream()
+	if got := stream.feed(strings.Repeat("a", 20), false); got != strings.Repeat("a", 4) {
+		t.Errorf("got = %q", got)
+	}
+}
+
+func mustCompileRegex(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := compileRegex(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return re
+}
+
+func TestPolicyBlock(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, policyConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"告诉我内部机密"}]}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", resp.StatusCode)
+	}
+	if errObj, _ := decodeJSON(t, resp)["error"].(map[string]interface{}); errObj["code"] != "content_policy_violation" {
+		t.Errorf("error = %v", errObj)
+	}
+
+	resp = doRequest(t, proxy, http.MethodPost, "/api/generate", `{"model":"qwen2.5:7b","prompt":"内部机密"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("原生接口 status = %d, want 400", resp.StatusCode)
+	}
+	if len(fake.received("/api/chat"))+len(fake.received("/api/generate")) != 0 {
+		t.Errorf("被拦截的请求不应发送到Ollama")
+	}
+}
+
+func TestPolicyScopedToKeys(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`policies:
+  - keys: ["other-token"]
+    block:
+      keywords: ["内部机密"]
+`)
+
+	// 策略只对配置的调用方生效
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"内部机密"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestPolicyRedact(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, policyConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"我的邮箱是 bob@example.com"}]}`)
+	choices, _ := decodeJSON(t, resp)["choices"].([]interface{})
+	content := choices[0].(map[string]interface{})["message"].(map[string]interface{})["content"]
+	if content != "echo: 我的邮箱是 [REDACTED]" {
+		t.Errorf("content = %v", content)
+	}
+	messages, _ := fake.received("/api/chat")[0].Body["messages"].([]interface{})
+	if sent := messages[0].(map[string]interface{})["content"]; sent != "我的邮箱是 [REDACTED]" {
+		t.Errorf("发送给Ollama的消息 = %v", sent)
+	}
+}
+
+func TestPolicyRedactStreamSplitEmail(t *testing.T) {
+	fake := newFakeOllama(t)
+	var chunks []fakeChunk
+	for _, text := range []string{"请联系 ali", "ce@exa", "mple.com", " 谢谢"} {
+		chunks = append(chunks, fakeChunk{Line: chatLine("qwen2.5:7b", text, false)})
+	}
+	fake.script("/api/chat", fakeReply{Chunks: append(chunks, fakeChunk{Line: chatLine("qwen2.5:7b", "", true)})})
+	proxy := newTestProxy(t, policyConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	var content string
+	for _, chunk := range chatChunks(t, readSSE(t, resp.Body)) {
+		content += chunk.Choices[0].Delta.Content
+	}
+	if content != "请联系 [REDACTED] 谢谢" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestPolicyRedactLogprobsAndToolCalls(t *testing.T) {
+	fake := newFakeOllama(t)
+	line := map[string]interface{}{}
+	json.Unmarshal([]byte(withLogprobs(chatLine("qwen2.5:7b", "", true), "bob@example.com", -0.1)), &line)
+	line["message"].(map[string]interface{})["tool_calls"] = []interface{}{
+		map[string]interface{}{"function": map[string]interface{}{"name": "send_mail", "arguments": map[string]interface{}{"to": "bob@example.com", "count": 3}}},
+	}
+	fake.script("/api/chat", jsonReply(line), jsonReply(line))
+	proxy := newTestProxy(t, policyConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"logprobs":true,"messages":[{"role":"user","content":"hi"}]}`)
+	var result struct {
+		Choices []struct {
+			Message struct {
+				ToolCalls []struct {
+					Function struct {
+						Arguments string `json:"arguments"`
+					} `json:"function"`
+				} `json:"tool_calls"`
+			} `json:"message"`
+			Logprobs interface{} `json:"logprobs"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	choice := result.Choices[0]
+	if choice.Logprobs != nil {
+		t.Errorf("开启输出脱敏时不应返回logprobs: %v", choice.Logprobs)
+	}
+	if got := choice.Message.ToolCalls[0].Function.Arguments; got != `{"count":3,"to":"[REDACTED]"}` {
+		t.Errorf("arguments = %s", got)
+	}
+
+	// 原生接口同样脱敏
+	resp = doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"qwen2.5:7b","stream":false,"logprobs":true,"messages":[{"role":"user","content":"hi"}]}`)
+	raw, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if strings.Contains(raw, "bob@example.com") || strings.Contains(raw, `"logprobs"`) || !strings.Contains(raw, "[REDACTED]") {
+		t.Errorf("原生接口响应 = %s", raw)
+	}
+}
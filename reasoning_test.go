@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestOpenAIChatStreamReasoning(t *testing.T) {
+	fake := newFakeOllama(t)
+	var chunks []fakeChunk
+	for _, text := range []string{"<thi", "nk>先算", "一下</th", "ink>\n\n", "42"} {
+		chunks = append(chunks, fakeChunk{Line: chatLine("deepseek-r1:14b", text, false)})
+	}
+	fake.script("/api/chat", fakeReply{Chunks: append(chunks, fakeChunk{Line: chatLine("deepseek-r1:14b", "", true)})})
+	proxy := newTestProxy(t, testConfig(fake.URL())+`models:
+  deepseek-r1:
+    model: "deepseek-r1:14b"
+    reasoning: extract
+`)
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"deepseek-r1","stream":true,"reasoning_effort":"high","messages":[{"role":"user","content":"6*7"}]}`)
+	var content, reasoning string
+	for _, event := range readSSE(t, resp.Body) {
+		if event.Data == sseDone {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		json.Unmarshal([]byte(event.Data), &chunk)
+		content += chunk.Choices[0].Delta.Content
+		reasoning += chunk.Choices[0].Delta.ReasoningContent
+	}
+	if content != "42" || reasoning != "先算一下" {
+		t.Errorf("content = %q, reasoning_content = %q", content, reasoning)
+	}
+	if think := fake.received("/api/chat")[0].Body["think"]; think != "high" {
+		t.Errorf("think = %v, want high", think)
+	}
+}
+
+func TestOpenAIChatReasoningStrip(t *testing.T) {
+	fake := newFakeOllama(t)
+	line := map[string]interface{}{}
+	json.Unmarshal([]byte(chatLine("qwq", "42", true)), &line)
+	line["message"].(map[string]interface{})["thinking"] = "先算一下"
+	fake.script("/api/chat", jsonReply(line))
+	proxy := newTestProxy(t, testConfig(fake.URL())+`models:
+  qwq:
+    reasoning: strip
+`)
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwq","stream":false,"think":false,"messages":[{"role":"user","content":"6*7"}]}`)
+	choices, _ := decodeJSON(t, resp)["choices"].([]interface{})
+	message := choices[0].(map[string]interface{})["message"].(map[string]interface{})
+	if message["content"] != "42" || message["reasoning_content"] != nil {
+		t.Errorf("strip模式下不应返回推理内容: %v", message)
+	}
+	if think := fake.received("/api/chat")[0].Body["think"]; think != false {
+		t.Errorf("think = %v, want false", think)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// listenReusePort 当前平台不支持SO_REUSEPORT
+func listenReusePort(addr string) (net.Listener, error) {
+	return nil, fmt.Errorf("%s 平台不支持reuse_port", runtime.GOOS)
+}
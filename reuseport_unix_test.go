@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"testing"
+)
+
+func TestListenReusePort(t *testing.T) {
+	config := &Config{}
+	config.Server.ReusePort = true
+	config.Server.Listen = []string{"127.0.0.1:0"}
+
+	first, err := listen(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first[0].Close()
+
+	// 升级时新进程可以在旧进程退出前监听同一端口
+	config.Server.Listen = []string{first[0].Addr().String()}
+	second, err := listen(config)
+	if err != nil {
+		t.Fatalf("开启reuse_port后无法重复监听 %s: %v", first[0].Addr(), err)
+	}
+	second[0].Close()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	// AllowOrigins 允许的来源，默认为["*"]，支持 https://*.example.com 形式的通配符
+	AllowOrigins []string `yaml:"allow_origins"`
+	AllowMethods []string `yaml:"allow_methods"`
+	AllowHeaders []string `yaml:"allow_headers"`
+	// ExposeHeaders 允许浏览器读取的响应头
+	ExposeHeaders []string `yaml:"expose_headers"`
+	// AllowCredentials 是否允许携带Cookie等凭证，allow_origins包含*时不能开启
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge 预检请求的缓存时间，默认为12h
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+var (
+	defaultCORSMethods       = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	defaultCORSHeaders       = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Session-ID", "Cache-Control"}
+	defaultCORSExposeHeaders = []string{"Content-Length", "X-Cache"}
+)
+
+const defaultCORSMaxAge = 12 * time.Hour
+
+// defaultSecurityHeaders 默认添加到所有响应的安全响应头
+var defaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options": "nosniff",
+	"X-Frame-Options":        "DENY",
+	"Referrer-Policy":        "no-referrer",
+}
+
+// hstsHeader 启用TLS时默认添加的HSTS响应头
+const hstsHeader = "max-age=31536000"
+
+// buildCORSConfig 将配置转换为CORS中间件的配置
+func buildCORSConfig(cfg CORSConfig) (cors.Config, error) {
+	origins := cfg.AllowOrigins
+	if len(origins) == 0 {
+		origins = []string{"*"}
+	}
+	result := cors.Config{
+		AllowMethods:     cfg.AllowMethods,
+		AllowHeaders:     cfg.AllowHeaders,
+		ExposeHeaders:    cfg.ExposeHeaders,
+		AllowCredentials: cfg.AllowCredentials,
+		AllowWildcard:    true,
+		MaxAge:           cfg.MaxAge,
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			result.AllowAllOrigins = true
+		}
+	}
+	if result.AllowAllOrigins {
+		// 浏览器不接受 Access-Control-Allow-Origin: * 与携带凭证同时使用
+		if cfg.AllowCredentials {
+			return cors.Config{}, errors.New("server.cors.allow_credentials 不能与 allow_origins: [\"*\"] 同时使用，请配置具体的来源")
+		}
+	} else {
+		result.AllowOrigins = origins
+	}
+	if len(result.AllowMethods) == 0 {
+		result.AllowMethods = defaultCORSMethods
+	}
+	if len(result.AllowHeaders) == 0 {
+		result.AllowHeaders = defaultCORSHeaders
+	}
+	if len(result.ExposeHeaders) == 0 {
+		result.ExposeHeaders = defaultCORSExposeHeaders
+	}
+	if result.MaxAge <= 0 {
+		result.MaxAge = defaultCORSMaxAge
+	}
+	if err := result.Validate(); err != nil {
+		return cors.Config{}, fmt.Errorf("server.cors 配置无效: %v", err)
+	}
+	return result, nil
+}
+
+// validateTrustedProxies 检查信任的代理地址是否为有效的IP或CIDR
+func validateTrustedProxies(proxies []string) error {
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("server.trusted_proxies 中的 %s 不是有效的CIDR", proxy)
+			}
+		} else if net.ParseIP(proxy) == nil {
+			return fmt.Errorf("server.trusted_proxies 中的 %s 不是有效的IP地址", proxy)
+		}
+	}
+	return nil
+}
+
+// securityHeaders 为所有响应添加安全响应头，配置中值为空的响应头不添加
+func securityHeaders(config *Config) gin.HandlerFunc {
+	headers := map[string]string{}
+	for name, value := range defaultSecurityHeaders {
+		headers[name] = value
+	}
+	if config.Server.TLS.Enabled {
+		headers["Strict-Transport-Security"] = hstsHeader
+	}
+	for name, value := range config.Server.SecurityHeaders {
+		headers[http.CanonicalHeaderKey(name)] = value
+	}
+	return func(c *gin.Context) {
+		for name, value := range headers {
+			if value != "" {
+				c.Header(name, value)
+			}
+		}
+		c.Next()
+	}
+}
+
+// limitBody 限制请求体大小，超出限制时返回413
+func limitBody(maxSize int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if maxSize <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > maxSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("请求体超过 %d 字节的限制", maxSize),
+			})
+			c.Abort()
+			return
+		}
+		// 未声明长度的请求体在读取超出限制时返回错误
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+		c.Next()
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`server:
+  cors:
+    allow_origins: ["https://chat.example.com", "https://*.internal.example.com"]
+    allow_credentials: true
+`)
+
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{origin: "https://chat.example.com", want: "https://chat.example.com"},
+		{origin: "https://webui.internal.example.com", want: "https://webui.internal.example.com"},
+		{origin: "https://evil.example.com", want: ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodOptions, proxy.URL+"/v1/chat/completions", nil)
+		req.Header.Set("Origin", tt.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.want)
+		}
+		if tt.want != "" && resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+			t.Errorf("%s: 应允许携带凭证", tt.origin)
+		}
+	}
+}
+
+func TestCORSRejectsWildcardWithCredentials(t *testing.T) {
+	config := &Config{}
+	config.Server.CORS.AllowCredentials = true
+	if _, err := buildCORSConfig(config.Server.CORS); err == nil {
+		t.Error("默认的allow_origins为*，开启allow_credentials时应返回错误")
+	}
+
+	config.Server.CORS.AllowOrigins = []string{"chat.example.com"}
+	if _, err := buildCORSConfig(config.Server.CORS); err == nil {
+		t.Error("缺少协议的来源应返回错误")
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`server:
+  security_headers:
+    X-Frame-Options: ""
+    Content-Security-Policy: "default-src 'none'"
+`)
+
+	resp := doRequest(t, proxy, http.MethodGet, "/api/tags", "")
+	if got := resp.Header.Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q", got)
+	}
+	if got := resp.Header.Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Errorf("Content-Security-Policy = %q", got)
+	}
+	if _, ok := resp.Header["X-Frame-Options"]; ok {
+		t.Errorf("配置为空的响应头不应添加")
+	}
+	if _, ok := resp.Header["Strict-Transport-Security"]; ok {
+		t.Errorf("未启用TLS时不应添加HSTS")
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+"server:\n  max_body_size: 64\n")
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"`+strings.Repeat("a", 100)+`"}]}`)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", resp.StatusCode)
+	}
+	if len(fake.received("/api/chat")) != 0 {
+		t.Errorf("超出限制的请求不应转发到Ollama")
+	}
+
+	resp = doRequest(t, proxy, http.MethodPost, "/api/chat", `{"model":"qwen2.5:7b","stream":false}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200 within limit", resp.StatusCode)
+	}
+}
+
+func TestMaxBodySizeChunked(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+"server:\n  max_body_size: 64\n")
+
+	// 包装后的Reader长度未知，请求使用chunked编码且没有Content-Length
+	body := struct{ io.Reader }{strings.NewReader(`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"` + strings.Repeat("a", 100) + `"}]}`)}
+	req, err := http.NewRequest(http.MethodPost, proxy.URL+"/api/chat", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", resp.StatusCode)
+	}
+	if len(fake.received("/api/chat")) != 0 {
+		t.Errorf("超出限制的请求不应转发到Ollama")
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	if err := validateTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1", "::1"}); err != nil {
+		t.Errorf("validateTrustedProxies() = %v", err)
+	}
+	writeTestConfig(t, testConfig("http://127.0.0.1:1"))
+	if err := os.WriteFile(configFile, []byte("server:\n  trusted_proxies: [\"10.0.0.0/33\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(); err == nil {
+		t.Error("无效的CIDR应返回错误")
+	}
+}
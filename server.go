@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	defaultListenAddr      = ":8080"
+
+	// systemdListenFD systemd socket激活传入的第一个文件描述符
+	systemdListenFD = 3
+)
+
+// activeRequests 正在处理的请求数
+var activeRequests int64
+
+// trackRequests 统计正在处理的请求数，关闭服务时用于输出等待的请求数
+func trackRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		atomic.AddInt64(&activeRequests, 1)
+		defer atomic.AddInt64(&activeRequests, -1)
+		c.Next()
+	}
+}
+
+// listenAddrs 返回配置的监听地址，默认为:8080
+func listenAddrs(config *Config) []string {
+	if len(config.Server.Listen) > 0 {
+		return config.Server.Listen
+	}
+	return []string{defaultListenAddr}
+}
+
+// listen 为每个监听地址创建监听器，通过systemd socket激活启动时使用传入的socket，配置reuse_port时开启SO_REUSEPORT
+func listen(config *Config) ([]net.Listener, error) {
+	lns, err := systemdListeners()
+	if err != nil || lns != nil {
+		return lns, err
+	}
+	for _, addr := range listenAddrs(config) {
+		var ln net.Listener
+		if config.Server.ReusePort {
+			ln, err = listenReusePort(addr)
+		} else {
+			ln, err = net.Listen("tcp", addr)
+		}
+		if err != nil {
+			for _, opened := range lns {
+				opened.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// systemdListeners 返回systemd socket激活传入的所有socket，未通过socket激活启动时返回nil
+func systemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	// 避免子进程误用传入的socket
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	lns := make([]net.Listener, 0, fds)
+	for fd := systemdListenFD; fd < systemdListenFD+fds; fd++ {
+		file := os.NewFile(uintptr(fd), "systemd-socket")
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range lns {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("无法使用systemd传入的socket: %v", err)
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// serve 在所有监听器上启动服务，ctx结束后停止接受新连接，并等待进行中的请求完成
+// 超过shutdown_timeout仍未完成的请求会被强制断开，任意一个监听器出错时关闭整个服务
+func serve(ctx context.Context, config *Config, srv *http.Server, lns []net.Listener) error {
+	// Serve会为HTTP/2设置srv.TLSConfig，需要在启动前判断是否使用TLS
+	useTLS := srv.TLSConfig != nil
+	errCh := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			if useTLS {
+				errCh <- srv.ServeTLS(ln, "", "")
+			} else {
+				errCh <- srv.Serve(ln)
+			}
+		}(ln)
+	}
+
+	var serveErr error
+	received := 0
+	select {
+	case serveErr = <-errCh:
+		received++
+	case <-ctx.Done():
+	}
+
+	timeout := config.Server.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	logger.Printf("正在关闭服务，等待 %d 个进行中的请求完成，最长 %v", atomic.LoadInt64(&activeRequests), timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("等待超时，强制断开 %d 个请求", atomic.LoadInt64(&activeRequests))
+		srv.Close()
+	}
+	for ; received < len(lns); received++ {
+		if err := <-errCh; serveErr == nil {
+			serveErr = err
+		}
+	}
+	if serveErr == http.ErrServerClosed {
+		return nil
+	}
+	return serveErr
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeDrainsActiveRequests(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "Hello", false)},
+		{Line: chatLine("qwen2.5:7b", " world", false), Delay: 300 * time.Millisecond},
+		{Line: chatLine("qwen2.5:7b", "", true)},
+	}})
+	config := writeTestConfig(t, testConfig(fake.URL()))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newRouter(config)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, config, srv, []net.Listener{ln})
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String()+"/v1/chat/completions",
+		strings.NewReader(`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if _, err := nextSSE(reader); err != nil {
+		t.Fatal(err)
+	}
+
+	// 流式响应进行中时开始关闭服务
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("关闭服务后不应再接受新连接")
+	}
+
+	// 进行中的流式响应完整返回
+	var content strings.Builder
+	events := readSSE(t, reader)
+	for _, chunk := range chatChunks(t, events) {
+		content.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if content.String() != " world" {
+		t.Errorf("剩余内容 = %q", content.String())
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("进行中的请求完成后服务没有退出")
+	}
+}
+
+func TestServeForcesCloseAfterTimeout(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "Hello", false)},
+		{Line: chatLine("qwen2.5:7b", "", true), Delay: time.Second},
+	}})
+	config := writeTestConfig(t, testConfig(fake.URL())+"server:\n  shutdown_timeout: 100ms\n")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newRouter(config)}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, config, srv, []net.Listener{ln})
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, "http://"+ln.Addr().String()+"/v1/chat/completions",
+		strings.NewReader(`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := nextSSE(bufio.NewReader(resp.Body)); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve() = %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("超过shutdown_timeout后应强制关闭，实际等待了 %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("超过shutdown_timeout后服务没有退出")
+	}
+}
+
+func TestServeMultipleListeners(t *testing.T) {
+	config := writeTestConfig(t, testConfig("http://127.0.0.1:1"))
+	var lns []net.Listener
+	for i := 0; i < 2; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lns = append(lns, ln)
+	}
+	srv := &http.Server{Handler: newRouter(config)}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, config, srv, lns)
+	}()
+
+	for _, ln := range lns {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: status = %d", ln.Addr(), resp.StatusCode)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("服务没有退出")
+	}
+}
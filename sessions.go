@@ -0,0 +1,410 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/douguohai/ollama-proxy/models"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionConfig 服务端会话配置
+type SessionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Dir 会话持久化目录，为空时只保存在内存中
+	Dir string `yaml:"dir"`
+	// MaxSessions 每个调用方最多保留的会话数，超出时删除最久未更新的会话
+	MaxSessions int `yaml:"max_sessions"`
+	// MaxMessages 每个会话最多保留的消息数，超出时删除最早的非system消息
+	MaxMessages int `yaml:"max_messages"`
+	// TTL 会话在最后一次更新后的保留时间
+	TTL time.Duration `yaml:"ttl"`
+}
+
+const (
+	// sessionHeader 客户端指定会话ID的请求头，未设置时使用请求中的user字段
+	sessionHeader = "X-Session-ID"
+	// sessionIDPrefix 复制会话时随机生成的会话ID前缀
+	sessionIDPrefix = "sess_"
+
+	defaultMaxSessions = 100
+	defaultMaxMessages = 200
+	defaultSessionTTL  = 7 * 24 * time.Hour
+)
+
+// chatSession 一个会话的完整历史
+type chatSession struct {
+	ID        string               `json:"id"`
+	Owner     string               `json:"owner"`
+	Model     string               `json:"model"`
+	Messages  []models.ChatMessage `json:"messages"`
+	CreatedAt int64                `json:"created_at"`
+	UpdatedAt int64                `json:"updated_at"`
+}
+
+// sessionSummary 会话列表中的单个会话
+type sessionSummary struct {
+	ID        string `json:"id"`
+	Model     string `json:"model"`
+	Messages  int    `json:"messages"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// sessionStore 会话存储，会话按调用方隔离
+type sessionStore struct {
+	mu       sync.Mutex
+	config   SessionConfig
+	sessions map[string]*chatSession
+}
+
+// sessions 全局会话存储，未启用时为nil
+var sessions *sessionStore
+
+// initSessionStore 根据配置初始化会话存储，配置了持久化目录时加载已保存的会话
+func initSessionStore(cfg SessionConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.MaxSessions <= 0 {
+		cfg.MaxSessions = defaultMaxSessions
+	}
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = defaultMaxMessages
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = defaultSessionTTL
+	}
+
+	store := &sessionStore{config: cfg, sessions: map[string]*chatSession{}}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return err
+		}
+		files, err := filepath.Glob(filepath.Join(cfg.Dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var session chatSession
+			if json.Unmarshal(data, &session) == nil {
+				store.sessions[sessionKey(session.Owner, session.ID)] = &session
+			}
+		}
+	}
+	sessions = store
+	return nil
+}
+
+// sessionKey 会话的存储键，同时用作持久化文件名
+func sessionKey(owner, id string) string {
+	sum := sha256.Sum256([]byte(owner + "\x00" + id))
+	return hex.EncodeToString(sum[:])
+}
+
+// sessionID 返回请求使用的会话ID，未启用会话时返回空字符串
+func sessionID(c *gin.Context, user string) string {
+	if sessions == nil {
+		return ""
+	}
+	if id := c.GetHeader(sessionHeader); id != "" {
+		return id
+	}
+	return user
+}
+
+// expired 判断会话是否已过期，需要持有锁
+func (s *sessionStore) expired(session *chatSession) bool {
+	return time.Since(time.Unix(session.UpdatedAt, 0)) > s.config.TTL
+}
+
+// get 返回会话的副本，不存在或已过期时返回nil
+func (s *sessionStore) get(owner, id string) *chatSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(owner, id)
+	session, ok := s.sessions[key]
+	if !ok {
+		return nil
+	}
+	if s.expired(session) {
+		s.remove(key)
+		return nil
+	}
+	copied := *session
+	copied.Messages = append([]models.ChatMessage(nil), session.Messages...)
+	return &copied
+}
+
+// history 返回会话的历史消息
+func (s *sessionStore) history(owner, id string) []models.ChatMessage {
+	if s == nil || id == "" {
+		return nil
+	}
+	if session := s.get(owner, id); session != nil {
+		return session.Messages
+	}
+	return nil
+}
+
+// appendMessages 追加消息到会话，会话不存在时创建
+func (s *sessionStore) appendMessages(owner, id, model string, messages ...models.ChatMessage) {
+	if s == nil || id == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	key := sessionKey(owner, id)
+	session, ok := s.sessions[key]
+	if !ok || s.expired(session) {
+		session = &chatSession{ID: id, Owner: owner, CreatedAt: now}
+		s.sessions[key] = session
+	}
+	session.Model = model
+	session.UpdatedAt = now
+	session.Messages = trimSessionMessages(append(session.Messages, messages...), s.config.MaxMessages)
+	s.save(key, session)
+	s.evict(owner)
+}
+
+// put 保存完整的会话，用于复制会话
+func (s *sessionStore) put(session *chatSession) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(session.Owner, session.ID)
+	s.sessions[key] = session
+	s.save(key, session)
+	s.evict(session.Owner)
+}
+
+// delete 删除会话，返回会话是否存在
+func (s *sessionStore) delete(owner, id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := sessionKey(owner, id)
+	if _, ok := s.sessions[key]; !ok {
+		return false
+	}
+	s.remove(key)
+	return true
+}
+
+// list 返回调用方的所有会话，按更新时间从新到旧排列
+func (s *sessionStore) list(owner string) []sessionSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := []sessionSummary{}
+	for key, session := range s.sessions {
+		if session.Owner != owner {
+			continue
+		}
+		if s.expired(session) {
+			s.remove(key)
+			continue
+		}
+		result = append(result, sessionSummary{
+			ID:        session.ID,
+			Model:     session.Model,
+			Messages:  len(session.Messages),
+			CreatedAt: session.CreatedAt,
+			UpdatedAt: session.UpdatedAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].UpdatedAt > result[j].UpdatedAt
+	})
+	return result
+}
+
+// evict 删除调用方过期的会话，以及超出数量上限的最久未更新的会话，需要持有锁
+func (s *sessionStore) evict(owner string) {
+	var keys []string
+	for key, session := range s.sessions {
+		if session.Owner != owner {
+			continue
+		}
+		if s.expired(session) {
+			s.remove(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) <= s.config.MaxSessions {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return s.sessions[keys[i]].UpdatedAt < s.sessions[keys[j]].UpdatedAt
+	})
+	for _, key := range keys[:len(keys)-s.config.MaxSessions] {
+		s.remove(key)
+	}
+}
+
+// save 持久化会话，需要持有锁
+func (s *sessionStore) save(key string, session *chatSession) {
+	if s.config.Dir == "" {
+		return
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(s.config.Dir, key+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// remove 删除会话及其持久化文件，需要持有锁
+func (s *sessionStore) remove(key string) {
+	delete(s.sessions, key)
+	if s.config.Dir != "" {
+		os.Remove(filepath.Join(s.config.Dir, key+".json"))
+	}
+}
+
+// trimSessionMessages 消息数超出上限时删除最早的非system消息
+func trimSessionMessages(messages []models.ChatMessage, maxMessages int) []models.ChatMessage {
+	excess := len(messages) - maxMessages
+	if excess <= 0 {
+		return messages
+	}
+	result := make([]models.ChatMessage, 0, maxMessages)
+	for _, message := range messages {
+		if excess > 0 && message.Role != "system" {
+			excess--
+			continue
+		}
+		result = append(result, message)
+	}
+	return result
+}
+
+// sessionOwner 返回会话所属的调用方，使用身份的摘要，避免在会话文件中保存token
+func sessionOwner(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(c.GetString(identityKey)))
+	return hex.EncodeToString(sum[:])
+}
+
+// requireSessions 会话功能未启用时返回错误
+func requireSessions(c *gin.Context) bool {
+	if sessions == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"error": "会话功能未启用",
+		})
+		return false
+	}
+	return true
+}
+
+// handleListSessions 列出调用方的所有会话
+func handleListSessions(c *gin.Context) {
+	if !requireSessions(c) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   sessions.list(sessionOwner(c)),
+	})
+}
+
+// handleGetSession 查询会话的完整历史
+func handleGetSession(c *gin.Context) {
+	if !requireSessions(c) {
+		return
+	}
+	session := sessions.get(sessionOwner(c), c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "会话不存在",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, session)
+}
+
+// handleForkSession 复制会话，可以只保留前若干条消息，用于从历史中的某一轮重新开始
+func handleForkSession(c *gin.Context) {
+	if !requireSessions(c) {
+		return
+	}
+	var req struct {
+		// ID 新会话的ID，为空时随机生成
+		ID string `json:"id"`
+		// Messages 保留的消息数，0表示保留全部
+		Messages int `json:"messages"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	owner := sessionOwner(c)
+	session := sessions.get(owner, c.Param("id"))
+	if session == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "会话不存在",
+		})
+		return
+	}
+
+	if req.ID == "" {
+		req.ID = models.NewID(sessionIDPrefix)
+	}
+	if sessions.get(owner, req.ID) != nil {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "会话已存在",
+		})
+		return
+	}
+	if req.Messages > 0 && req.Messages < len(session.Messages) {
+		session.Messages = session.Messages[:req.Messages]
+	}
+	now := time.Now().Unix()
+	session.ID = req.ID
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	sessions.put(session)
+	c.JSON(http.StatusOK, session)
+}
+
+// handleDeleteSession 删除会话
+func handleDeleteSession(c *gin.Context) {
+	if !requireSessions(c) {
+		return
+	}
+	id := c.Param("id")
+	if !sessions.delete(sessionOwner(c), id) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "会话不存在",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"deleted": true,
+	})
+}
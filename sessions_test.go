@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/douguohai/ollama-proxy/models"
+)
+
+// withSessions 使用指定配置启用服务端会话，测试结束后恢复
+func withSessions(t *testing.T, cfg SessionConfig) {
+	t.Helper()
+	previous := sessions
+	t.Cleanup(func() { sessions = previous })
+	cfg.Enabled = true
+	if err := initSessionStore(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// doSessionChat 在指定会话中发送一条消息
+func doSessionChat(t *testing.T, proxy string, token, session, content string, stream bool) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, proxy+"/v1/chat/completions", strings.NewReader(fmt.Sprintf(
+		`{"model":"qwen2.5:7b","stream":%v,"messages":[{"role":"user","content":%q}]}`, stream, content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(sessionHeader, session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// sentContents 返回Ollama收到的最后一个chat请求中的消息内容
+func sentContents(t *testing.T, fake *fakeOllama) []string {
+	t.Helper()
+	requests := fake.received("/api/chat")
+	if len(requests) == 0 {
+		t.Fatal("Ollama没有收到chat请求")
+	}
+	var contents []string
+	messages, _ := requests[len(requests)-1].Body["messages"].([]interface{})
+	for _, message := range messages {
+		fields, _ := message.(map[string]interface{})
+		contents = append(contents, fmt.Sprint(fields["content"]))
+	}
+	return contents
+}
+
+func TestTrimSessionMessages(t *testing.T) {
+	messages := []models.ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "1"},
+		{Role: "assistant", Content: "2"},
+		{Role: "user", Content: "3"},
+	}
+	got := trimSessionMessages(messages, 2)
+	if len(got) != 2 || got[0].Role != "system" || got[1].Content != "3" {
+		t.Errorf("got = %+v, 应保留system消息和最新的消息", got)
+	}
+	if got := trimSessionMessages(messages, 10); len(got) != 4 {
+		t.Errorf("未超出上限时不应删除消息, got %d", len(got))
+	}
+}
+
+func TestSessionHistory(t *testing.T) {
+	fake := newFakeOllama(t)
+	withSessions(t, SessionConfig{})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	doSessionChat(t, proxy.URL, testToken, "s1", "hi", false)
+	// 流式响应结束后同样保存回复
+	readSSE(t, doSessionChat(t, proxy.URL, testToken, "s1", "again", true).Body)
+	doSessionChat(t, proxy.URL, testToken, "s1", "third", false)
+
+	want := []string{"hi", "echo: hi", "again", "echo: again", "third"}
+	if got := sentContents(t, fake); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("发送给Ollama的消息 = %q, want %q", got, want)
+	}
+
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/sessions/s1", ""))
+	if messages, _ := result["messages"].([]interface{}); len(messages) != 6 {
+		t.Errorf("会话有 %d 条消息, want 6", len(messages))
+	}
+}
+
+func TestSessionIsolatedPerCaller(t *testing.T) {
+	fake := newFakeOllama(t)
+	withSessions(t, SessionConfig{})
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q, "other-token"]
+service:
+  base_url: %q
+`, testToken, fake.URL()))
+
+	doSessionChat(t, proxy.URL, testToken, "shared-id", "secret", false)
+	doSessionChat(t, proxy.URL, "other-token", "shared-id", "hi", false)
+	if got := sentContents(t, fake); len(got) != 1 || got[0] != "hi" {
+		t.Errorf("其他调用方不应看到会话历史, got %q", got)
+	}
+
+	resp := doRequestAs(t, proxy.URL, "other-token", http.MethodGet, "/sessions", "")
+	if data, _ := decodeJSON(t, resp)["data"].([]interface{}); len(data) != 1 {
+		t.Errorf("other-token有 %d 个会话, want 1", len(data))
+	}
+}
+
+func TestSessionForkAndDelete(t *testing.T) {
+	fake := newFakeOllama(t)
+	withSessions(t, SessionConfig{})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	doSessionChat(t, proxy.URL, testToken, "base", "hi", false)
+	doSessionChat(t, proxy.URL, testToken, "base", "again", false)
+
+	// 只保留第一轮对话
+	result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/sessions/base/fork", `{"id":"branch","messages":2}`))
+	if messages, _ := result["messages"].([]interface{}); result["id"] != "branch" || len(messages) != 2 {
+		t.Errorf("fork = %v", result)
+	}
+	if resp := doRequest(t, proxy, http.MethodPost, "/sessions/base/fork", `{"id":"branch"}`); resp.StatusCode != http.StatusConflict {
+		t.Errorf("目标会话已存在时 status = %d, want 409", resp.StatusCode)
+	}
+	if result := decodeJSON(t, doRequest(t, proxy, http.MethodPost, "/sessions/base/fork", "")); !strings.HasPrefix(fmt.Sprint(result["id"]), sessionIDPrefix) {
+		t.Errorf("未指定ID时应随机生成, got %v", result["id"])
+	}
+
+	doSessionChat(t, proxy.URL, testToken, "branch", "other", false)
+	want := []string{"hi", "echo: hi", "other"}
+	if got := sentContents(t, fake); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("发送给Ollama的消息 = %q, want %q", got, want)
+	}
+
+	if result := decodeJSON(t, doRequest(t, proxy, http.MethodDelete, "/sessions/base", "")); result["deleted"] != true {
+		t.Errorf("delete = %v", result)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if resp := doRequest(t, proxy, method, "/sessions/base", ""); resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s 已删除的会话 status = %d, want 404", method, resp.StatusCode)
+		}
+	}
+}
+
+func TestSessionEvictAndPersist(t *testing.T) {
+	fake := newFakeOllama(t)
+	dir := t.TempDir()
+	withSessions(t, SessionConfig{Dir: dir, MaxSessions: 1})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	doSessionChat(t, proxy.URL, testToken, "old", "hi", false)
+	// 保证两个会话的更新时间不同
+	sessions.mu.Lock()
+	for _, session := range sessions.sessions {
+		session.UpdatedAt--
+	}
+	sessions.mu.Unlock()
+	doSessionChat(t, proxy.URL, testToken, "new", "hi", false)
+
+	// 重新加载持久化的会话，超出数量上限的会话已被删除
+	if err := initSessionStore(SessionConfig{Enabled: true, Dir: dir, MaxSessions: 1}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/sessions", ""))["data"].([]interface{})
+	if len(data) != 1 {
+		t.Fatalf("有 %d 个会话, want 1", len(data))
+	}
+	if summary, _ := data[0].(map[string]interface{}); summary["id"] != "new" || summary["messages"] != float64(2) {
+		t.Errorf("会话 = %v", summary)
+	}
+}
+
+func TestSessionsDisabled(t *testing.T) {
+	fake := newFakeOllama(t)
+	previous := sessions
+	sessions = nil
+	t.Cleanup(func() { sessions = previous })
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	if result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/sessions", "")); result["error"] != "会话功能未启用" {
+		t.Errorf("result = %v", result)
+	}
+	resp := doSessionChat(t, proxy.URL, testToken, "s1", "hi", false)
+	if resp.Header.Get(sessionHeader) != "" {
+		t.Errorf("未启用会话时不应返回 %s", sessionHeader)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// sseDone OpenAI流式响应的结束标记
+const sseDone = "[DONE]"
+
+// writeSSEData 写入一条只包含data字段的SSE事件，OpenAI客户端只解析不带event字段的data行
+func writeSSEData(w io.Writer, data []byte) {
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}
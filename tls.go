@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ServerTLSConfig 服务端TLS配置
+type ServerTLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// SelfSigned 证书文件不存在时自动生成自签名证书，仅用于开发环境
+	SelfSigned bool `yaml:"self_signed"`
+	// ClientCAFile 用于校验客户端证书的CA文件，配置后启用mTLS
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth 客户端证书校验方式：request（提供则校验）/ require（必须提供）
+	ClientAuth string `yaml:"client_auth"`
+	// HealthcheckCertFile、HealthcheckKeyFile healthcheck命令使用的客户端证书，client_auth为require时需要配置
+	HealthcheckCertFile string `yaml:"healthcheck_cert_file"`
+	HealthcheckKeyFile  string `yaml:"healthcheck_key_file"`
+}
+
+// UpstreamTLSConfig 访问HTTPS上游服务时使用的TLS配置
+type UpstreamTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ClientCertIdentity 客户端证书与身份的映射
+type ClientCertIdentity struct {
+	// Name 身份名称，用于日志记录
+	Name string `yaml:"name"`
+	// Subject 匹配证书Subject中的CN
+	Subject string `yaml:"subject"`
+	// SANs 匹配证书中的DNS、Email、URI或IP类型的SAN
+	SANs []string `yaml:"sans"`
+	// Scope 与token相同的权限范围：generate / model，默认为generate
+	Scope string `yaml:"scope"`
+}
+
+// certCheckInterval 检查证书文件是否变更的最小间隔
+const certCheckInterval = 10 * time.Second
+
+// certReloader 在证书文件变更后自动重新加载证书
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load 在文件修改时间变化时重新读取证书
+func (r *certReloader) load() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert != nil && time.Since(r.checkedAt) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// 文件暂时不可读时继续使用旧证书
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// 证书与私钥可能正在替换中，保留旧证书等待下次检查
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return r.cert, nil
+}
+
+// GetCertificate 供服务端tls.Config使用
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.load()
+}
+
+// GetClientCertificate 供上游客户端tls.Config使用
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return r.load()
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// buildServerTLSConfig 根据配置构造服务端TLS配置
+func buildServerTLSConfig(cfg ServerTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		if !cfg.SelfSigned {
+			return nil, fmt.Errorf("启用TLS需要配置cert_file和key_file，或开启self_signed")
+		}
+		// 未指定证书路径时仅在内存中生成自签名证书
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else {
+		if _, err := os.Stat(cfg.CertFile); os.IsNotExist(err) && cfg.SelfSigned {
+			if err := writeSelfSignedCert(cfg.CertFile, cfg.KeyFile); err != nil {
+				return nil, err
+			}
+		}
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = reloader.GetCertificate
+	}
+
+	if cfg.ClientCAFile != "" {
+		pool, err := loadCertPool(cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		switch strings.ToLower(cfg.ClientAuth) {
+		case "require":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case "", "request":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("不支持的client_auth: %s", cfg.ClientAuth)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("无法解析CA证书: %s", file)
+	}
+	return pool, nil
+}
+
+// generateSelfSignedCert 生成开发用的自签名证书
+func generateSelfSignedCert() (tls.Certificate, error) {
+	certPEM, keyPEM, err := selfSignedPEM()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// writeSelfSignedCert 生成自签名证书并写入指定路径
+func writeSelfSignedCert(certFile, keyFile string) error {
+	certPEM, keyPEM, err := selfSignedPEM()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(keyFile, keyPEM, 0600)
+}
+
+func selfSignedPEM() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "ollama-proxy", Organization: []string{"ollama-proxy development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// identityFromCert 根据客户端证书查找对应的身份
+func identityFromCert(identities []ClientCertIdentity, cert *x509.Certificate) *ClientCertIdentity {
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+
+	for i := range identities {
+		identity := &identities[i]
+		if identity.Subject != "" && identity.Subject == cert.Subject.CommonName {
+			return identity
+		}
+		for _, want := range identity.SANs {
+			for _, san := range sans {
+				if strings.EqualFold(want, san) {
+					return identity
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// upstreamClients 按TLS配置缓存上游HTTP客户端，复用连接
+var upstreamClients sync.Map
+
+// upstreamClient 返回访问上游服务的HTTP客户端
+func upstreamClient(cfg UpstreamTLSConfig) (*http.Client, error) {
+	if client, ok := upstreamClients.Load(cfg); ok {
+		return client.(*http.Client), nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		reloader, err := newCertReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	client, _ := upstreamClients.LoadOrStore(cfg, &http.Client{Transport: transport})
+	return client.(*http.Client), nil
+}
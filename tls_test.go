@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的CA，用于签发客户端证书和服务端证书
+type testCA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// File CA证书文件路径
+	File string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.File = ca.write("ca.crt", "CERTIFICATE", der)
+	return ca
+}
+
+// issue 签发客户端证书，返回证书和私钥文件路径
+func (ca *testCA) issue(cn string, emails ...string) (string, string) {
+	ca.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: cn},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		EmailAddresses: emails,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		ca.t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return ca.write(cn+".crt", "CERTIFICATE", der), ca.write(cn+".key", "EC PRIVATE KEY", keyDER)
+}
+
+// client 返回携带新签发的客户端证书的HTTP客户端
+func (ca *testCA) client(cn string) *http.Client {
+	certFile, keyFile := ca.issue(cn)
+	return tlsClient(ca.t, certFile, keyFile)
+}
+
+func (ca *testCA) write(name, blockType string, der []byte) string {
+	path := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		ca.t.Fatal(err)
+	}
+	return path
+}
+
+// newTLSProxy 使用服务端TLS配置启动代理，服务端证书为内存中的自签名证书
+func newTLSProxy(t *testing.T, config string) *httptest.Server {
+	t.Helper()
+	cfg := writeTestConfig(t, config)
+	tlsConfig, err := buildServerTLSConfig(cfg.Server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewUnstartedServer(newRouter(cfg))
+	proxy.TLS = tlsConfig
+	proxy.StartTLS()
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+// tlsClient 返回携带客户端证书的HTTP客户端，不校验代理的自签名证书
+func tlsClient(t *testing.T, certFile, keyFile string) *http.Client {
+	t.Helper()
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+func TestIdentityFromCert(t *testing.T) {
+	identities := []ClientCertIdentity{
+		{Name: "ci", Subject: "ci-runner"},
+		{Name: "alice", SANs: []string{"Alice@Example.com"}},
+		{Name: "svc", SANs: []string{"spiffe://cluster/ns/default/sa/app"}},
+	}
+	spiffe, _ := url.Parse("spiffe://cluster/ns/default/sa/app")
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want string
+	}{
+		{name: "CN", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "ci-runner"}}, want: "ci"},
+		{name: "Email不区分大小写", cert: &x509.Certificate{EmailAddresses: []string{"alice@example.com"}}, want: "alice"},
+		{name: "URI", cert: &x509.Certificate{URIs: []*url.URL{spiffe}}, want: "svc"},
+		{name: "未配置", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ""
+			if identity := identityFromCert(identities, tt.cert); identity != nil {
+				got = identity.Name
+			}
+			if got != tt.want {
+				t.Errorf("identity = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildServerTLSConfigErrors(t *testing.T) {
+	if _, err := buildServerTLSConfig(ServerTLSConfig{Enabled: true}); err == nil {
+		t.Errorf("没有证书且未开启self_signed时应返回错误")
+	}
+	ca := newTestCA(t)
+	if _, err := buildServerTLSConfig(ServerTLSConfig{Enabled: true, SelfSigned: true, ClientCAFile: ca.File, ClientAuth: "always"}); err == nil {
+		t.Errorf("不支持的client_auth应返回错误")
+	}
+}
+
+func TestMTLSClientIdentity(t *testing.T) {
+	fake := newFakeOllama(t)
+	ca := newTestCA(t)
+	proxy := newTLSProxy(t, fmt.Sprintf(`server:
+  tls:
+    enabled: true
+    self_signed: true
+    client_ca_file: %q
+auth:
+  generate_tokens: [%q]
+  client_certs:
+    - name: ci
+      subject: ci-runner
+    - name: viewer
+      subject: viewer
+      scope: model
+service:
+  base_url: %q
+`, ca.File, testToken, fake.URL()))
+
+	get := func(client *http.Client) map[string]interface{} {
+		t.Helper()
+		resp, err := client.Get(proxy.URL + "/api/tags")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		return decodeJSON(t, resp)
+	}
+
+	// 证书映射到generate权限的身份时不需要token
+	if result := get(ca.client("ci-runner")); result["models"] == nil {
+		t.Errorf("ci-runner应通过认证: %v", result)
+	}
+	// model权限的身份不能访问生成接口
+	if result := get(ca.client("viewer")); result["error"] != "非授权访问" {
+		t.Errorf("viewer应被拒绝: %v", result)
+	}
+	// 未配置身份的证书与没有证书一样需要token
+	if result := get(ca.client("mallory")); result["error"] != "未提供认证token" {
+		t.Errorf("mallory应被拒绝: %v", result)
+	}
+	if result := get(tlsClient(t, "", "")); result["error"] != "未提供认证token" {
+		t.Errorf("没有证书时应要求token: %v", result)
+	}
+	if got := len(fake.received("/api/tags")); got != 1 {
+		t.Errorf("Ollama收到 %d 个请求, want 1", got)
+	}
+}
+
+func TestHTTPSUpstreamWithCA(t *testing.T) {
+	fake := newFakeOllama(t)
+	upstream := httptest.NewTLSServer(fake)
+	t.Cleanup(upstream.Close)
+	caFile := filepath.Join(t.TempDir(), "upstream-ca.crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := newTestProxy(t, testConfig(upstream.URL)+fmt.Sprintf("  tls:\n    ca_file: %q\n", caFile))
+	if result := decodeJSON(t, doRequest(t, proxy, http.MethodGet, "/api/tags", "")); result["models"] == nil {
+		t.Errorf("使用ca_file应能访问HTTPS上游: %v", result)
+	}
+
+	// 不信任上游证书时连接失败
+	proxy = newTestProxy(t, testConfig(upstream.URL))
+	if resp := doRequest(t, proxy, http.MethodGet, "/api/tags", ""); resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+}
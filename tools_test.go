@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+const weatherTool = `{"type":"function","function":{"name":"get_weather","parameters":{"type":"object","properties":{"city":{"type":"string"}}}}}`
+
+func TestOpenAIChatFinishReasonLength(t *testing.T) {
+	fake := newFakeOllama(t)
+	line := map[string]interface{}{}
+	json.Unmarshal([]byte(chatLine("qwen2.5:7b", "one two three", true)), &line)
+	line["done_reason"] = "length"
+	fake.script("/api/chat", jsonReply(line))
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"max_tokens":3,"messages":[{"role":"user","content":"count"}]}`)
+	choices, _ := decodeJSON(t, resp)["choices"].([]interface{})
+	if reason := choices[0].(map[string]interface{})["finish_reason"]; reason != "length" {
+		t.Errorf("finish_reason = %v, want length", reason)
+	}
+	options, _ := fake.received("/api/chat")[0].Body["options"].(map[string]interface{})
+	if options["num_predict"] != float64(3) {
+		t.Errorf("max_tokens应转换为num_predict: %v", options)
+	}
+}
+
+func TestOpenAIChatStreamToolCalls(t *testing.T) {
+	fake := newFakeOllama(t)
+	call := map[string]interface{}{}
+	json.Unmarshal([]byte(chatLine("qwen2.5:7b", "", false)), &call)
+	call["message"].(map[string]interface{})["tool_calls"] = []interface{}{
+		map[string]interface{}{"function": map[string]interface{}{"name": "get_weather", "arguments": map[string]interface{}{"city": "北京"}}},
+	}
+	// 工具调用出现在结束前的分块中，最后一个分块的done_reason为stop
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{{Line: mustJSON(call)}, {Line: chatLine("qwen2.5:7b", "", true)}}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"tools":[`+weatherTool+`],"messages":[{"role":"user","content":"北京天气"}]}`)
+	var chunks []map[string]interface{}
+	for _, event := range readSSE(t, resp.Body) {
+		if event.Data == sseDone {
+			continue
+		}
+		var chunk map[string]interface{}
+		json.Unmarshal([]byte(event.Data), &chunk)
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("chunks = %v", chunks)
+	}
+	first := chunks[0]["choices"].([]interface{})[0].(map[string]interface{})
+	calls, _ := first["delta"].(map[string]interface{})["tool_calls"].([]interface{})
+	if len(calls) != 1 {
+		t.Fatalf("delta = %v", first["delta"])
+	}
+	function := calls[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "get_weather" || function["arguments"] != `{"city":"北京"}` || calls[0].(map[string]interface{})["index"] != float64(0) {
+		t.Errorf("tool_calls = %v", calls)
+	}
+	last := chunks[1]["choices"].([]interface{})[0].(map[string]interface{})
+	if last["finish_reason"] != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", last["finish_reason"])
+	}
+
+	tools, _ := fake.received("/api/chat")[0].Body["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Errorf("工具定义应转发给Ollama: %v", tools)
+	}
+}
+
+func TestOpenAIChatToolChoiceNone(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":false,"tool_choice":"none","tools":[`+weatherTool+`],"messages":[{"role":"user","content":"hi"}]}`)
+	choices, _ := decodeJSON(t, resp)["choices"].([]interface{})
+	if reason := choices[0].(map[string]interface{})["finish_reason"]; reason != "stop" {
+		t.Errorf("finish_reason = %v, want stop", reason)
+	}
+	if _, ok := fake.received("/api/chat")[0].Body["tools"]; ok {
+		t.Errorf("tool_choice为none时不应向模型提供工具")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TrafficConfig 上游流量录制与回放配置
+type TrafficConfig struct {
+	// Mode 为record时录制所有上游请求和响应，为replay时使用录制的响应代替上游服务，为空时不启用
+	Mode string `yaml:"mode"`
+	// Dir 录制文件目录，默认为fixtures
+	Dir string `yaml:"dir"`
+	// Realtime 回放时是否按录制时的间隔返回响应和流式分块，默认立即返回
+	Realtime bool `yaml:"realtime"`
+}
+
+const (
+	trafficRecord = "record"
+	trafficReplay = "replay"
+
+	defaultTrafficDir = "fixtures"
+)
+
+// trafficFixture 一次录制的上游请求和响应
+type trafficFixture struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Request 发送给上游的请求体
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status"`
+	Header  http.Header     `json:"header"`
+	// LatencyMS 从发出请求到收到响应头的时间
+	LatencyMS int64 `json:"latency_ms"`
+	// Chunks 按到达顺序记录的响应体分块
+	Chunks []trafficChunk `json:"chunks"`
+	// Error 读取响应体时出现的错误，例如上游中途断开连接
+	Error      string    `json:"error,omitempty"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// trafficChunk 响应体的一个分块
+type trafficChunk struct {
+	// DelayMS 距离上一个分块（第一个分块为响应头）的时间
+	DelayMS int64  `json:"delay_ms"`
+	Data    string `json:"data"`
+}
+
+// recordedHeaders 需要录制的响应头
+var recordedHeaders = []string{"Content-Type"}
+
+// trafficDir 返回录制文件目录
+func trafficDir(cfg TrafficConfig) string {
+	if cfg.Dir != "" {
+		return cfg.Dir
+	}
+	return defaultTrafficDir
+}
+
+// fixturePath 计算请求对应的录制文件，与后端地址无关，录制文件可以在不同环境中使用
+func fixturePath(cfg TrafficConfig, method, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method))
+	hash.Write([]byte{0})
+	hash.Write([]byte(path))
+	hash.Write([]byte{0})
+	hash.Write(normalizeBody(body))
+	name := method + "_" + strings.ReplaceAll(strings.Trim(path, "/"), "/", "_") + "_" + hex.EncodeToString(hash.Sum(nil))[:16] + ".json"
+	return filepath.Join(trafficDir(cfg), name)
+}
+
+// normalizeBody 将JSON请求体重新序列化，使字段顺序不影响匹配
+func normalizeBody(body []byte) []byte {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body
+	}
+	normalized, err := json.Marshal(value)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+// recordExchange 发送请求并在读取完响应体后保存录制文件
+func recordExchange(cfg TrafficConfig, client *http.Client, req *http.Request, body []byte) (*http.Response, error) {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := &trafficFixture{
+		Method:     req.Method,
+		Path:       req.URL.Path,
+		Status:     resp.StatusCode,
+		Header:     http.Header{},
+		LatencyMS:  time.Since(start).Milliseconds(),
+		RecordedAt: start,
+	}
+	if json.Valid(body) {
+		fixture.Request = normalizeBody(body)
+	}
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			fixture.Header.Set(name, value)
+		}
+	}
+	resp.Body = &recordingBody{
+		ReadCloser: resp.Body,
+		fixture:    fixture,
+		path:       fixturePath(cfg, req.Method, req.URL.Path, body),
+		last:       time.Now(),
+	}
+	return resp, nil
+}
+
+// recordingBody 在读取响应体的同时记录每个分块，读取结束、出错或关闭时保存
+// 调用方提前关闭时只保存已经读取的分块，回放时调用方读取到的内容与录制时一致
+type recordingBody struct {
+	io.ReadCloser
+	fixture *trafficFixture
+	path    string
+	last    time.Time
+	once    sync.Once
+}
+
+func (b *recordingBody) Close() error {
+	b.once.Do(b.save)
+	return b.ReadCloser.Close()
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		now := time.Now()
+		b.fixture.Chunks = append(b.fixture.Chunks, trafficChunk{
+			DelayMS: now.Sub(b.last).Milliseconds(),
+			Data:    string(p[:n]),
+		})
+		b.last = now
+	}
+	if err != nil {
+		if err != io.EOF {
+			b.fixture.Error = err.Error()
+		}
+		b.once.Do(b.save)
+	}
+	return n, err
+}
+
+// save 保存录制文件，先写入临时文件再重命名，避免回放时读取到不完整的文件
+func (b *recordingBody) save() {
+	data, err := json.MarshalIndent(b.fixture, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		logger.LogRequest(b.fixture.Method, b.fixture.Path, nil, nil, err, "", false)
+		return
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		logger.LogRequest(b.fixture.Method, b.fixture.Path, nil, nil, err, "", false)
+		return
+	}
+	os.Rename(tmp, b.path)
+}
+
+// replayExchange 返回录制的响应，不访问上游服务
+func replayExchange(ctx context.Context, cfg TrafficConfig, req *http.Request, body []byte) (*http.Response, error) {
+	path := fixturePath(cfg, req.Method, req.URL.Path, body)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("回放模式下没有找到录制的响应: %s %s", req.Method, req.URL.Path)
+	}
+	var fixture trafficFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("录制文件 %s 无效: %v", path, err)
+	}
+
+	if cfg.Realtime {
+		if err := sleepContext(ctx, time.Duration(fixture.LatencyMS)*time.Millisecond); err != nil {
+			return nil, err
+		}
+	}
+	header := fixture.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", fixture.Status, http.StatusText(fixture.Status)),
+		StatusCode: fixture.Status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       &replayBody{ctx: ctx, fixture: fixture, realtime: cfg.Realtime},
+		Request:    req,
+	}, nil
+}
+
+// replayBody 按录制的分块返回响应体，录制时出现的读取错误在所有分块之后返回
+type replayBody struct {
+	ctx      context.Context
+	fixture  trafficFixture
+	realtime bool
+	next     int
+	pending  bytes.Reader
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	for b.pending.Len() == 0 {
+		if b.next >= len(b.fixture.Chunks) {
+			if b.fixture.Error != "" {
+				return 0, errors.New(b.fixture.Error)
+			}
+			return 0, io.EOF
+		}
+		chunk := b.fixture.Chunks[b.next]
+		b.next++
+		if b.realtime {
+			if err := sleepContext(b.ctx, time.Duration(chunk.DelayMS)*time.Millisecond); err != nil {
+				return 0, err
+			}
+		}
+		b.pending.Reset([]byte(chunk.Data))
+	}
+	return b.pending.Read(p)
+}
+
+func (b *replayBody) Close() error {
+	return nil
+}
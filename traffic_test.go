@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trafficConfig 返回启用流量录制或回放的测试配置
+func trafficConfig(upstream, mode, dir string, realtime bool) string {
+	return testConfig(upstream) + fmt.Sprintf("  traffic:\n    mode: %s\n    dir: %q\n    realtime: %v\n", mode, dir, realtime)
+}
+
+// streamContent 发送流式聊天请求，返回拼接后的内容和所有错误事件
+func streamContent(t *testing.T, config string) (string, []string) {
+	t.Helper()
+	server := newTestProxy(t, config)
+	resp := doRequest(t, server, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	var content strings.Builder
+	var errs []string
+	for _, event := range readSSE(t, resp.Body) {
+		if event.Event == "error" {
+			errs = append(errs, event.Data)
+			continue
+		}
+		var chunk streamChunk
+		if json.Unmarshal([]byte(event.Data), &chunk) == nil && len(chunk.Choices) > 0 {
+			content.WriteString(chunk.Choices[0].Delta.Content)
+		}
+	}
+	return content.String(), errs
+}
+
+func TestTrafficRecordAndReplay(t *testing.T) {
+	const delay = 150 * time.Millisecond
+	dir := t.TempDir()
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "Hello", false)},
+		{Line: `{"message":`},
+		{Line: chatLine("qwen2.5:7b", " world", false), Delay: delay},
+		{Line: chatLine("qwen2.5:7b", "", true)},
+	}})
+
+	recorded, errs := streamContent(t, trafficConfig(fake.URL(), trafficRecord, dir, false))
+	if recorded != "Hello world" || len(errs) != 0 {
+		t.Fatalf("录制时的响应 = %q, errors = %v", recorded, errs)
+	}
+
+	// 录制文件记录了流式分块之间的间隔
+	files, _ := filepath.Glob(filepath.Join(dir, "POST_api_chat_*.json"))
+	if len(files) != 1 {
+		t.Fatalf("录制文件 = %v, want 1 file", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture trafficFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	var maxDelay int64
+	for _, chunk := range fixture.Chunks {
+		if chunk.DelayMS > maxDelay {
+			maxDelay = chunk.DelayMS
+		}
+	}
+	if fixture.Status != http.StatusOK || maxDelay < delay.Milliseconds()/2 {
+		t.Errorf("status = %d, 最大分块间隔 = %dms", fixture.Status, maxDelay)
+	}
+
+	// 回放时不访问上游服务
+	fake.server.Close()
+	start := time.Now()
+	replayed, errs := streamContent(t, trafficConfig(fake.URL(), trafficReplay, dir, false))
+	if replayed != recorded || len(errs) != 0 {
+		t.Errorf("回放的响应 = %q, errors = %v, want %q", replayed, errs, recorded)
+	}
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("未启用realtime时回放耗时 %v", elapsed)
+	}
+
+	start = time.Now()
+	replayed, _ = streamContent(t, trafficConfig(fake.URL(), trafficReplay, dir, true))
+	if elapsed := time.Since(start); replayed != recorded || elapsed < delay/2 {
+		t.Errorf("realtime回放的响应 = %q, 耗时 %v", replayed, elapsed)
+	}
+}
+
+func TestTrafficReplayDisconnect(t *testing.T) {
+	dir := t.TempDir()
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Chunks: []fakeChunk{
+		{Line: chatLine("qwen2.5:7b", "partial", false)},
+		{Disconnect: true},
+	}})
+
+	_, errs := streamContent(t, trafficConfig(fake.URL(), trafficRecord, dir, false))
+	if len(errs) != 1 {
+		t.Fatalf("录制时应返回上游断开的错误: %v", errs)
+	}
+
+	fake.server.Close()
+	content, replayedErrs := streamContent(t, trafficConfig(fake.URL(), trafficReplay, dir, false))
+	if content != "partial" || len(replayedErrs) != 1 {
+		t.Errorf("回放的响应 = %q, errors = %v", content, replayedErrs)
+	}
+}
+
+func TestTrafficReplayMissing(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, trafficConfig(fake.URL(), trafficReplay, t.TempDir(), false))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","messages":[{"role":"user","content":"hi"}]}`)
+	if got, _ := decodeJSON(t, resp)["error"].(string); !strings.Contains(got, "没有找到录制的响应") {
+		t.Errorf("error = %q", got)
+	}
+	if len(fake.received("/api/chat")) != 0 {
+		t.Errorf("回放模式不应访问上游服务")
+	}
+}
+
+func TestTrafficReplayFanOut(t *testing.T) {
+	dir := t.TempDir()
+	fake := newFakeOllama(t)
+	body := `{"model":"qwen2.5:7b","n":2,"stream":false,"messages":[{"role":"user","content":"hi"}]}`
+	contents := func(config string) []string {
+		t.Helper()
+		result := decodeJSON(t, doRequest(t, newTestProxy(t, config), http.MethodPost, "/v1/chat/completions", body))
+		choices, _ := result["choices"].([]interface{})
+		var contents []string
+		for _, choice := range choices {
+			message, _ := choice.(map[string]interface{})["message"].(map[string]interface{})
+			contents = append(contents, fmt.Sprint(message["content"]))
+		}
+		if len(contents) != 2 {
+			t.Fatalf("响应 = %v, want 2 choices", result)
+		}
+		return contents
+	}
+
+	recorded := contents(trafficConfig(fake.URL(), trafficRecord, dir, false))
+	if files, _ := filepath.Glob(filepath.Join(dir, "POST_api_chat_*.json")); len(files) != 2 {
+		t.Fatalf("录制文件 = %v, want 2 files", files)
+	}
+
+	// 每一路使用固定的seed，回放时可以找到录制的响应
+	fake.server.Close()
+	replayed := contents(trafficConfig(fake.URL(), trafficReplay, dir, false))
+	if strings.Join(replayed, "|") != strings.Join(recorded, "|") {
+		t.Errorf("回放的响应 = %q, want %q", replayed, recorded)
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BackendConfig 上游后端配置
+type BackendConfig struct {
+	Name    string `yaml:"name"`
+	BaseURL string `yaml:"base_url"`
+	// TLS 未配置时使用service.tls
+	TLS *UpstreamTLSConfig `yaml:"tls"`
+	// Auth 未配置时使用service.auth
+	Auth *UpstreamAuthConfig `yaml:"auth"`
+	// Type 后端类型：ollama（默认）/ openai，openai类型的后端只支持OpenAI兼容接口，例如vLLM、llama.cpp server
+	Type string `yaml:"type"`
+	// Models 后端提供的模型，配置后这些模型的请求只发送到列出它们的后端
+	Models []string `yaml:"models"`
+}
+
+// RetryConfig 上游请求重试配置
+type RetryConfig struct {
+	// MaxAttempts 每个后端的最大尝试次数，默认为1（不重试）
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+}
+
+// CircuitBreakerConfig 熔断配置
+type CircuitBreakerConfig struct {
+	// FailureThreshold 连续失败多少次后熔断，0表示不启用
+	FailureThreshold int `yaml:"failure_threshold"`
+	// Cooldown 熔断后等待多久进入半开状态
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+const (
+	defaultBaseURL        = "http://localhost:11434"
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultCooldown       = 30 * time.Second
+)
+
+// idempotentPaths 可以在收到5xx后安全重试的接口
+var idempotentPaths = map[string]bool{
+	"/api/chat":       true,
+	"/api/generate":   true,
+	"/api/embed":      true,
+	"/api/embeddings": true,
+	"/api/tags":       true,
+	"/api/show":       true,
+	"/api/ps":         true,
+	"/api/version":    true,
+}
+
+// upstreamBackends 返回按优先级排列的后端列表，第一个为主后端
+func upstreamBackends(config *Config) []BackendConfig {
+	if len(config.Service.Backends) > 0 {
+		return config.Service.Backends
+	}
+	baseURL := defaultBaseURL
+	if config.Service.BaseURL != "" {
+		baseURL = config.Service.BaseURL
+	}
+	return []BackendConfig{{Name: "default", BaseURL: baseURL}}
+}
+
+// backendTLS 返回后端使用的TLS配置
+func backendTLS(config *Config, backend BackendConfig) UpstreamTLSConfig {
+	if backend.TLS != nil {
+		return *backend.TLS
+	}
+	return config.Service.TLS
+}
+
+// doUpstream 发送请求到上游服务，按配置进行重试、熔断和故障转移
+// 只有在尚未收到响应时才会重试，已经开始返回内容的流不会被重试
+func doUpstream(ctx context.Context, config *Config, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	backends, err := routeBackends(config, requestModel(body))
+	if err != nil {
+		return nil, err
+	}
+	return doBackends(ctx, config, backends, method, path, body, header)
+}
+
+// doBackends 按顺序将请求发送到指定的后端
+func doBackends(ctx context.Context, config *Config, backends []BackendConfig, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	retry := config.Service.Retry
+	maxAttempts := retry.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	idempotent := method == http.MethodGet || idempotentPaths[path]
+
+	var lastErr error
+	var lastResp *http.Response
+	for _, backend := range backends {
+		breaker := breakerFor(backend.BaseURL, config.Service.CircuitBreaker)
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			if !breaker.allow() {
+				lastErr = fmt.Errorf("后端 %s 已熔断", backend.Name)
+				break
+			}
+			if attempt > 0 {
+				if err := sleepContext(ctx, backoff(retry, attempt)); err != nil {
+					if lastResp != nil {
+						lastResp.Body.Close()
+					}
+					return nil, err
+				}
+			}
+
+			resp, err := sendToBackend(ctx, config, backend, method, path, body, header)
+			if err == nil && resp.StatusCode < http.StatusInternalServerError && !streamFailed(resp) {
+				breaker.success()
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				return resp, nil
+			}
+			// 调用方取消请求不计入后端失败
+			if ctx.Err() != nil {
+				breaker.release()
+				if resp != nil {
+					resp.Body.Close()
+				}
+				if lastResp != nil {
+					lastResp.Body.Close()
+				}
+				return nil, ctx.Err()
+			}
+			breaker.failure()
+
+			if err != nil {
+				lastErr = err
+				// 非幂等请求只在连接未建立时重试
+				if !idempotent && !isDialError(err) {
+					return nil, err
+				}
+				continue
+			}
+
+			// 5xx响应或流的第一行为错误：非幂等请求直接返回给调用方
+			if !idempotent {
+				return resp, nil
+			}
+			if lastResp != nil {
+				lastResp.Body.Close()
+			}
+			lastResp, err = bufferResponse(resp)
+			if err != nil {
+				lastErr = err
+				lastResp = nil
+			}
+		}
+	}
+
+	// 所有后端均失败时返回最后一次收到的5xx响应
+	if lastResp != nil {
+		return lastResp, nil
+	}
+	if lastErr == nil {
+		lastErr = errors.New("没有可用的上游服务")
+	}
+	return nil, lastErr
+}
+
+// sendToBackend 向指定后端发送一次请求
+func sendToBackend(ctx context.Context, config *Config, backend BackendConfig, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	// OpenAI兼容后端不支持Ollama接口，需要转换请求和响应
+	if isOpenAIBackend(backend) && strings.HasPrefix(path, "/api/") {
+		return sendTranslated(ctx, config, backend, method, path, body, header)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, backend.BaseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	applyUpstreamHeaders(config.Service.Headers, req.Header)
+	if err := applyUpstreamAuth(backendAuth(config, backend), req); err != nil {
+		return nil, fmt.Errorf("后端 %s 的认证配置无效: %v", backend.Name, err)
+	}
+
+	// 响应体关闭前都计入后端正在处理的请求数
+	done := beginInFlight(backend.BaseURL)
+	resp, err := roundTrip(ctx, config, backend, req, body)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	resp.Body = &inFlightBody{ReadCloser: resp.Body, done: done}
+	return resp, nil
+}
+
+// roundTrip 按流量录制模式发送请求
+func roundTrip(ctx context.Context, config *Config, backend BackendConfig, req *http.Request, body []byte) (*http.Response, error) {
+	// 回放模式下不访问上游服务
+	traffic := config.Service.Traffic
+	if traffic.Mode == trafficReplay {
+		return replayExchange(ctx, traffic, req, body)
+	}
+
+	client, err := upstreamClient(backendTLS(config, backend))
+	if err != nil {
+		return nil, err
+	}
+	switch traffic.Mode {
+	case "":
+		return client.Do(req)
+	case trafficRecord:
+		return recordExchange(traffic, client, req, body)
+	default:
+		return nil, fmt.Errorf("不支持的流量录制模式: %s", traffic.Mode)
+	}
+}
+
+// backendRequests 按后端地址统计正在处理的请求数
+var backendRequests sync.Map
+
+// beginInFlight 增加后端正在处理的请求数，返回的函数用于在请求结束时减少计数
+func beginInFlight(baseURL string) func() {
+	value, _ := backendRequests.LoadOrStore(baseURL, new(int64))
+	counter := value.(*int64)
+	atomic.AddInt64(counter, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(counter, -1) })
+	}
+}
+
+// inFlightRequests 返回后端正在处理的请求数
+func inFlightRequests(baseURL string) int64 {
+	if value, ok := backendRequests.Load(baseURL); ok {
+		return atomic.LoadInt64(value.(*int64))
+	}
+	return 0
+}
+
+// inFlightBody 关闭时结束请求计数
+type inFlightBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b *inFlightBody) Close() error {
+	b.done()
+	return b.ReadCloser.Close()
+}
+
+// bufferResponse 读取完整响应体，以便关闭连接后仍能返回给调用方
+func bufferResponse(resp *http.Response) (*http.Response, error) {
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return resp, nil
+}
+
+// streamFailed 读取NDJSON流的第一行，判断上游是否在200响应中返回了错误
+// 已读取的内容会放回响应体，调用方仍能读取完整的流
+func streamFailed(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "ndjson") {
+		return false
+	}
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadBytes('\n')
+	resp.Body = &peekedBody{Reader: io.MultiReader(bytes.NewReader(line), reader), Closer: resp.Body}
+	if err != nil && err != io.EOF {
+		// 第一行之前连接就已中断
+		return true
+	}
+	var first struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(line, &first) == nil && first.Error != ""
+}
+
+// peekedBody 读取过开头部分的响应体
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// isDialError 判断错误是否发生在建立连接阶段，此时请求一定没有发出
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// backoff 计算带抖动的指数退避时间
+func backoff(retry RetryConfig, attempt int) time.Duration {
+	initial := retry.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	maxBackoff := retry.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	d := initial << uint(attempt-1)
+	if d <= 0 || d > maxBackoff {
+		d = maxBackoff
+	}
+	// 在[d/2, d)范围内随机抖动，避免多个请求同时重试
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 熔断器状态
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker 单个后端的熔断器
+type circuitBreaker struct {
+	mu       sync.Mutex
+	config   CircuitBreakerConfig
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakers 按后端地址保存的熔断器
+var breakers sync.Map
+
+func breakerFor(baseURL string, cfg CircuitBreakerConfig) *circuitBreaker {
+	value, _ := breakers.LoadOrStore(baseURL, &circuitBreaker{state: breakerClosed})
+	b := value.(*circuitBreaker)
+	b.mu.Lock()
+	b.config = cfg
+	b.mu.Unlock()
+	return b
+}
+
+// allow 判断是否允许发送请求，熔断冷却结束后只放行一个探测请求
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.config.FailureThreshold <= 0 {
+		return true
+	}
+	switch b.state {
+	case breakerOpen:
+		cooldown := b.config.Cooldown
+		if cooldown <= 0 {
+			cooldown = defaultCooldown
+		}
+		if time.Since(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = breakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if b.config.FailureThreshold <= 0 {
+		return
+	}
+	if b.state == breakerHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// snapshot 返回熔断器当前的状态和连续失败次数
+func (b *circuitBreaker) snapshot() (string, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state, b.failures
+}
+
+// release 探测请求被调用方取消时释放探测名额
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UpstreamAuthConfig 访问上游时使用的认证信息
+type UpstreamAuthConfig struct {
+	// Type bearer / basic，为空时只添加headers
+	Type string `yaml:"type"`
+	// Token bearer认证使用的token
+	Token Secret `yaml:"token"`
+	// Username、Password basic认证使用的用户名和密码
+	Username string `yaml:"username"`
+	Password Secret `yaml:"password"`
+	// Headers 额外添加的请求头，例如托管服务要求的API Key
+	Headers map[string]Secret `yaml:"headers"`
+}
+
+const (
+	upstreamAuthBearer = "bearer"
+	upstreamAuthBasic  = "basic"
+)
+
+// Secret 密钥配置，可以直接写在配置文件中，也可以从环境变量或文件中读取：
+//
+//	token: "sk-xxx"
+//	token: {env: "VLLM_TOKEN"}
+//	token: {file: "/run/secrets/vllm-token"}
+type Secret struct {
+	Value string `yaml:"value"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+}
+
+// UnmarshalYAML 支持直接使用字符串作为密钥
+func (s *Secret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Value)
+	}
+	type plain Secret
+	return node.Decode((*plain)(s))
+}
+
+// IsZero 是否没有配置密钥
+func (s Secret) IsZero() bool {
+	return s.Value == "" && s.Env == "" && s.File == ""
+}
+
+// Resolve 读取密钥，每次请求时重新读取，环境变量和文件更新后立即生效
+func (s Secret) Resolve() (string, error) {
+	switch {
+	case s.Env != "":
+		value, ok := os.LookupEnv(s.Env)
+		if !ok {
+			return "", fmt.Errorf("环境变量 %s 未设置", s.Env)
+		}
+		return value, nil
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("无法读取密钥文件: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		return s.Value, nil
+	}
+}
+
+// backendAuth 返回后端使用的认证配置
+func backendAuth(config *Config, backend BackendConfig) UpstreamAuthConfig {
+	if backend.Auth != nil {
+		return *backend.Auth
+	}
+	return config.Service.Auth
+}
+
+// applyUpstreamAuth 将上游认证信息添加到请求中
+func applyUpstreamAuth(auth UpstreamAuthConfig, req *http.Request) error {
+	for name, secret := range auth.Headers {
+		value, err := secret.Resolve()
+		if err != nil {
+			return fmt.Errorf("上游请求头 %s: %v", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+
+	switch strings.ToLower(auth.Type) {
+	case "":
+		return nil
+	case upstreamAuthBearer:
+		if auth.Token.IsZero() {
+			return errors.New("bearer认证缺少token")
+		}
+		token, err := auth.Token.Resolve()
+		if err != nil {
+			return fmt.Errorf("上游认证token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case upstreamAuthBasic:
+		password, err := auth.Password.Resolve()
+		if err != nil {
+			return fmt.Errorf("上游认证密码: %v", err)
+		}
+		req.SetBasicAuth(auth.Username, password)
+	default:
+		return fmt.Errorf("不支持的上游认证类型: %s", auth.Type)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSecretUnmarshal(t *testing.T) {
+	var auth UpstreamAuthConfig
+	err := yaml.Unmarshal([]byte(`
+type: bearer
+token: {env: VLLM_TOKEN}
+password: plain
+headers:
+  X-Api-Key: {file: /run/secrets/key}
+`), &auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.Token.Env != "VLLM_TOKEN" || auth.Password.Value != "plain" || auth.Headers["X-Api-Key"].File != "/run/secrets/key" {
+		t.Errorf("auth = %+v", auth)
+	}
+}
+
+func TestUpstreamAuthPerBackend(t *testing.T) {
+	bearer := newFakeOllama(t)
+	basic := newFakeOllama(t)
+	bearer.script("/api/chat", fakeReply{Status: http.StatusInternalServerError, Body: `{"error":"busy"}`})
+
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_UPSTREAM_TOKEN", "managed-token")
+	proxy := newTestProxy(t, fmt.Sprintf(`auth:
+  generate_tokens: [%q]
+service:
+  auth:
+    headers:
+      X-Api-Key: "shared-key"
+  backends:
+    - name: managed
+      base_url: %q
+      auth:
+        type: bearer
+        token: {env: TEST_UPSTREAM_TOKEN}
+    - name: nginx
+      base_url: %q
+      auth:
+        type: basic
+        username: proxy
+        password: {file: %q}
+`, testToken, bearer.URL(), basic.URL(), passwordFile))
+
+	// 流式请求在主后端返回5xx后故障转移到备用后端，两个后端分别使用自己的认证信息
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	chatChunks(t, readSSE(t, resp.Body))
+
+	if got := bearer.received("/api/chat")[0].Header.Get("Authorization"); got != "Bearer managed-token" {
+		t.Errorf("managed Authorization = %q", got)
+	}
+	received := basic.received("/api/chat")
+	if len(received) != 1 {
+		t.Fatalf("nginx received %d requests", len(received))
+	}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("proxy:s3cret"))
+	if got := received[0].Header.Get("Authorization"); got != want {
+		t.Errorf("nginx Authorization = %q, want %q", got, want)
+	}
+	// 后端配置了auth时不使用service.auth
+	if got := received[0].Header.Get("X-Api-Key"); got != "" {
+		t.Errorf("X-Api-Key = %q，后端的auth应替代service.auth", got)
+	}
+}
+
+func TestUpstreamAuthSharedHeaders(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`  auth:
+    headers:
+      X-Api-Key: "shared-key"
+`)
+
+	doRequest(t, proxy, http.MethodGet, "/api/tags", "")
+	doRequest(t, proxy, http.MethodPost, "/v1/embeddings", `{"model":"qwen2.5:7b","input":"hi"}`)
+	for _, path := range []string{"/api/tags", "/api/embed"} {
+		received := fake.received(path)
+		if len(received) != 1 || received[0].Header.Get("X-Api-Key") != "shared-key" {
+			t.Errorf("%s 应带有service.auth中的请求头", path)
+		}
+	}
+}
+
+func TestUpstreamAuthMissingSecret(t *testing.T) {
+	fake := newFakeOllama(t)
+	proxy := newTestProxy(t, testConfig(fake.URL())+`  auth:
+    type: bearer
+    token: {env: TEST_UPSTREAM_TOKEN_UNSET}
+`)
+
+	resp := doRequest(t, proxy, http.MethodGet, "/api/tags", "")
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+	if len(fake.received("/api/tags")) != 0 {
+		t.Errorf("缺少密钥时不应发送请求")
+	}
+}
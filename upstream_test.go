@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backendsConfig 返回按顺序配置多个后端的代理配置
+func backendsConfig(extra string, urls ...string) string {
+	config := fmt.Sprintf("auth:\n  generate_tokens: [%q]\nservice:\n  backends:\n", testToken)
+	for i, url := range urls {
+		config += fmt.Sprintf("    - name: backend-%d\n      base_url: %q\n", i, url)
+	}
+	return config + extra
+}
+
+func TestBackoff(t *testing.T) {
+	retry := RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: 300 * time.Millisecond} {
+		for i := 0; i < 20; i++ {
+			if got := backoff(retry, attempt); got < want/2 || got > want {
+				t.Errorf("backoff(%d) = %v, want [%v, %v]", attempt, got, want/2, want)
+			}
+		}
+	}
+}
+
+func TestRetrySameBackend(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/chat", fakeReply{Status: http.StatusServiceUnavailable, Body: `{"error":"busy"}`})
+	proxy := newTestProxy(t, testConfig(fake.URL())+"  retry:\n    max_attempts: 2\n    initial_backoff: 1ms\n")
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/chat",
+		`{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := len(fake.received("/api/chat")); got != 2 {
+		t.Errorf("Ollama收到 %d 个请求, want 2", got)
+	}
+}
+
+func TestNonIdempotentNotRetried(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/pull", fakeReply{Status: http.StatusInternalServerError, Body: `{"error":"disk full"}`})
+	proxy := newTestProxy(t, testConfig(fake.URL())+"  retry:\n    max_attempts: 3\n    initial_backoff: 1ms\n")
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/pull", `{"model":"qwen2.5:7b","stream":false}`)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", resp.StatusCode)
+	}
+	if got := len(fake.received("/api/pull")); got != 1 {
+		t.Errorf("非幂等请求收到5xx后不应重试, Ollama收到 %d 个请求", got)
+	}
+}
+
+func TestFailoverOnStreamError(t *testing.T) {
+	primary := newFakeOllama(t)
+	secondary := newFakeOllama(t)
+	primary.script("/api/chat", fakeReply{Chunks: []fakeChunk{{Line: `{"error":"llama runner process has terminated"}`}}})
+	proxy := newTestProxy(t, backendsConfig("", primary.URL(), secondary.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/v1/chat/completions",
+		`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	var content string
+	for _, chunk := range chatChunks(t, readSSE(t, resp.Body)) {
+		for _, choice := range chunk.Choices {
+			content += choice.Delta.Content
+		}
+	}
+	if content != "echo: hi" {
+		t.Errorf("content = %q, want %q", content, "echo: hi")
+	}
+	if len(primary.received("/api/chat")) != 1 || len(secondary.received("/api/chat")) != 1 {
+		t.Errorf("流的第一行为错误时应故障转移到备用后端")
+	}
+}
+
+func TestStreamErrorPassedThroughWhenAllFail(t *testing.T) {
+	fake := newFakeOllama(t)
+	fake.script("/api/generate", fakeReply{Chunks: []fakeChunk{{Line: `{"error":"model requires more system memory"}`}}})
+	proxy := newTestProxy(t, testConfig(fake.URL()))
+
+	resp := doRequest(t, proxy, http.MethodPost, "/api/generate", `{"model":"qwen2.5:7b","prompt":"hi"}`)
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if !strings.Contains(line, "model requires more system memory") {
+		t.Errorf("没有可用后端时应返回上游的错误, got %q", line)
+	}
+}
+
+func TestCircuitBreakerSkipsBackend(t *testing.T) {
+	primary := newFakeOllama(t)
+	secondary := newFakeOllama(t)
+	primary.script("/api/chat", fakeReply{Status: http.StatusInternalServerError, Body: `{"error":"out of memory"}`})
+	proxy := newTestProxy(t, backendsConfig("  circuit_breaker:\n    failure_threshold: 1\n    cooldown: 1h\n", primary.URL(), secondary.URL()))
+
+	body := `{"model":"qwen2.5:7b","stream":false,"messages":[{"role":"user","content":"hi"}]}`
+	for i := 0; i < 2; i++ {
+		if resp := doRequest(t, proxy, http.MethodPost, "/api/chat", body); resp.StatusCode != http.StatusOK {
+			t.Errorf("第%d次请求 status = %d, want 200", i+1, resp.StatusCode)
+		}
+	}
+	// 熔断后的请求不再发送到主后端
+	if got := len(primary.received("/api/chat")); got != 1 {
+		t.Errorf("主后端收到 %d 个请求, want 1", got)
+	}
+	if got := len(secondary.received("/api/chat")); got != 2 {
+		t.Errorf("备用后端收到 %d 个请求, want 2", got)
+	}
+	if state, _ := breakerFor(primary.URL(), CircuitBreakerConfig{}).snapshot(); state != breakerOpen {
+		t.Errorf("主后端熔断器状态 = %s, want open", state)
+	}
+}
+
+func TestClientDisconnectCancelsUpstream(t *testing.T) {
+	fake := newFakeOllama(t)
+	// 模拟加载模型和处理prompt耗时较长，上游迟迟没有返回响应头
+	fake.script("/api/chat", fakeReply{Delay: 3 * time.Second, Chunks: []fakeChunk{{Line: chatLine("qwen2.5:7b", "late", true)}}})
+	proxy := newTestProxy(t, testConfig(fake.URL())+"  circuit_breaker:\n    failure_threshold: 1\n")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/v1/chat/completions",
+		strings.NewReader(`{"model":"qwen2.5:7b","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatalf("请求应超时")
+	}
+
+	// 上游请求随调用方一起结束，不必等到上游返回
+	deadline := time.Now().Add(time.Second)
+	for inFlightRequests(fake.URL()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("调用方断开后上游请求仍在进行")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state, failures := breakerFor(fake.URL(), CircuitBreakerConfig{}).snapshot(); state != breakerClosed || failures != 0 {
+		t.Errorf("调用方取消不应计入后端失败: state=%s failures=%d", state, failures)
+	}
+}